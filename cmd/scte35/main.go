@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/leonlinc/mpts/internal"
+)
+
+var textFlag = flag.Bool("text", true, "print the decoded fields")
+var jsonFlag = flag.Bool("json", true, "print the section as JSON")
+var xmlFlag = flag.Bool("xml", false, "print the section as SCTE-35 XML")
+var cwFlag = flag.String("cw", "", "control word file for encrypted SCTE-35 sections")
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: scte35 [flags] decode [base64|hex...]\n")
+	fmt.Fprintf(os.Stderr, "Reads one payload per line from stdin if none is given.\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if *cwFlag != "" {
+		if err := mpts.LoadControlWords(*cwFlag); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	args := flag.Args()
+	if len(args) < 1 || args[0] != "decode" {
+		usage()
+		os.Exit(1)
+	}
+
+	payloads := args[1:]
+	if len(payloads) == 0 {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" {
+				payloads = append(payloads, line)
+			}
+		}
+	}
+
+	failed := false
+	for i, payload := range payloads {
+		if i > 0 {
+			fmt.Println()
+		}
+		if err := decode(payload); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", payload, err)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// parsePayload accepts hex, with or without a 0x prefix, or base64 in the
+// standard or URL alphabet.
+func parsePayload(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	h := strings.TrimPrefix(strings.TrimPrefix(s, "0x"), "0X")
+	h = strings.Map(func(r rune) rune {
+		if r == ' ' || r == ':' {
+			return -1
+		}
+		return r
+	}, h)
+	if data, err := hex.DecodeString(h); err == nil {
+		return data, nil
+	}
+	for _, enc := range []*base64.Encoding{
+		base64.StdEncoding, base64.RawStdEncoding,
+		base64.URLEncoding, base64.RawURLEncoding,
+	} {
+		if data, err := enc.DecodeString(s); err == nil {
+			return data, nil
+		}
+	}
+	return nil, fmt.Errorf("neither hex nor base64")
+}
+
+func decode(payload string) (err error) {
+	data, err := parsePayload(payload)
+	if err != nil {
+		return err
+	}
+	if len(data) < 3 {
+		return fmt.Errorf("too short for a splice_info_section")
+	}
+
+	// The parser panics on truncated input
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("malformed section: %v", r)
+		}
+	}()
+
+	var section *mpts.SpliceInfoSection
+	if data[0] != 0xFC && int(data[0])+1 < len(data) && data[int(data[0])+1] == 0xFC {
+		// Copied from a TS payload with pointer_field
+		section = mpts.ParseSpliceInfoSection(data)
+	} else if data[0] == 0xFC {
+		section = mpts.DecodeSpliceInfoSection(data)
+	} else {
+		return fmt.Errorf("table_id 0x%02X is not a splice_info_section", data[0])
+	}
+
+	if *textFlag {
+		section.Dump(os.Stdout)
+	}
+	if *jsonFlag {
+		b, err := json.MarshalIndent(section, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(b))
+	}
+	if *xmlFlag {
+		b, err := xml.MarshalIndent(section, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(b))
+	}
+	return nil
+}
@@ -9,7 +9,10 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
+
+	"github.com/leonlinc/mpts/internal"
 )
 
 const (
@@ -21,9 +24,30 @@ var dumpFlag = flag.Bool("dump", false, "dump TS file")
 var loopFlag = flag.Bool("loop", true, "loop the source")
 var outFile = flag.String("out", "out.ts", "output file name")
 var sendFlag = flag.Bool("send", false, "send TS file")
+var extractFlag = flag.Bool("extract", false, "extract elementary streams")
+var timestampFlag = flag.Bool("timestamps", false, "log PTS/DTS of extracted streams")
+var remuxFlag = flag.Bool("remux", false, "filter and remap PIDs into the output file")
+var programFlag = flag.Int("program", 0, "program number to keep when remuxing")
+var keepFlag = flag.String("keep", "", "comma separated PIDs to keep when remuxing")
+var dropFlag = flag.String("drop", "", "comma separated PIDs to drop when remuxing")
+var remapFlag = flag.String("remap", "", "comma separated old:new PID pairs when remuxing")
+var nullFlag = flag.Bool("nulls", true, "replace dropped packets with null packets")
+var trimFlag = flag.Bool("trim", false, "cut a range of packets into the output file")
+var unitFlag = flag.String("unit", "pkt", "trim unit: pkt, byte, pcr or pts")
+var startFlag = flag.Float64("start", 0, "trim start, in seconds for pcr and pts")
+var endFlag = flag.Float64("end", -1, "trim end, in seconds for pcr and pts, -1 for end of file")
+var pidFlag = flag.Int("pid", 0, "PID of the PTS (or PCR) used for trimming")
+var cwFlag = flag.String("cw", "", "control word file for encrypted SCTE-35 sections")
+var prerollFlag = flag.Float64("preroll", mpts.MinSplicePreroll, "minimum SCTE-35 pre-roll in seconds")
 
 func main() {
 	flag.Parse()
+	if *cwFlag != "" {
+		if err := mpts.LoadControlWords(*cwFlag); err != nil {
+			log.Fatalln(err)
+		}
+	}
+	mpts.MinSplicePreroll = *prerollFlag
 	args := flag.Args()
 
 	if *dumpFlag {
@@ -39,6 +63,38 @@ func main() {
 			os.Exit(1)
 		}
 		send(args[0], args[1], args[2], args[3], args[4])
+	} else if *extractFlag {
+		if len(args) < 1 {
+			fmt.Printf("Usage: tsparser -extract [-timestamps] file [pid...]\n")
+			os.Exit(1)
+		}
+		var pids []int
+		for _, arg := range args[1:] {
+			pids = append(pids, parsePid(arg))
+		}
+		extractFile(args[0], pids)
+	} else if *remuxFlag {
+		if len(args) != 1 {
+			fmt.Printf("Usage: tsparser -remux [-program n] [-keep pids] [-drop pids] [-remap old:new,...] [-out file] file\n")
+			os.Exit(1)
+		}
+		remuxFile(args[0], *outFile)
+	} else if *trimFlag {
+		if len(args) != 1 {
+			fmt.Printf("Usage: tsparser -trim [-unit pkt|byte|pcr|pts] [-start n] [-end n] [-pid pid] [-out file] file\n")
+			os.Exit(1)
+		}
+		switch *unitFlag {
+		case mpts.TrimUnitPacket, mpts.TrimUnitByte, mpts.TrimUnitPcr:
+		case mpts.TrimUnitPts:
+			if *pidFlag == 0 {
+				log.Fatalln("trimming by PTS requires -pid")
+			}
+		default:
+			log.Fatalln("invalid trim unit:", *unitFlag)
+		}
+		opt := mpts.TrimOptions{Unit: *unitFlag, Start: *startFlag, End: *endFlag, Pid: *pidFlag}
+		trim(args[0], *outFile, opt)
 	} else {
 		if len(args) != 1 {
 			fmt.Printf("Usage: tsparser [options] arguments\n")
@@ -157,3 +213,50 @@ func parseFile(input string) {
 	os.Mkdir(outdir, os.ModeDir|0755)
 	parse(input, outdir, false)
 }
+
+func extractFile(input string, pids []int) {
+	outdir := filepath.Base(input) + ".es"
+	os.Mkdir(outdir, os.ModeDir|0755)
+	extract(input, outdir, pids, *timestampFlag)
+}
+
+func remuxFile(input, output string) {
+	opt := mpts.RemuxOptions{
+		Program:  *programFlag,
+		Keep:     parsePidSet(*keepFlag),
+		Drop:     parsePidSet(*dropFlag),
+		Remap:    make(map[int]int),
+		NullFill: *nullFlag,
+	}
+	for _, pair := range splitList(*remapFlag) {
+		fields := strings.Split(pair, ":")
+		if len(fields) != 2 {
+			log.Fatalln("invalid remap:", pair)
+		}
+		opt.Remap[parsePid(fields[0])] = parsePid(fields[1])
+	}
+	remux(input, output, opt)
+}
+
+func splitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+func parsePid(s string) int {
+	pid, err := strconv.ParseInt(strings.TrimSpace(s), 0, 32)
+	if err != nil || pid < 0 || pid > 0x1FFF {
+		log.Fatalln("invalid PID:", s)
+	}
+	return int(pid)
+}
+
+func parsePidSet(s string) map[int]bool {
+	pids := make(map[int]bool)
+	for _, field := range splitList(s) {
+		pids[parsePid(field)] = true
+	}
+	return pids
+}
@@ -1,13 +1,13 @@
 package main
 
 import (
+	"bufio"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
+	"log"
 	"os"
 	"path/filepath"
 	"strconv"
-	"strings"
 
 	"github.com/leonlinc/mpts/internal"
 )
@@ -23,20 +23,7 @@ func parse(fname string, outdir string, psiOnly bool) {
 	var progPcrList = make(map[int][]mpts.PcrInfo)
 	var extraPcrList = make(map[int][]mpts.PcrInfo)
 
-	pkts = mpts.ParseFile(fname)
-	psiParser := mpts.NewPsiParser()
-	psiParseDone := false
-	for pkt := range pkts {
-		if ok := psiParser.Parse(pkt); ok {
-			psiParseDone = true
-			break
-		}
-	}
-	// TODO: non existent PMT will not trigger ParseDone automatically
-	if psiParseDone == false {
-		psiParser.ParseDone()
-	}
-	psiParser.Finish()
+	psiParser := parsePsi(fname)
 	psiParser.Report(outdir)
 
 	if psiOnly {
@@ -63,7 +50,7 @@ func parse(fname string, outdir string, psiOnly bool) {
 				// Save the PCR value
 				progPcrList[pkt.Pid] = append(
 					progPcrList[pkt.Pid],
-					mpts.PcrInfo{pkt.Pos, pcr})
+					mpts.PcrInfo{Pos: pkt.Pos, Pcr: pcr})
 				for _, pid := range pids {
 					records[pid].NotifyTime(pcr, pkt.Pos)
 				}
@@ -74,7 +61,7 @@ func parse(fname string, outdir string, psiOnly bool) {
 				}
 				extraPcrList[pkt.Pid] = append(
 					extraPcrList[pkt.Pid],
-					mpts.PcrInfo{pkt.Pos, pcr})
+					mpts.PcrInfo{Pos: pkt.Pos, Pcr: pcr})
 			}
 		}
 
@@ -96,92 +83,133 @@ func parse(fname string, outdir string, psiOnly bool) {
 		record.Report(outdir)
 	}
 
-	// verify(psiParser.Info)
+	verify(psiParser.Info, records)
 }
 
-func extract(fname string, outdir string, pid int) {
-	var pkts chan *mpts.TsPkt
-	pkts = mpts.ParseFile(fname)
-
-	of := filepath.Join(outdir, strconv.Itoa(pid)+".es")
-	f, err := os.Create(of)
-	if err != nil {
-		panic(err)
-	}
-	defer f.Close()
-
+func parsePsi(fname string) *mpts.PsiParser {
+	pkts := mpts.ParseFile(fname)
+	psiParser := mpts.NewPsiParser()
+	psiParseDone := false
 	for pkt := range pkts {
-		if pkt.Pid == pid {
-			f.Write(pkt.Data)
+		if ok := psiParser.Parse(pkt); ok {
+			psiParseDone = true
+			break
 		}
 	}
+	// TODO: non existent PMT will not trigger ParseDone automatically
+	if psiParseDone == false {
+		psiParser.ParseDone()
+	}
+	psiParser.Finish()
+	return psiParser
 }
 
-func verify(psiInfo mpts.Info) {
-	result := map[string]interface{}{}
-	for _, prog := range psiInfo.Programs {
-		result["splice-frame-accuracy"] = verifySpliceFrameAccuracy(prog)
+// extract writes the elementary streams of the given PIDs, or of all PIDs
+// listed in the PMTs when pids is empty.
+func extract(fname string, outdir string, pids []int, timestamps bool) {
+	psiParser := parsePsi(fname)
+	streams := psiParser.GetStreams()
+	if len(pids) == 0 {
+		for pid, _ := range streams {
+			pids = append(pids, pid)
+		}
 	}
-	logJson("verified", result)
-}
 
-func verifySpliceFrameAccuracy(prog mpts.Program) map[string]interface{} {
-	sctePids, videoPid := []string{}, ""
-	for pid, strm := range prog.Streams {
-		switch strm.StreamType {
-		case "SCTE-35":
-			sctePids = append(sctePids, pid)
-		case "MPEG-2 Video", "MPEG-4 Video", "MPEG-4 AVC Video":
-			videoPid = pid
+	extractors := make(map[int]*mpts.EsExtractor)
+	for _, pid := range pids {
+		t := "Unknown stream type"
+		if s, ok := streams[pid]; ok {
+			t = mpts.GetStreamType(s)
 		}
+		extractors[pid] = mpts.NewEsExtractor(pid, t, outdir, timestamps)
 	}
 
-	result := map[string]interface{}{}
-	for _, sctePid := range sctePids {
-		pair := sctePid + ":" + videoPid
-		result[pair] = verifySpliceWithKeyframe(sctePid, videoPid)
+	pkts := mpts.ParseFile(fname)
+	for pkt := range pkts {
+		if e, ok := extractors[pkt.Pid]; ok {
+			e.Process(pkt)
+		}
+	}
+
+	for _, e := range extractors {
+		e.Close()
 	}
-	return result
 }
 
-func verifySpliceWithKeyframe(sctePid, videoPid string) map[string]bool {
-	splice := map[string]bool{}
-	iframe := map[string]bool{}
+func remux(fname string, output string, opt mpts.RemuxOptions) {
+	psiParser := parsePsi(fname)
 
-	parseCsv(sctePid, func(fields []string) {
-		if len(fields) > 3 {
-			pts, _ := strconv.ParseInt(fields[3], 10, 64)
-			adj, _ := strconv.ParseInt(fields[4], 10, 64)
-			key := strconv.FormatInt(pts+adj, 10)
-			splice[key] = false
-		}
-	})
+	f, err := os.Create(output)
+	check(err)
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	defer w.Flush()
 
-	parseCsv(videoPid+"-iframe", func(fields []string) {
-		if len(fields) > 1 {
-			iframe[fields[1]] = false
+	m, err := mpts.NewRemuxer(psiParser, opt, w)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	pkts := mpts.ParseFile(fname)
+	for pkt := range pkts {
+		if err := m.Process(pkt); err != nil {
+			log.Fatalln(err)
 		}
-	})
+	}
+}
+
+func trim(fname string, output string, opt mpts.TrimOptions) {
+	psiParser := parsePsi(fname)
+	start, end := mpts.FindTrimRange(fname, psiParser, opt)
+	log.Println("trim packets from", start, "to", end)
 
-	for pts, _ := range splice {
-		if _, ok := iframe[pts]; ok {
-			splice[pts] = true
+	f, err := os.Create(output)
+	check(err)
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	defer w.Flush()
+
+	mpts.Trim(fname, psiParser, start, end, w)
+}
+
+// verify checks that every splice point of each program lands on an
+// I-frame of its video stream.
+func verify(psiInfo mpts.Info, records map[int]mpts.Record) {
+	result := map[string]interface{}{}
+	accuracy := map[string]interface{}{}
+	for _, prog := range psiInfo.Programs {
+		for pair, checks := range verifySpliceFrameAccuracy(prog, records) {
+			accuracy[pair] = checks
 		}
 	}
-
-	return splice
+	result["splice-frame-accuracy"] = accuracy
+	logJson("verified", result)
 }
 
-func parseCsv(filename string, handle func([]string)) {
-	content, err := ioutil.ReadFile(filepath.Join(Root, filename+".csv"))
-	check(err)
+func verifySpliceFrameAccuracy(prog mpts.Program, records map[int]mpts.Record) map[string][]mpts.SpliceCheck {
+	sctePids, videoPid := []int{}, -1
+	for _, strm := range prog.Streams {
+		switch {
+		case strm.StreamType == "SCTE-35":
+			sctePids = append(sctePids, strm.Pid)
+		case mpts.IsVideoStreamType(strm.StreamType):
+			if videoPid < 0 || strm.Pid < videoPid {
+				videoPid = strm.Pid
+			}
+		}
+	}
 
-	lines := strings.Split(string(content), "\n")
-	lines = lines[1:]
-	for _, line := range lines {
-		fields := strings.Split(line, ", ")
-		handle(fields)
+	result := map[string][]mpts.SpliceCheck{}
+	video, ok := records[videoPid]
+	if !ok {
+		return result
 	}
+	for _, sctePid := range sctePids {
+		if scte, ok := records[sctePid].(*mpts.Scte35Record); ok {
+			pair := strconv.Itoa(sctePid) + ":" + strconv.Itoa(videoPid)
+			result[pair] = mpts.VerifySplices(scte, video)
+		}
+	}
+	return result
 }
 
 func logJson(filename string, v interface{}) {
@@ -0,0 +1,118 @@
+package mpts
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// active_format, SMPTE ST 2016-1 Table 1 and ETSI TS 101 154 Table B.3
+var AfdString = map[int]string{
+	2:  "Box 16:9 (top)",
+	3:  "Box 14:9 (top)",
+	4:  "Box > 16:9 (centre)",
+	8:  "As the coded frame",
+	9:  "4:3 (centre)",
+	10: "16:9 (centre)",
+	11: "14:9 (centre)",
+	13: "4:3 with shoot and protect 14:9 centre",
+	14: "16:9 with shoot and protect 14:9 centre",
+	15: "16:9 with shoot and protect 4:3 centre",
+}
+
+// AfdChange is the AFD and bar data in effect from a picture on. Afd is nil
+// when no active_format is signalled, Bar when no bar data was received.
+type AfdChange struct {
+	Pos         int64
+	Pts         int64
+	Afd         *int     `json:",omitempty"`
+	Description string   `json:",omitempty"`
+	Bar         *BarData `json:",omitempty"`
+}
+
+// AfdTimeline records the changes of the AFD and bar data of a video PID.
+// Both persist until the next afd_data or bar_data.
+type AfdTimeline struct {
+	Changes []AfdChange
+	afd     *int
+	bar     *BarData
+}
+
+// Add accounts the ATSC user data of the picture at pos.
+func (t *AfdTimeline) Add(pos int64, pts int64, u *A53UserData) {
+	if u.Afd == nil && u.Bar == nil {
+		return
+	}
+	afd, bar := t.afd, t.bar
+	if u.Afd != nil {
+		afd = nil
+		if u.Afd.ActiveFormatFlag {
+			format := u.Afd.ActiveFormat
+			afd = &format
+		}
+	}
+	if u.Bar != nil {
+		bar = u.Bar
+	}
+	afdChanged := (afd == nil) != (t.afd == nil) || afd != nil && *afd != *t.afd
+	barChanged := (bar == nil) != (t.bar == nil) || bar != nil && *bar != *t.bar
+	if len(t.Changes) > 0 && !afdChanged && !barChanged {
+		return
+	}
+	t.afd, t.bar = afd, bar
+	change := AfdChange{Pos: pos, Pts: pts, Afd: afd, Bar: bar}
+	if afd != nil {
+		change.Description = AfdString[*afd]
+	}
+	// Bar data and AFD of the same picture make one change
+	if n := len(t.Changes); n > 0 && t.Changes[n-1].Pos == pos && t.Changes[n-1].Pts == pts {
+		t.Changes[n-1] = change
+		return
+	}
+	t.Changes = append(t.Changes, change)
+}
+
+// barPosition formats a bar position, empty when the bar is not signalled.
+func barPosition(flag bool, pos int) string {
+	if !flag {
+		return ""
+	}
+	return strconv.Itoa(pos)
+}
+
+// Report writes the changes to <pid>-afd.csv.
+func (t *AfdTimeline) Report(root string, pid int) {
+	if len(t.Changes) == 0 {
+		return
+	}
+	fname := filepath.Join(root, strconv.Itoa(pid)+"-afd.csv")
+	w, err := os.Create(fname)
+	if err != nil {
+		panic(err)
+	}
+	defer w.Close()
+	fmt.Fprintln(w, "Pos, PTS, AFD, Description, TopBarEnd, BottomBarStart, LeftBarEnd, RightBarStart")
+	for _, c := range t.Changes {
+		afd := ""
+		if c.Afd != nil {
+			afd = strconv.Itoa(*c.Afd)
+		}
+		bar := c.Bar
+		if bar == nil {
+			bar = &BarData{}
+		}
+		cols := []string{
+			strconv.FormatInt(c.Pos, 10),
+			formatTimestamp(c.Pts),
+			afd,
+			c.Description,
+			barPosition(bar.TopBarFlag, bar.LineNumberEndOfTopBar),
+			barPosition(bar.BottomBarFlag, bar.LineNumberStartOfBottomBar),
+			barPosition(bar.LeftBarFlag, bar.PixelNumberEndOfLeftBar),
+			barPosition(bar.RightBarFlag, bar.PixelNumberStartOfRightBar),
+		}
+		fmt.Fprintln(w, strings.Join(cols, ", "))
+	}
+}
@@ -0,0 +1,178 @@
+package mpts
+
+// ATSC A/53 Part 4 user data, carried in MPEG-2 user_data and in
+// user_data_registered_itu_t_t35 SEI messages.
+
+const (
+	A53UserIdentifier = 0x47413934 // "GA94"
+	AfdUserIdentifier = 0x44544731 // "DTG1"
+)
+
+type A53UserData struct {
+	UserIdentifier   string
+	UserDataTypeCode int      `json:",omitempty"`
+	CcData           *CcData  `json:",omitempty"`
+	Bar              *BarData `json:",omitempty"`
+	Afd              *AfdData `json:",omitempty"`
+}
+
+// CcData is a cc_data() structure, CEA-708 section 4.4.
+type CcData struct {
+	ProcessCcDataFlag bool
+	CcCount           int
+	Triplets          []CcTriplet
+}
+
+type CcTriplet struct {
+	Valid bool
+	Type  int
+	Data1 byte
+	Data2 byte
+}
+
+type BarData struct {
+	TopBarFlag                 bool
+	BottomBarFlag              bool
+	LeftBarFlag                bool
+	RightBarFlag               bool
+	LineNumberEndOfTopBar      int `json:",omitempty"`
+	LineNumberStartOfBottomBar int `json:",omitempty"`
+	PixelNumberEndOfLeftBar    int `json:",omitempty"`
+	PixelNumberStartOfRightBar int `json:",omitempty"`
+}
+
+type AfdData struct {
+	ActiveFormatFlag bool
+	ActiveFormat     int
+}
+
+// ParseA53UserData parses ATSC user data starting with its 32-bit
+// user_identifier. It returns nil for other identifiers.
+func ParseA53UserData(data []byte) (u *A53UserData) {
+	if len(data) < 5 {
+		return nil
+	}
+	defer func() {
+		// Truncated user data keeps what was read
+		recover()
+	}()
+	r := NewReader(data)
+	id := r.ReadBit(32)
+	switch id {
+	case A53UserIdentifier:
+		u = &A53UserData{UserIdentifier: "GA94"}
+		u.UserDataTypeCode = r.ReadBit(8)
+		switch u.UserDataTypeCode {
+		case 0x03:
+			u.CcData = ParseCcData(r)
+		case 0x06:
+			u.Bar = ParseBarData(r)
+		}
+	case AfdUserIdentifier:
+		u = &A53UserData{UserIdentifier: "DTG1"}
+		u.Afd = ParseAfdData(r)
+	}
+	return u
+}
+
+func ParseCcData(r *Reader) *CcData {
+	cc := &CcData{}
+	r.SkipBit(1) // process_em_data_flag
+	cc.ProcessCcDataFlag = r.ReadFlag()
+	r.SkipBit(1) // additional_data_flag
+	cc.CcCount = r.ReadBit(5)
+	r.SkipByte(1) // em_data
+	for i := 0; i < cc.CcCount; i++ {
+		t := CcTriplet{}
+		r.SkipBit(5) // marker_bits
+		t.Valid = r.ReadFlag()
+		t.Type = r.ReadBit(2)
+		t.Data1 = byte(r.ReadBit(8))
+		t.Data2 = byte(r.ReadBit(8))
+		cc.Triplets = append(cc.Triplets, t)
+	}
+	return cc
+}
+
+func ParseBarData(r *Reader) *BarData {
+	bar := &BarData{}
+	bar.TopBarFlag = r.ReadFlag()
+	bar.BottomBarFlag = r.ReadFlag()
+	bar.LeftBarFlag = r.ReadFlag()
+	bar.RightBarFlag = r.ReadFlag()
+	r.SkipBit(4)
+	if bar.TopBarFlag {
+		r.SkipBit(2)
+		bar.LineNumberEndOfTopBar = r.ReadBit(14)
+	}
+	if bar.BottomBarFlag {
+		r.SkipBit(2)
+		bar.LineNumberStartOfBottomBar = r.ReadBit(14)
+	}
+	if bar.LeftBarFlag {
+		r.SkipBit(2)
+		bar.PixelNumberEndOfLeftBar = r.ReadBit(14)
+	}
+	if bar.RightBarFlag {
+		r.SkipBit(2)
+		bar.PixelNumberStartOfRightBar = r.ReadBit(14)
+	}
+	return bar
+}
+
+// ParseAfdData parses afd_data(), ATSC A/53 Part 4 section 6.2.4.
+func ParseAfdData(r *Reader) *AfdData {
+	afd := &AfdData{}
+	r.SkipBit(1)
+	afd.ActiveFormatFlag = r.ReadFlag()
+	r.SkipBit(6)
+	if afd.ActiveFormatFlag {
+		r.SkipBit(4)
+		afd.ActiveFormat = r.ReadBit(4)
+	}
+	return afd
+}
+
+// ItuT35 is a user_data_registered_itu_t_t35 SEI payload.
+type ItuT35 struct {
+	CountryCode  int
+	ProviderCode int
+	A53          *A53UserData `json:",omitempty"`
+	Hdr10Plus    *Hdr10Plus   `json:",omitempty"`
+}
+
+// a53 returns the ATSC user data of a T.35 SEI message, or nil.
+func (i *SeiInfo) a53() *A53UserData {
+	if i.Registered == nil {
+		return nil
+	}
+	return i.Registered.A53
+}
+
+// ParseItuT35 parses the header of a T.35 payload and the ATSC user data or
+// HDR10+ metadata it may carry.
+func ParseItuT35(payload []byte) *ItuT35 {
+	if len(payload) < 3 {
+		return nil
+	}
+	t := &ItuT35{}
+	pos := 0
+	t.CountryCode = int(payload[pos])
+	pos++
+	if t.CountryCode == 0xFF {
+		t.CountryCode = t.CountryCode<<8 | int(payload[pos])
+		pos++
+	}
+	if pos+2 > len(payload) {
+		return t
+	}
+	t.ProviderCode = int(payload[pos])<<8 | int(payload[pos+1])
+	pos += 2
+	// United States, ATSC
+	if t.CountryCode == 0xB5 && t.ProviderCode == 0x0031 {
+		t.A53 = ParseA53UserData(payload[pos:])
+	} else if t.CountryCode == 0xB5 && t.ProviderCode == Hdr10PlusProviderCode {
+		t.Hdr10Plus = ParseHdr10Plus(payload[pos:])
+	}
+	return t
+}
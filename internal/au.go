@@ -0,0 +1,327 @@
+package mpts
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// How a NAL unit, or an MPEG-2 start code, relates to access units.
+const (
+	auContinue      = iota // belongs to the current access unit
+	auStartAfterVcl        // starts an access unit if the current one has a picture
+	auStart                // always starts an access unit
+)
+
+// FrameTiming is one access unit of a video PID in decoding order. Pts and
+// Dts are -1 when the PES packet did not code them; Dts equals Pts when only
+// the PTS was coded.
+type FrameTiming struct {
+	Pos int64
+	// Offset of the first unit in the elementary stream, and the size of
+	// the units without their start codes
+	Offset int64
+	Size   int
+	Pts    int64
+	Dts    int64
+	Key    bool
+	// Whether the PES header carried the DTS
+	CodedDts bool
+	hasVcl   bool
+}
+
+// TimingAnomaly is a timestamp problem of one access unit.
+type TimingAnomaly struct {
+	Frame  int
+	Pos    int64
+	Kind   string
+	Pts    int64
+	Dts    int64
+	Detail string `json:",omitempty"`
+}
+
+// TimingSummary is the result of the access unit timing analysis.
+type TimingSummary struct {
+	Frames           int
+	FramesWithoutPts int
+	// Most frequent DTS step between consecutive frames, in 90 kHz
+	FrameDuration int64
+	FrameRate     float64
+	Reordering    bool
+	// Largest PTS-DTS, in 90 kHz and in frames
+	MaxPtsDtsDelay int64
+	ReorderDepth   int
+	AnomalyCount   map[string]int
+	Anomalies      []TimingAnomaly
+}
+
+// AuTimer rebuilds the access units of a video PID from its NAL units and
+// checks their timestamps. The PTS and DTS of a PES packet belong to the
+// first access unit starting in it.
+type AuTimer struct {
+	Frames  []FrameTiming
+	cur     *FrameTiming
+	stamped *PesPkt
+}
+
+// Add accounts a unit of PES packet p. kind tells whether it starts an
+// access unit; vcl whether it is part of the coded picture.
+func (t *AuTimer) Add(p *PesPkt, unit NalUnit, kind int, vcl bool, key bool) {
+	if t.cur == nil || kind == auStart || kind == auStartAfterVcl && t.cur.hasVcl {
+		t.Close()
+		t.cur = &FrameTiming{Pos: p.Pos, Offset: unit.Offset, Pts: -1, Dts: -1}
+		if p != t.stamped {
+			t.stamped = p
+			if p.Pts != 0 {
+				t.cur.Pts, t.cur.Dts = p.Pts, p.Pts
+			}
+			if p.Dts != 0 {
+				t.cur.Dts = p.Dts
+				t.cur.CodedDts = true
+			}
+		}
+	}
+	t.cur.Size += len(unit.Data)
+	t.cur.hasVcl = t.cur.hasVcl || vcl
+	t.cur.Key = t.cur.Key || key
+}
+
+// Close ends the access unit in progress.
+func (t *AuTimer) Close() {
+	if t.cur != nil {
+		t.Frames = append(t.Frames, *t.cur)
+		t.cur = nil
+	}
+}
+
+// frameDuration returns the most frequent DTS step per frame between
+// frames with timestamps, and the frame rate over all of them.
+func (t *AuTimer) frameDuration() (int64, float64) {
+	count := make(map[int64]int)
+	first, prev := -1, -1
+	var span int64
+	for i, f := range t.Frames {
+		if f.Dts < 0 {
+			continue
+		}
+		if prev >= 0 {
+			d := ptsDiff(f.Dts, t.Frames[prev].Dts)
+			if n := int64(i - prev); d > 0 {
+				count[(d+n/2)/n] += 1
+			}
+			span += d
+		} else {
+			first = i
+		}
+		prev = i
+	}
+	var best int64
+	for d, n := range count {
+		if n > count[best] || n == count[best] && d < best {
+			best = d
+		}
+	}
+	if span <= 0 {
+		return best, 0
+	}
+	return best, 90000 * float64(prev-first) / float64(span)
+}
+
+// Analyze checks the frames: DTS not increasing in decoding order, DTS
+// missing on frames presented after frames decoded later, and PTS gaps and
+// duplicates in presentation order. When some frames have no PTS, gaps are
+// looked for in decoding order.
+func (t *AuTimer) Analyze() TimingSummary {
+	sum := TimingSummary{Frames: len(t.Frames), AnomalyCount: make(map[string]int)}
+	add := func(i int, kind string, detail string) {
+		f := t.Frames[i]
+		sum.Anomalies = append(sum.Anomalies, TimingAnomaly{i, f.Pos, kind, f.Pts, f.Dts, detail})
+		sum.AnomalyCount[kind] += 1
+	}
+	sum.FrameDuration, sum.FrameRate = t.frameDuration()
+	for _, f := range t.Frames {
+		if f.Pts < 0 {
+			sum.FramesWithoutPts += 1
+		}
+	}
+
+	// Decoding order
+	prev := -1
+	for i, f := range t.Frames {
+		if f.Pts < 0 {
+			continue
+		}
+		if prev >= 0 {
+			d := ptsDiff(f.Dts, t.Frames[prev].Dts)
+			switch {
+			case d == 0:
+				add(i, "DuplicateDts", "")
+			case d < 0:
+				add(i, "DtsNotIncreasing", strconv.FormatInt(d, 10))
+			case sum.FramesWithoutPts > 0 && sum.FrameDuration > 0 &&
+				d > sum.FrameDuration*int64(7*(i-prev))/4:
+				// Frames without timestamps leave the presentation order
+				// unknown; look for gaps in decoding order instead
+				add(i, "DtsGap", strconv.FormatInt(d, 10))
+			}
+		}
+		if delay := ptsDiff(f.Pts, f.Dts); delay > sum.MaxPtsDtsDelay {
+			sum.MaxPtsDtsDelay = delay
+		}
+		prev = i
+	}
+	if sum.FrameDuration > 0 {
+		sum.ReorderDepth = int((sum.MaxPtsDtsDelay + sum.FrameDuration/2) / sum.FrameDuration)
+	}
+
+	// A frame presented after a frame decoded later needs a DTS before its
+	// PTS
+	minLater := int64(-1)
+	for i := len(t.Frames) - 1; i >= 0; i-- {
+		f := t.Frames[i]
+		if f.Pts < 0 {
+			continue
+		}
+		if minLater >= 0 && ptsDiff(minLater, f.Pts) < 0 {
+			sum.Reordering = true
+			if !f.CodedDts {
+				add(i, "MissingDts", "")
+			}
+		}
+		if minLater < 0 || ptsDiff(f.Pts, minLater) < 0 {
+			minLater = f.Pts
+		}
+	}
+
+	// Presentation order, when every frame has a PTS
+	var order []int
+	if sum.FramesWithoutPts == 0 {
+		for i := range t.Frames {
+			order = append(order, i)
+		}
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return ptsDiff(t.Frames[order[a]].Pts, t.Frames[order[b]].Pts) < 0
+	})
+	for k := 1; k < len(order); k++ {
+		d := ptsDiff(t.Frames[order[k]].Pts, t.Frames[order[k-1]].Pts)
+		switch {
+		case d == 0:
+			add(order[k], "DuplicatePts", "")
+		case sum.FrameDuration > 0 && d > sum.FrameDuration*7/4:
+			// Pictures with a repeated field last 1.5 frames
+			add(order[k], "PtsGap", strconv.FormatInt(d, 10))
+		}
+	}
+	sort.SliceStable(sum.Anomalies, func(a, b int) bool {
+		return sum.Anomalies[a].Frame < sum.Anomalies[b].Frame
+	})
+	return sum
+}
+
+func formatTimestamp(ts int64) string {
+	if ts < 0 {
+		return ""
+	}
+	return strconv.FormatInt(ts, 10)
+}
+
+// Report writes the frames to <pid>-au.csv and the analysis to
+// <pid>-timing.json.
+func (t *AuTimer) Report(root string, pid int) {
+	t.Close()
+	if len(t.Frames) == 0 {
+		return
+	}
+	sum := t.Analyze()
+
+	fname := filepath.Join(root, strconv.Itoa(pid)+"-au.csv")
+	w, err := os.Create(fname)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Fprintln(w, "Frame, Pos, Size, PTS, DTS, Key, (PTS-DTS)")
+	for i, f := range t.Frames {
+		delay := ""
+		if f.Pts >= 0 {
+			delay = strconv.FormatInt(ptsDiff(f.Pts, f.Dts), 10)
+		}
+		cols := []string{
+			strconv.Itoa(i),
+			strconv.FormatInt(f.Pos, 10),
+			strconv.Itoa(f.Size),
+			formatTimestamp(f.Pts),
+			formatTimestamp(f.Dts),
+			strconv.FormatBool(f.Key),
+			delay,
+		}
+		fmt.Fprintln(w, strings.Join(cols, ", "))
+	}
+	w.Close()
+
+	fname = filepath.Join(root, strconv.Itoa(pid)+"-timing.json")
+	w, err = os.Create(fname)
+	if err != nil {
+		panic(err)
+	}
+	defer w.Close()
+	buf, _ := json.MarshalIndent(sum, "", "  ")
+	fmt.Fprintln(w, string(buf))
+}
+
+// h264AuKind tells how a NAL unit relates to access units, 7.4.1.2.3.
+func h264AuKind(nal NalUnit) (kind int, vcl bool) {
+	switch t := nal.Data[0] & 0x1F; {
+	case t == 9:
+		return auStart, false
+	case t == 1 || t == 5:
+		// first_mb_in_slice is 0 when its ue(v) code is a single 1 bit
+		if len(nal.Rbsp) > 1 && nal.Rbsp[1]&0x80 != 0 {
+			return auStartAfterVcl, true
+		}
+		return auContinue, true
+	case t == 2:
+		return auStartAfterVcl, true
+	case t == 3 || t == 4:
+		return auContinue, true
+	case t >= 6 && t <= 8, t >= 14 && t <= 18:
+		return auStartAfterVcl, false
+	}
+	return auContinue, false
+}
+
+// hevcAuKind tells how a NAL unit relates to access units, 7.4.2.4.4.
+// Units of other layers belong to the access unit of the base layer.
+func hevcAuKind(nal NalUnit) (kind int, vcl bool) {
+	if len(nal.Data) < 2 || nal.Data[0]&0x01 != 0 || nal.Data[1]&0xF8 != 0 {
+		return auContinue, false
+	}
+	switch t := int(nal.Data[0]>>1) & 0x3F; {
+	case t == 35:
+		return auStart, false
+	case t <= 31:
+		if len(nal.Rbsp) > 2 && nal.Rbsp[2]&0x80 != 0 {
+			return auStartAfterVcl, true
+		}
+		return auContinue, true
+	case t >= 32 && t <= 34, t == 39, t >= 41 && t <= 44, t >= 48 && t <= 55:
+		return auStartAfterVcl, false
+	}
+	return auContinue, false
+}
+
+// mp2vAuKind tells how an MPEG-2 video start code unit relates to access
+// units: a sequence, GOP or picture header starts a picture.
+func mp2vAuKind(unit NalUnit) (kind int, vcl bool) {
+	switch code := unit.Data[0]; {
+	case code == 0x00, code == 0xB3, code == 0xB8:
+		return auStartAfterVcl, false
+	case code >= 0x01 && code <= 0xAF:
+		return auContinue, true
+	}
+	return auContinue, false
+}
@@ -19,6 +19,8 @@ func (r *Reader) SkipByte(n int) {
 func (r *Reader) SkipBit(n int) {
 	r.Base += n / 8
 	r.Off += n % 8
+	r.Base += r.Off / 8
+	r.Off %= 8
 }
 
 func (r *Reader) ReadBit(n int) (v int) {
@@ -54,3 +56,53 @@ func (r *Reader) ReadBit64(n int) (v int64) {
 	}
 	return v
 }
+
+// ReadUE reads an unsigned Exp-Golomb code, ue(v).
+func (r *Reader) ReadUE() int {
+	zeros := 0
+	for r.ReadBit(1) == 0 {
+		zeros++
+		if zeros > 31 {
+			panic("invalid Exp-Golomb code")
+		}
+	}
+	if zeros == 0 {
+		return 0
+	}
+	return 1<<uint(zeros) - 1 + r.ReadBit(zeros)
+}
+
+// ReadSE reads a signed Exp-Golomb code, se(v).
+func (r *Reader) ReadSE() int {
+	k := r.ReadUE()
+	if k%2 == 1 {
+		return (k + 1) / 2
+	}
+	return -k / 2
+}
+
+func (r *Reader) ReadFlag() bool {
+	return r.ReadBit(1) == 1
+}
+
+// BitsLeft returns the number of unread bits.
+func (r *Reader) BitsLeft() int {
+	return (len(r.Data)-r.Base)*BYTE - r.Off
+}
+
+// MoreRbspData reports whether there is data before the rbsp_stop_one_bit.
+func (r *Reader) MoreRbspData() bool {
+	last := len(r.Data) - 1
+	for last >= 0 && r.Data[last] == 0 {
+		last--
+	}
+	if last < 0 {
+		return false
+	}
+	// Position of the stop bit, counted in bits from the start
+	stop := last*BYTE + BYTE - 1
+	for b := r.Data[last]; b&1 == 0; b >>= 1 {
+		stop--
+	}
+	return r.Base*BYTE+r.Off < stop
+}
@@ -0,0 +1,46 @@
+package mpts
+
+type Writer struct {
+	Data []byte
+	Off  int
+}
+
+func NewWriter() *Writer {
+	return &Writer{}
+}
+
+func (w *Writer) WriteBit(v int, n int) {
+	w.WriteBit64(int64(v), n)
+}
+
+func (w *Writer) WriteBit64(v int64, n int) {
+	for n > 0 {
+		if w.Off == 0 {
+			w.Data = append(w.Data, 0)
+		}
+		// Fill the remaining bits of the current byte
+		sw := BYTE - w.Off
+		if n < sw {
+			sw = n
+		}
+		bits := byte((v >> uint(n-sw)) & (1<<uint(sw) - 1))
+		w.Data[len(w.Data)-1] |= bits << uint(BYTE-w.Off-sw)
+		w.Off = (w.Off + sw) % BYTE
+		n -= sw
+	}
+}
+
+func (w *Writer) WriteBytes(b []byte) {
+	for _, v := range b {
+		w.WriteBit(int(v), 8)
+	}
+}
+
+// Len returns the number of bytes written, counting a partial byte.
+func (w *Writer) Len() int {
+	return len(w.Data)
+}
+
+func (w *Writer) Bytes() []byte {
+	return w.Data
+}
@@ -0,0 +1,211 @@
+package mpts
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Caption is a caption shown from Start to End, in 90 kHz PTS units.
+type Caption struct {
+	Start int64
+	End   int64
+	Text  string
+}
+
+// captionTrack turns the successive states of a caption display into
+// captions.
+type captionTrack struct {
+	Name     string
+	Captions []Caption
+	shown    string
+	since    int64
+}
+
+// update records the text displayed from now on.
+func (t *captionTrack) update(now int64, text string) {
+	if text == t.shown {
+		return
+	}
+	if t.shown != "" {
+		t.Captions = append(t.Captions, Caption{t.since, now, t.shown})
+	}
+	t.shown = text
+	t.since = now
+}
+
+// close ends the caption displayed at the end of the stream.
+func (t *captionTrack) close(now int64) {
+	t.update(now, "")
+}
+
+type ccFrame struct {
+	pts      int64
+	triplets []CcTriplet
+}
+
+// CaptionExtractor collects the cc_data of the pictures of a video PID,
+// from MPEG-2 user data or H.264 and HEVC SEI messages, and decodes the
+// CEA-608 channels and CEA-708 services in presentation order.
+type CaptionExtractor struct {
+	frames []ccFrame
+}
+
+// Add records the cc_data of a picture. Pictures without a PTS, pts -1,
+// take the time of the previous one.
+func (c *CaptionExtractor) Add(pts int64, cc *CcData) {
+	if !cc.ProcessCcDataFlag || len(cc.Triplets) == 0 {
+		return
+	}
+	if pts < 0 {
+		if len(c.frames) == 0 {
+			return
+		}
+		pts = c.frames[len(c.frames)-1].pts
+	}
+	c.frames = append(c.frames, ccFrame{pts, cc.Triplets})
+}
+
+// sort puts the cc_data, carried in decoding order, in presentation order.
+func (c *CaptionExtractor) sort() {
+	sort.SliceStable(c.frames, func(i, j int) bool {
+		return ptsDiff(c.frames[i].pts, c.frames[j].pts) < 0
+	})
+}
+
+// Decode returns the caption tracks found, CC1 to CC4 then the CEA-708
+// services by number, and the start time of the captions.
+func (c *CaptionExtractor) Decode() (tracks []*captionTrack, base int64) {
+	if len(c.frames) == 0 {
+		return nil, 0
+	}
+	c.sort()
+	base = c.frames[0].pts
+	fields := [2]*cea608Field{newCea608Field(0), newCea608Field(2)}
+	dtvcc := &Dtvcc{}
+	var now int64
+	for _, f := range c.frames {
+		now = f.pts
+		for _, t := range f.triplets {
+			if !t.Valid {
+				continue
+			}
+			if t.Type < 2 {
+				fields[t.Type].Decode(t.Data1, t.Data2, now)
+			} else {
+				dtvcc.Add(t, now)
+			}
+		}
+	}
+	dtvcc.decodePacket(now)
+	for _, f := range fields {
+		for _, ch := range f.channels {
+			ch.close(now)
+			tracks = append(tracks, &ch.captionTrack)
+		}
+	}
+	var numbers []int
+	for n := range dtvcc.Services {
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers)
+	for _, n := range numbers {
+		svc := dtvcc.Services[n]
+		svc.close(now)
+		tracks = append(tracks, &svc.captionTrack)
+	}
+	return tracks, base
+}
+
+// formatCaptionTime formats a time relative to base as HH:MM:SS followed
+// by sep and milliseconds.
+func formatCaptionTime(pts int64, base int64, sep string) string {
+	ms := ptsDiff(pts, base) / 90
+	if ms < 0 {
+		ms = 0
+	}
+	return fmt.Sprintf("%02d:%02d:%02d%s%03d", ms/3600000, ms/60000%60, ms/1000%60, sep, ms%1000)
+}
+
+// WriteSrt writes captions in the SubRip format, timed from base.
+func WriteSrt(w io.Writer, captions []Caption, base int64) {
+	for i, c := range captions {
+		fmt.Fprintf(w, "%d\n%s --> %s\n%s\n\n", i+1,
+			formatCaptionTime(c.Start, base, ","),
+			formatCaptionTime(c.End, base, ","), c.Text)
+	}
+}
+
+// WriteWebVtt writes captions in the WebVTT format, timed from base.
+func WriteWebVtt(w io.Writer, captions []Caption, base int64) {
+	fmt.Fprint(w, "WEBVTT\n\n")
+	for _, c := range captions {
+		fmt.Fprintf(w, "%s --> %s\n%s\n\n",
+			formatCaptionTime(c.Start, base, "."),
+			formatCaptionTime(c.End, base, "."), c.Text)
+	}
+}
+
+// WriteScc writes the field 1 byte pairs in the Scenarist SCC format, with
+// 29.97 fps non-drop frame time codes from base. Each line starts where
+// caption data resumes after padding.
+func (c *CaptionExtractor) WriteScc(w io.Writer, base int64) {
+	c.sort()
+	fmt.Fprint(w, "Scenarist_SCC V1.0")
+	line := false
+	for _, f := range c.frames {
+		for _, t := range f.triplets {
+			if !t.Valid || t.Type != 0 {
+				continue
+			}
+			if t.Data1&0x7F == 0 && t.Data2&0x7F == 0 {
+				line = false
+				continue
+			}
+			if !line {
+				frames := ptsDiff(f.pts, base) * 30000 / (1001 * 90000)
+				fmt.Fprintf(w, "\n\n%02d:%02d:%02d:%02d\t", frames/108000, frames/1800%60, frames/30%60, frames%30)
+				line = true
+			} else {
+				fmt.Fprint(w, " ")
+			}
+			fmt.Fprintf(w, "%02x%02x", t.Data1, t.Data2)
+		}
+	}
+	fmt.Fprintln(w)
+}
+
+// Report writes <pid>-<track>.srt and .vtt for each caption track with
+// captions, and <pid>.scc when CEA-608 field 1 data is present.
+func (c *CaptionExtractor) Report(root string, pid int) {
+	tracks, base := c.Decode()
+	write := func(name string, f func(w io.Writer)) {
+		fname := filepath.Join(root, strconv.Itoa(pid)+name)
+		file, err := os.Create(fname)
+		if err != nil {
+			panic(err)
+		}
+		defer file.Close()
+		w := bufio.NewWriter(file)
+		defer w.Flush()
+		f(w)
+	}
+	field1 := false
+	for _, t := range tracks {
+		if len(t.Captions) == 0 {
+			continue
+		}
+		name := "-" + strings.ToLower(t.Name)
+		write(name+".srt", func(w io.Writer) { WriteSrt(w, t.Captions, base) })
+		write(name+".vtt", func(w io.Writer) { WriteWebVtt(w, t.Captions, base) })
+		field1 = field1 || t.Name == "CC1" || t.Name == "CC2"
+	}
+	if field1 {
+		write(".scc", func(w io.Writer) { c.WriteScc(w, base) })
+	}
+}
@@ -0,0 +1,259 @@
+package mpts
+
+import (
+	"strings"
+)
+
+// CEA-608 line 21 captions, decoded from the byte pairs of cc_data.
+
+// Characters differing from ASCII in the basic set
+var cea608BasicChars = map[byte]rune{
+	0x2A: 'á',
+	0x5C: 'é',
+	0x5E: 'í',
+	0x5F: 'ó',
+	0x60: 'ú',
+	0x7B: 'ç',
+	0x7C: '÷',
+	0x7D: 'Ñ',
+	0x7E: 'ñ',
+	0x7F: '█',
+}
+
+// Special characters, second byte 0x30 to 0x3F after 0x11
+var cea608SpecialChars = []rune("®°½¿™¢£♪à èâêîôû")
+
+// Extended characters, second byte 0x20 to 0x3F after 0x12 and 0x13. Each
+// replaces the standard character sent before it.
+var cea608ExtendedChars = [2][]rune{
+	[]rune("ÁÉÓÚÜü‘¡*’—©℠•“”ÀÂÇÈÊËëÎÏïÔÙùÛ«»"),
+	[]rune("ÃãÍÌìÒòÕõ{}\\^_|~ÄäÖöß¥¤¦ÅåØø┌┐└┘"),
+}
+
+// Rows of the preamble address codes by the low 3 bits of the first byte
+// and bit 5 of the second byte
+var cea608PacRows = [8][2]int{
+	{11, 11}, {1, 2}, {3, 4}, {12, 13}, {14, 15}, {5, 6}, {7, 8}, {9, 10},
+}
+
+// Caption modes
+const (
+	cea608PopOn = iota
+	cea608RollUp
+	cea608PaintOn
+)
+
+const (
+	cea608Rows    = 15
+	cea608Columns = 32
+)
+
+type cea608Screen [cea608Rows][cea608Columns]rune
+
+func (scr *cea608Screen) text() string {
+	var lines []string
+	for _, row := range scr {
+		line := strings.TrimSpace(strings.Map(func(r rune) rune {
+			if r == 0 {
+				return ' '
+			}
+			return r
+		}, string(row[:])))
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// cea608Channel is the caption state of one data channel, CC1 to CC4.
+type cea608Channel struct {
+	captionTrack
+	mode         int
+	rollRows     int
+	row, col     int
+	displayed    cea608Screen
+	nonDisplayed cea608Screen
+}
+
+func (c *cea608Channel) screen() *cea608Screen {
+	if c.mode == cea608PopOn {
+		return &c.nonDisplayed
+	}
+	return &c.displayed
+}
+
+func (c *cea608Channel) put(r rune) {
+	c.screen()[c.row][c.col] = r
+	if c.col < cea608Columns-1 {
+		c.col++
+	}
+}
+
+func (c *cea608Channel) backspace() {
+	if c.col > 0 {
+		c.col--
+	}
+	c.screen()[c.row][c.col] = 0
+}
+
+func (c *cea608Channel) show(now int64) {
+	c.update(now, c.displayed.text())
+}
+
+// control applies a miscellaneous control code, Table 52 of CEA-608.
+func (c *cea608Channel) control(code byte, now int64) {
+	switch code {
+	case 0x20: // RCL, resume caption loading
+		c.mode = cea608PopOn
+	case 0x21: // BS
+		c.backspace()
+	case 0x24: // DER, delete to end of row
+		for col := c.col; col < cea608Columns; col++ {
+			c.screen()[c.row][col] = 0
+		}
+	case 0x25, 0x26, 0x27: // RU2, RU3, RU4
+		if c.mode != cea608RollUp {
+			c.displayed = cea608Screen{}
+			c.nonDisplayed = cea608Screen{}
+			c.show(now)
+			c.row = cea608Rows - 1
+		}
+		c.mode = cea608RollUp
+		c.rollRows = int(code-0x25) + 2
+		c.col = 0
+	case 0x29: // RDC, resume direct captioning
+		c.mode = cea608PaintOn
+	case 0x2C: // EDM, erase displayed memory
+		c.displayed = cea608Screen{}
+		c.show(now)
+	case 0x2D: // CR
+		if c.mode != cea608RollUp {
+			break
+		}
+		// The completed row is shown until the next one
+		c.show(now)
+		top := c.row - c.rollRows + 1
+		if top < 0 {
+			top = 0
+		}
+		for row := top; row < c.row; row++ {
+			c.displayed[row] = c.displayed[row+1]
+		}
+		c.displayed[c.row] = [cea608Columns]rune{}
+		c.col = 0
+	case 0x2E: // ENM, erase non-displayed memory
+		c.nonDisplayed = cea608Screen{}
+	case 0x2F: // EOC, end of caption
+		c.displayed, c.nonDisplayed = c.nonDisplayed, c.displayed
+		c.mode = cea608PopOn
+		c.show(now)
+	}
+}
+
+// pac applies a preamble address code. In roll-up mode it moves the base
+// row along with the rows above it.
+func (c *cea608Channel) pac(b1, b2 byte) {
+	row := cea608PacRows[b1&0x07][(b2>>5)&0x01] - 1
+	if c.mode == cea608RollUp && row != c.row {
+		var moved cea608Screen
+		for i := 0; i < c.rollRows; i++ {
+			if from, to := c.row-i, row-i; from >= 0 && to >= 0 {
+				moved[to] = c.displayed[from]
+			}
+		}
+		c.displayed = moved
+	}
+	c.row = row
+	c.col = 0
+	if b2&0x10 != 0 {
+		c.col = int((b2&0x0E)>>1) * 4
+	}
+}
+
+// cea608Field decodes the byte pairs of one field: CC1 and CC2 in field 1,
+// CC3 and CC4 in field 2. Extended data services are skipped.
+type cea608Field struct {
+	channels [2]*cea608Channel
+	cur      int
+	lastCtrl [2]byte
+	xds      bool
+}
+
+func newCea608Field(first int) *cea608Field {
+	f := &cea608Field{}
+	for i := range f.channels {
+		f.channels[i] = &cea608Channel{captionTrack: captionTrack{Name: "CC" + string(rune('1'+first+i))}}
+	}
+	return f
+}
+
+// Decode handles a byte pair, odd parity bits included.
+func (f *cea608Field) Decode(d1, d2 byte, now int64) {
+	b1, b2 := d1&0x7F, d2&0x7F
+	if b1 == 0 && b2 == 0 {
+		return
+	}
+	if b1 >= 0x10 && b1 <= 0x1F {
+		f.xds = false
+		// Control codes are sent twice
+		if f.lastCtrl == [2]byte{b1, b2} {
+			f.lastCtrl = [2]byte{}
+			return
+		}
+		f.lastCtrl = [2]byte{b1, b2}
+		f.cur = 0
+		if b1&0x08 != 0 {
+			f.cur = 1
+		}
+		f.command(b1&0xF7, b2, now)
+		// Paint-on text is shown as it is written
+		if c := f.channels[f.cur]; c.mode == cea608PaintOn {
+			c.show(now)
+		}
+		return
+	}
+	f.lastCtrl = [2]byte{}
+	if b1 >= 0x01 && b1 <= 0x0F {
+		f.xds = b1 != 0x0F
+		return
+	}
+	if f.xds {
+		return
+	}
+	c := f.channels[f.cur]
+	for _, b := range []byte{b1, b2} {
+		if b < 0x20 {
+			continue
+		}
+		if r, ok := cea608BasicChars[b]; ok {
+			c.put(r)
+		} else {
+			c.put(rune(b))
+		}
+	}
+}
+
+func (f *cea608Field) command(b1, b2 byte, now int64) {
+	c := f.channels[f.cur]
+	switch {
+	case b2 >= 0x40 && b2 <= 0x7F:
+		c.pac(b1, b2)
+	case b1 == 0x11 && b2 >= 0x20 && b2 <= 0x2F:
+		// Mid-row codes take a space
+		c.put(' ')
+	case b1 == 0x11 && b2 >= 0x30 && b2 <= 0x3F:
+		c.put(cea608SpecialChars[b2-0x30])
+	case (b1 == 0x12 || b1 == 0x13) && b2 >= 0x20 && b2 <= 0x3F:
+		c.backspace()
+		c.put(cea608ExtendedChars[b1-0x12][b2-0x20])
+	case (b1 == 0x14 || b1 == 0x15) && b2 >= 0x20 && b2 <= 0x2F:
+		c.control(b2, now)
+	case b1 == 0x17 && b2 >= 0x21 && b2 <= 0x23:
+		// Tab offsets
+		c.col += int(b2 - 0x20)
+		if c.col >= cea608Columns {
+			c.col = cea608Columns - 1
+		}
+	}
+}
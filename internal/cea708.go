@@ -0,0 +1,312 @@
+package mpts
+
+import (
+	"strconv"
+	"strings"
+)
+
+// CEA-708 digital television closed captions, decoded from the DTVCC
+// packets of cc_data.
+
+// G2 characters, CEA-708 section 7.1.7
+var cea708G2Chars = map[byte]rune{
+	0x20: ' ',
+	0x21: ' ',
+	0x25: '…',
+	0x2A: 'Š',
+	0x2C: 'Œ',
+	0x30: '█',
+	0x31: '‘',
+	0x32: '’',
+	0x33: '“',
+	0x34: '”',
+	0x35: '•',
+	0x39: '™',
+	0x3A: 'š',
+	0x3C: 'œ',
+	0x3D: '℠',
+	0x3F: 'Ÿ',
+	0x76: '⅛',
+	0x77: '⅜',
+	0x78: '⅝',
+	0x79: '⅞',
+	0x7A: '│',
+	0x7B: '┐',
+	0x7C: '└',
+	0x7D: '─',
+	0x7E: '┘',
+	0x7F: '┌',
+}
+
+// Parameter bytes of the C1 commands 0x80 to 0x9F, section 7.1.5
+var cea708C1Params = [32]int{
+	0, 0, 0, 0, 0, 0, 0, 0, // CW0-CW7
+	1, 1, 1, 1, 1, 1, 0, 0, // CLW, DSW, HDW, TGW, DLW, DLY, DLC, RST
+	2, 3, 2, 0, 0, 0, 0, 4, // SPA, SPC, SPL, reserved, SWA
+	6, 6, 6, 6, 6, 6, 6, 6, // DF0-DF7
+}
+
+type cea708Window struct {
+	defined  bool
+	visible  bool
+	rowCount int
+	rows     [][]rune
+	row, col int
+}
+
+func (w *cea708Window) clear() {
+	w.rows = make([][]rune, w.rowCount)
+	w.row, w.col = 0, 0
+}
+
+func (w *cea708Window) put(r rune) {
+	if w.row >= len(w.rows) {
+		return
+	}
+	line := w.rows[w.row]
+	for len(line) <= w.col {
+		line = append(line, ' ')
+	}
+	line[w.col] = r
+	w.rows[w.row] = line
+	w.col++
+}
+
+// newLine moves the pen to the next row, scrolling the window up when it
+// is on the last row.
+func (w *cea708Window) newLine() {
+	w.col = 0
+	if w.row+1 < len(w.rows) {
+		w.row++
+		return
+	}
+	if len(w.rows) > 0 {
+		w.rows = append(w.rows[1:], nil)
+	}
+}
+
+func (w *cea708Window) text() []string {
+	var lines []string
+	for _, row := range w.rows {
+		if line := strings.TrimSpace(string(row)); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// cea708Service is the caption state of one caption service. Window
+// positions, pens and styles are not kept.
+type cea708Service struct {
+	captionTrack
+	windows [8]cea708Window
+	cur     int
+}
+
+func newCea708Service(number int) *cea708Service {
+	return &cea708Service{captionTrack: captionTrack{Name: "Service" + strconv.Itoa(number)}}
+}
+
+func (s *cea708Service) window() *cea708Window {
+	return &s.windows[s.cur]
+}
+
+func (s *cea708Service) show(now int64) {
+	var lines []string
+	for i := range s.windows {
+		if w := &s.windows[i]; w.defined && w.visible {
+			lines = append(lines, w.text()...)
+		}
+	}
+	s.update(now, strings.Join(lines, "\n"))
+}
+
+// Decode handles a service block.
+func (s *cea708Service) Decode(data []byte, now int64) {
+	for i := 0; i < len(data); {
+		code := data[i]
+		i++
+		switch {
+		case code == 0x10: // EXT1
+			if i >= len(data) {
+				return
+			}
+			ext := data[i]
+			i++
+			switch {
+			case ext < 0x20:
+				i += int(ext) / 8
+			case ext < 0x80:
+				if r, ok := cea708G2Chars[ext]; ok {
+					s.window().put(r)
+				}
+			case ext < 0x90:
+				i += 4 + int(ext-0x80)/8
+			case ext < 0xA0:
+				// Variable length C3 commands end the block
+				return
+			}
+		case code < 0x20:
+			s.c0(code, now)
+			switch {
+			case code >= 0x18:
+				i += 2
+			case code >= 0x11:
+				i += 1
+			}
+		case code < 0x80:
+			if code == 0x7F {
+				s.window().put('♪')
+			} else {
+				s.window().put(rune(code))
+			}
+		case code < 0xA0:
+			n := cea708C1Params[code-0x80]
+			if i+n > len(data) {
+				return
+			}
+			s.c1(code, data[i:i+n], now)
+			i += n
+		default:
+			s.window().put(rune(code))
+		}
+	}
+}
+
+func (s *cea708Service) c0(code byte, now int64) {
+	w := s.window()
+	switch code {
+	case 0x03: // ETX
+		s.show(now)
+	case 0x08: // BS
+		if w.col > 0 {
+			w.col--
+			if w.row < len(w.rows) && w.col < len(w.rows[w.row]) {
+				w.rows[w.row] = w.rows[w.row][:w.col]
+			}
+		}
+	case 0x0C: // FF
+		w.clear()
+		s.show(now)
+	case 0x0D: // CR
+		// The completed row is shown until the next one
+		s.show(now)
+		w.newLine()
+	case 0x0E: // HCR
+		if w.row < len(w.rows) {
+			w.rows[w.row] = nil
+		}
+		w.col = 0
+	}
+}
+
+func (s *cea708Service) c1(code byte, params []byte, now int64) {
+	each := func(bitmap byte, f func(w *cea708Window)) {
+		for i := range s.windows {
+			if bitmap&(1<<uint(i)) != 0 {
+				f(&s.windows[i])
+			}
+		}
+		s.show(now)
+	}
+	switch {
+	case code <= 0x87: // CWx
+		s.cur = int(code - 0x80)
+	case code == 0x88: // CLW
+		each(params[0], func(w *cea708Window) { w.clear() })
+	case code == 0x89: // DSW
+		each(params[0], func(w *cea708Window) { w.visible = true })
+	case code == 0x8A: // HDW
+		each(params[0], func(w *cea708Window) { w.visible = false })
+	case code == 0x8B: // TGW
+		each(params[0], func(w *cea708Window) { w.visible = !w.visible })
+	case code == 0x8C: // DLW
+		each(params[0], func(w *cea708Window) { *w = cea708Window{} })
+	case code == 0x8F: // RST
+		s.windows = [8]cea708Window{}
+		s.show(now)
+	case code == 0x92: // SPL
+		w := s.window()
+		w.row = int(params[0] & 0x0F)
+		w.col = int(params[1] & 0x3F)
+	case code >= 0x98: // DFx
+		s.cur = int(code - 0x98)
+		w := s.window()
+		rowCount := int(params[3]&0x0F) + 1
+		if !w.defined || w.rowCount != rowCount {
+			w.defined = true
+			w.rowCount = rowCount
+			w.clear()
+		}
+		w.visible = params[0]&0x20 != 0
+		s.show(now)
+	}
+}
+
+// Dtvcc reassembles the DTVCC packets of cc_data and decodes their caption
+// services, CEA-708 section 5 and 6.
+type Dtvcc struct {
+	Services map[int]*cea708Service
+	packet   []byte
+}
+
+// Add handles a DTVCC cc_data triplet.
+func (d *Dtvcc) Add(t CcTriplet, now int64) {
+	switch {
+	case !t.Valid:
+		return
+	case t.Type == 3: // DTVCC_PACKET_START
+		d.decodePacket(now)
+		d.packet = []byte{t.Data1, t.Data2}
+	case t.Type == 2 && d.packet != nil:
+		d.packet = append(d.packet, t.Data1, t.Data2)
+	}
+	if d.packet != nil && len(d.packet) >= d.packetSize() {
+		d.decodePacket(now)
+	}
+}
+
+// packetSize returns the size of the packet in progress, header included.
+func (d *Dtvcc) packetSize() int {
+	code := int(d.packet[0] & 0x3F)
+	if code == 0 {
+		return 128
+	}
+	return code * 2
+}
+
+func (d *Dtvcc) decodePacket(now int64) {
+	if d.packet == nil {
+		return
+	}
+	data := d.packet[1:]
+	if n := d.packetSize() - 1; len(data) > n {
+		data = data[:n]
+	}
+	d.packet = nil
+	for i := 0; i < len(data); {
+		number := int(data[i] >> 5)
+		size := int(data[i] & 0x1F)
+		i++
+		if number == 7 && size != 0 {
+			if i >= len(data) {
+				return
+			}
+			number = int(data[i] & 0x3F)
+			i++
+		}
+		if number == 0 || i+size > len(data) {
+			return
+		}
+		if d.Services == nil {
+			d.Services = make(map[int]*cea708Service)
+		}
+		svc, ok := d.Services[number]
+		if !ok {
+			svc = newCea708Service(number)
+			d.Services[number] = svc
+		}
+		svc.Decode(data[i:i+size], now)
+		i += size
+	}
+}
@@ -6,7 +6,7 @@ import (
 )
 
 func ParseTsPkt(data []byte) *TsPkt {
-	pkt := &TsPkt{}
+	pkt := &TsPkt{Raw: data[:TSPacketSize]}
 	r := &Reader{Data: data}
 
 	pkt.SyncByte = r.ReadBit(8)
@@ -17,11 +17,14 @@ func ParseTsPkt(data []byte) *TsPkt {
 	r.SkipBit(2)
 	afctrl := r.ReadBit(2)
 	pkt.CC = r.ReadBit(4)
+	pkt.hasPayload = afctrl == 1 || afctrl == 3
 	if afctrl == 2 || afctrl == 3 {
 		pkt.AdaptField = &AdaptField{}
 		aflen := r.ReadBit(8)
 		if aflen > 0 {
 			flags := r.ReadBit(8)
+			pkt.AdaptField.Discontinuity = (flags & 0x80) != 0
+			pkt.AdaptField.RandomAccess = (flags & 0x40) != 0
 			if (flags & 0x10) != 0 {
 				pkt.hasPCR = true
 				pkt.pcr = ParsePcr(r)
@@ -67,7 +70,9 @@ func ParsePcr(r *Reader) int64 {
 }
 
 type AdaptField struct {
-	PrivateData []byte
+	Discontinuity bool
+	RandomAccess  bool
+	PrivateData   []byte
 }
 
 type AuInfo struct {
@@ -270,16 +275,90 @@ func ParseAdaptFieldPrivData(data []byte) []AdaptFieldPrivData {
 	return privList
 }
 
+// EncodeAdaptFieldPrivData builds transport private data from the fields
+// that ParseAdaptFieldPrivData decodes. FieldLen is computed; fields with
+// an unknown tag are skipped.
+func EncodeAdaptFieldPrivData(privList []AdaptFieldPrivData) []byte {
+	var data []byte
+	for _, priv := range privList {
+		w := NewWriter()
+		switch {
+		case priv.FieldTag == 0x02 && priv.AuInfo != nil:
+			auInfo := priv.AuInfo
+			w.WriteBit(auInfo.CodingFormat, 4)
+			w.WriteBit(auInfo.CodingType, 4)
+			w.WriteBit(auInfo.RefPicIdc, 2)
+			w.WriteBit(auInfo.PicStruct, 2)
+			w.WriteBit(b2i(auInfo.PtsPresent), 1)
+			w.WriteBit(b2i(auInfo.ProfileInfoPresent), 1)
+			w.WriteBit(b2i(auInfo.StreamInfoPresent), 1)
+			w.WriteBit(b2i(auInfo.TrickModeInfoPresent), 1)
+			if auInfo.PtsPresent {
+				w.WriteBit64(auInfo.Pts&0xFFFFFFFF, 32)
+			}
+			if auInfo.StreamInfoPresent {
+				w.WriteBit(0, 4)
+				w.WriteBit(auInfo.AuFrameRateCode, 4)
+			}
+			if auInfo.ProfileInfoPresent {
+				w.WriteBit(auInfo.AuProfile, 8)
+				w.WriteBit(auInfo.AuAvcFlags, 8)
+				w.WriteBit(auInfo.AuLevel, 8)
+			}
+		case priv.FieldTag == 0xA0 && priv.DirecTvTimeCode != nil:
+			tcInfo := priv.DirecTvTimeCode
+			w.WriteBit(b2i(tcInfo.DropFrameFlag), 1)
+			w.WriteBit(tcInfo.Hours, 5)
+			w.WriteBit(tcInfo.Minutes, 6)
+			w.WriteBit(tcInfo.Seconds, 6)
+			w.WriteBit(tcInfo.Pictures, 6)
+		case priv.FieldTag == 0xAD && priv.BroadcastId != nil:
+			biInfo := priv.BroadcastId
+			w.WriteBit(biInfo.Identifier, 32)
+			w.WriteBit(biInfo.Origin, 8)
+			name := make([]byte, 14)
+			copy(name, biInfo.ServiceName)
+			w.WriteBytes(name)
+			w.WriteBit(biInfo.TransportStreamId, 16)
+			if biInfo.Origin == 1 {
+				w.WriteBit(0xF, 4)
+				w.WriteBit(biInfo.MajorChannelNumber, 10)
+				w.WriteBit(biInfo.MinorChannelNumber, 10)
+			}
+		case (priv.FieldTag == 0xA9 || priv.FieldTag == 0xDF) && priv.EBP != nil:
+			ebp := priv.EBP
+			if priv.FieldTag == 0xDF {
+				w.WriteBytes([]byte("EBP0"))
+			}
+			w.WriteBit(b2i(ebp.Fragment), 1)
+			w.WriteBit(b2i(ebp.Segment), 1)
+			w.WriteBit(0, 2) // SAP, grouping
+			w.WriteBit(b2i(ebp.UtcTimestamp != nil), 1)
+			w.WriteBit(0, 3) // concealment, reserved, extension
+			if ebp.UtcTimestamp != nil {
+				w.WriteBit64(int64(*ebp.UtcTimestamp), 64)
+			}
+		default:
+			continue
+		}
+		data = append(data, priv.FieldTag, byte(w.Len()))
+		data = append(data, w.Bytes()...)
+	}
+	return data
+}
+
 type TsPkt struct {
 	SyncByte int
 	PUSI     int
 	Pid      int
 	CC       int
 	*AdaptField
-	Data   []byte
-	pcr    int64
-	hasPCR bool
-	Pos    int64
+	Data       []byte
+	Raw        []byte
+	pcr        int64
+	hasPCR     bool
+	hasPayload bool
+	Pos        int64
 }
 
 func (p TsPkt) PCR() (int64, bool) {
@@ -289,15 +368,26 @@ func (p TsPkt) PCR() (int64, bool) {
 	return 0, false
 }
 
+// HasPayload reports whether the adaptation_field_control of the packet
+// signals a payload, i.e. whether the continuity counter is incremented.
+func (p TsPkt) HasPayload() bool {
+	return p.hasPayload
+}
+
 type PesPkt struct {
-	Pos      int64
-	Size     int64
-	StreamId int
-	Pcr      int64
-	PcrPos   int64
-	Pts      int64
-	Dts      int64
-	Data     []byte
+	Pos       int64
+	Size      int64
+	StreamId  int
+	Length    int // PES_packet_length
+	HeaderLen int
+	Pcr       int64
+	PcrPos    int64
+	Pts       int64
+	Dts       int64
+	Data      []byte
+	// Errors found while collecting the packet
+	StartCodeError bool
+	CCError        bool
 }
 
 func (p *PesPkt) Read(data []byte) (n int) {
@@ -306,7 +396,7 @@ func (p *PesPkt) Read(data []byte) (n int) {
 	r.SkipByte(3)
 	streamId := r.ReadBit(8)
 	p.StreamId = streamId
-	r.SkipByte(2)
+	p.Length = r.ReadBit(16)
 	switch {
 	case streamId >= 0xC0 && streamId < 0xF0:
 		fallthrough
@@ -314,27 +404,37 @@ func (p *PesPkt) Read(data []byte) (n int) {
 		r.SkipByte(1)
 		flags := r.ReadBit(2)
 		r.SkipBit(6)
-		r.SkipByte(1)
-		n = 9
+		n = 9 + r.ReadBit(8)
 		if flags == 2 {
 			p.Pts = ParsePts(r)
-			n += 5
 		} else if flags == 3 {
 			p.Pts = ParsePts(r)
 			p.Dts = ParsePts(r)
-			n += 10
 		}
 	case streamId == 0xBE:
 		// Padding stream
+		n = 6
+	case streamId == 0xBC, streamId == 0xBF, streamId == 0xF0, streamId == 0xF1,
+		streamId == 0xF2, streamId == 0xF8, streamId == 0xFF:
+		// No optional PES header
+		n = 6
 	default:
 		fmt.Println("Unknown stream id", streamId)
 	}
+	p.HeaderLen = n
 
 	return
 }
 
+// IsVideoStreamId reports whether the stream_id belongs to a video stream,
+// the only kind allowed to carry an unbounded PES_packet_length of 0.
+func IsVideoStreamId(streamId int) bool {
+	return streamId >= 0xE0 && streamId <= 0xEF
+}
+
 type IFrameInfo struct {
-	Pos int64
-	Pts int64
-	Key bool
+	Pos  int64
+	Pts  int64
+	Key  bool
+	Type string
 }
@@ -0,0 +1,28 @@
+package mpts
+
+var crcTable [256]uint32
+
+func init() {
+	// CRC-32/MPEG-2: polynomial 0x04C11DB7, not reflected
+	for i := 0; i < 256; i++ {
+		crc := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04C11DB7
+			} else {
+				crc <<= 1
+			}
+		}
+		crcTable[i] = crc
+	}
+}
+
+// Crc32 computes the CRC_32 used by PSI and SCTE-35 sections. Running it
+// over a complete section including its CRC_32 field yields 0.
+func Crc32(data []byte) uint32 {
+	crc := uint32(0xFFFFFFFF)
+	for _, b := range data {
+		crc = crc<<8 ^ crcTable[byte(crc>>24)^b]
+	}
+	return crc
+}
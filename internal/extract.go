@@ -0,0 +1,247 @@
+package mpts
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var EsFileExtString map[string]string = map[string]string{
+	"MPEG-1 Video":            ".m1v",
+	"MPEG-2 Video":            ".m2v",
+	"MPEG-1 Audio":            ".mp2",
+	"MPEG-2 Audio":            ".mp2",
+	"MPEG-2 AAC Audio (ADTS)": ".aac",
+	"MPEG-4 AAC Audio (LATM)": ".latm",
+	"MPEG-4 AVC Video":        ".h264",
+	"HEVC Video":              ".h265",
+	"AC-3 Audio":              ".ac3",
+	"E-AC-3 Audio":            ".ec3",
+	"SCTE-35":                 ".scte35",
+	"Private Section":         ".sec",
+}
+
+func GetEsFileExt(t string) string {
+	if ext, ok := EsFileExtString[t]; ok {
+		return ext
+	} else {
+		return ".es"
+	}
+}
+
+// IsSectionStream reports whether the stream type carries sections rather
+// than PES packets.
+func IsSectionStream(t string) bool {
+	return t == "SCTE-35" || t == "Private Section"
+}
+
+// SplitSections splits buffered section data into complete sections,
+// stopping at stuffing bytes or at an incomplete section.
+func SplitSections(data []byte) [][]byte {
+	var sections [][]byte
+	for len(data) >= 3 && data[0] != 0xFF {
+		r := &Reader{Data: data}
+		r.SkipBit(12)
+		n := 3 + r.ReadBit(12)
+		if n > len(data) {
+			break
+		}
+		sections = append(sections, data[:n])
+		data = data[n:]
+	}
+	return sections
+}
+
+// esAuKind tells, for the video streams split into access units, how a unit
+// relates to access units.
+var esAuKind = map[string]func(NalUnit) (int, bool){
+	"MPEG-1 Video":     mp2vAuKind,
+	"MPEG-2 Video":     mp2vAuKind,
+	"MPEG-4 AVC Video": h264AuKind,
+	"HEVC Video":       hevcAuKind,
+}
+
+// EsExtractor writes the elementary stream of one PID, with PES headers or
+// section pointer fields removed. With a time log, the PTS/DTS of each
+// access unit of MPEG-1/2, H.264 and HEVC video, or of each PES of other
+// streams, is written along with its offset in the elementary stream.
+// Duplicate TS packets are dropped.
+type EsExtractor struct {
+	Pid        int
+	StreamType string
+	Out        *os.File
+	TimeLog    *os.File
+	curpkt     *PesPkt
+	curdata    []byte
+	offset     int64
+	auKind     func(NalUnit) (int, bool)
+	splitter   NalSplitter
+	au         AuTimer
+	ContinuityChecker
+}
+
+func NewEsExtractor(pid int, t string, root string, timestamps bool) *EsExtractor {
+	e := &EsExtractor{Pid: pid, StreamType: t}
+	var pidStr string = strconv.Itoa(pid)
+	var err error
+	fname := filepath.Join(root, pidStr+GetEsFileExt(t))
+	e.Out, err = os.Create(fname)
+	if err != nil {
+		panic(err)
+	}
+	if timestamps && !IsSectionStream(t) {
+		fname = filepath.Join(root, pidStr+"-es-time.csv")
+		e.TimeLog, err = os.Create(fname)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Fprintln(e.TimeLog, "Offset, Size, PTS, DTS")
+		e.auKind = esAuKind[t]
+	}
+	return e
+}
+
+func (e *EsExtractor) Process(pkt *TsPkt) {
+	ccOk, duplicate := e.CheckCC(pkt)
+	if duplicate {
+		return
+	}
+	if !ccOk {
+		log.Println("CC error at pkt:", pkt.Pos)
+	}
+	if IsSectionStream(e.StreamType) {
+		e.processSection(pkt)
+	} else {
+		e.processPes(pkt)
+	}
+}
+
+func (e *EsExtractor) processSection(pkt *TsPkt) {
+	if pkt.PUSI == 1 {
+		if len(pkt.Data) == 0 {
+			return
+		}
+		pointer := int(pkt.Data[0])
+		if 1+pointer > len(pkt.Data) {
+			log.Println("Section pointer error at pkt:", pkt.Pos)
+			e.curdata = nil
+			return
+		}
+		if e.curdata != nil {
+			e.curdata = append(e.curdata, pkt.Data[1:1+pointer]...)
+			e.writeSections()
+		}
+		e.curdata = append([]byte{}, pkt.Data[1+pointer:]...)
+	} else if e.curdata != nil {
+		e.curdata = append(e.curdata, pkt.Data...)
+	}
+}
+
+func (e *EsExtractor) writeSections() {
+	for _, section := range SplitSections(e.curdata) {
+		e.Out.Write(section)
+		e.offset += int64(len(section))
+	}
+	e.curdata = nil
+}
+
+func (e *EsExtractor) processPes(pkt *TsPkt) {
+	if pkt.PUSI == 1 {
+		e.writePes(pkt.Data)
+		e.curpkt = &PesPkt{Pos: pkt.Pos}
+	}
+	if e.curpkt != nil {
+		e.curdata = append(e.curdata, pkt.Data...)
+	}
+}
+
+// writePes writes the PES in progress. next is the data of the packet
+// starting the next PES, nil at the end of the stream.
+func (e *EsExtractor) writePes(next []byte) {
+	if e.curpkt == nil {
+		return
+	}
+	p, data := e.curpkt, e.curdata
+	e.curpkt, e.curdata = nil, nil
+
+	var startcode = []byte{0, 0, 1}
+	if len(data) < 9 || bytes.Compare(startcode, data[0:3]) != 0 {
+		log.Println("PES start code error at pkt:", p.Pos)
+		return
+	}
+	hlen := p.Read(data)
+	if p.StreamId == 0xBE || hlen > len(data) {
+		return
+	}
+	payload := data[hlen:]
+	if e.auKind != nil {
+		units := e.splitter.Write(payload)
+		if next == nil || pesPayloadStartsNal(next) {
+			units = append(units, e.splitter.Flush()...)
+		}
+		for _, unit := range units {
+			kind, vcl := e.auKind(unit)
+			e.au.Add(p, unit, kind, vcl, false)
+		}
+	} else if e.TimeLog != nil {
+		dts := p.Dts
+		if dts == 0 {
+			dts = p.Pts
+		}
+		cols := []string{
+			strconv.FormatInt(e.offset, 10),
+			strconv.Itoa(len(payload)),
+			strconv.FormatInt(p.Pts, 10),
+			strconv.FormatInt(dts, 10),
+		}
+		fmt.Fprintln(e.TimeLog, strings.Join(cols, ", "))
+	}
+	e.Out.Write(payload)
+	e.offset += int64(len(payload))
+	if e.auKind != nil {
+		e.logFrames()
+	}
+}
+
+// logFrames writes the completed access units to the time log. An access
+// unit lasts up to the start code of the next one.
+func (e *EsExtractor) logFrames() {
+	for i, f := range e.au.Frames {
+		end := e.offset
+		if i+1 < len(e.au.Frames) {
+			end = e.au.Frames[i+1].Offset
+		} else if e.au.cur != nil {
+			end = e.au.cur.Offset
+		}
+		cols := []string{
+			strconv.FormatInt(f.Offset, 10),
+			strconv.FormatInt(end-f.Offset, 10),
+			formatTimestamp(f.Pts),
+			formatTimestamp(f.Dts),
+		}
+		fmt.Fprintln(e.TimeLog, strings.Join(cols, ", "))
+	}
+	e.au.Frames = e.au.Frames[:0]
+}
+
+func (e *EsExtractor) Close() {
+	if IsSectionStream(e.StreamType) {
+		if e.curdata != nil {
+			e.writeSections()
+		}
+	} else {
+		e.writePes(nil)
+		if e.auKind != nil {
+			e.au.Close()
+			e.logFrames()
+		}
+	}
+	e.Out.Close()
+	if e.TimeLog != nil {
+		e.TimeLog.Close()
+	}
+}
@@ -40,16 +40,16 @@ func GetNalUnitType(b int) string {
 
 func ParseNalUnits(data []byte) []string {
 	var nals []string
-	var pos int
-	var startcode = []byte{0, 0, 1}
-	var startlen = len(startcode)
-	for pos+5 < len(data) {
-		if bytes.Compare(startcode, data[pos:pos+startlen]) == 0 {
-			pos += startlen
-			nal := GetNalUnitType(int(data[pos]))
-			nals = append(nals, nal)
-		}
-		pos += 1
+	for _, nal := range SplitNalUnits(data) {
+		nals = append(nals, GetNalUnitType(int(nal[0])))
+	}
+	return nals
+}
+
+func nalUnitTypes(units []NalUnit) []string {
+	var nals []string
+	for _, nal := range units {
+		nals = append(nals, GetNalUnitType(int(nal.Data[0])))
 	}
 	return nals
 }
@@ -59,6 +59,19 @@ type H264Record struct {
 	curpkt *PesPkt
 	Pkts   []*PesPkt
 	Nals   [][]string
+	// Active parameter sets by id, and every change seen
+	Sps       map[int]*H264Sps
+	Pps       map[int]*H264Pps
+	ParamSets []H264ParamSet
+	Pictures  []*H264Picture
+	poc       H264Poc
+	splitter  NalSplitter
+	au        AuTimer
+	captions  CaptionExtractor
+	afd       AfdTimeline
+	lastSps   *H264Sps
+	rawSps    map[int]string
+	rawPps    map[int]string
 	// Workaround PES parsing error
 	WorkaroundPESFlag bool
 	WorkaroundPES     []byte
@@ -67,19 +80,22 @@ type H264Record struct {
 const minPesHeaderLen = 19
 
 func (s *H264Record) Process(pkt *TsPkt) {
+	ccOk, duplicate := s.CheckCC(pkt)
+	if duplicate {
+		return
+	}
 	s.LogAdaptFieldPrivData(pkt)
 	if pkt.PUSI == 1 {
 		if s.curpkt != nil {
-			nals := ParseNalUnits(s.curpkt.Data)
-			for _, nal := range nals {
-				if nal == "slice_idr" {
-					info := IFrameInfo{}
-					info.Pos = s.curpkt.Pos
-					info.Pts = s.curpkt.Pts
-					info.Key = true
-					s.LogIFrame(info)
-				}
+			s.curpkt.CCError = s.curpkt.CCError || !ccOk
+			s.CheckPes(s.curpkt)
+			units := s.splitter.Write(s.curpkt.Data)
+			if pesPayloadStartsNal(pkt.Data) {
+				units = append(units, s.splitter.Flush()...)
 			}
+			nals := nalUnitTypes(units)
+			pics := s.parseNals(s.curpkt, units)
+			s.logKeyFrame(s.curpkt, nals, pics)
 			s.Nals = append(s.Nals, nals)
 			s.Pkts = append(s.Pkts, s.curpkt)
 		}
@@ -94,6 +110,7 @@ func (s *H264Record) Process(pkt *TsPkt) {
 				pkt.Data = pkt.Data[hlen:]
 			} else {
 				log.Println("PES start code error")
+				s.curpkt.StartCodeError = true
 			}
 		} else {
 			log.Println("Workaround for pkt:", pkt.Pos, "size:", len(pkt.Data))
@@ -102,6 +119,10 @@ func (s *H264Record) Process(pkt *TsPkt) {
 		}
 	}
 
+	if s.curpkt != nil && !ccOk && pkt.PUSI == 0 {
+		s.curpkt.CCError = true
+	}
+
 	if s.WorkaroundPESFlag {
 		s.WorkaroundPES = append(s.WorkaroundPES, pkt.Data...)
 		pkt.Data = nil
@@ -113,6 +134,7 @@ func (s *H264Record) Process(pkt *TsPkt) {
 				s.WorkaroundPESFlag = false
 			} else {
 				log.Println("PES start code error")
+				s.curpkt.StartCodeError = true
 			}
 		}
 	}
@@ -123,9 +145,57 @@ func (s *H264Record) Process(pkt *TsPkt) {
 	}
 }
 
+// parseNals decodes the NAL units of a PES packet the record keeps state
+// for, and returns the pictures they make up, none if their slices could
+// not be parsed. Malformed units are logged and skipped.
+func (s *H264Record) parseNals(p *PesPkt, units []NalUnit) []*H264Picture {
+	var pics []*H264Picture
+	recoveryPoint := false
+	for _, nal := range units {
+		if len(nal.Data) < 2 {
+			continue
+		}
+		kind, vcl := h264AuKind(nal)
+		s.au.Add(p, nal, kind, vcl, nal.Data[0]&0x1F == 5)
+		switch nal.Data[0] & 0x1F {
+		case 1, 5:
+			n := len(pics)
+			s.parseSlice(p, nal, &pics)
+			// The recovery point SEI precedes the picture it applies to
+			if len(pics) > n {
+				pics[n].RecoveryPoint = recoveryPoint
+				recoveryPoint = false
+			}
+		case 6:
+			for _, info := range s.parseH264Sei(p, nal.Rbsp) {
+				if info.PayloadType == 6 {
+					recoveryPoint = true
+				}
+				s.LogSei(info)
+				if u := info.a53(); u != nil {
+					if u.CcData != nil {
+						s.captions.Add(s.au.cur.Pts, u.CcData)
+					}
+					s.afd.Add(s.au.cur.Pos, s.au.cur.Pts, u)
+				}
+			}
+		case 7:
+			s.parseSps(p, nal)
+		case 8:
+			s.parsePps(p, nal)
+		}
+	}
+	s.Pictures = append(s.Pictures, pics...)
+	return pics
+}
+
 func (s *H264Record) Flush() {
 	if s.curpkt != nil {
-		nals := ParseNalUnits(s.curpkt.Data)
+		s.CheckPes(s.curpkt)
+		units := append(s.splitter.Write(s.curpkt.Data), s.splitter.Flush()...)
+		nals := nalUnitTypes(units)
+		pics := s.parseNals(s.curpkt, units)
+		s.logKeyFrame(s.curpkt, nals, pics)
 		s.Nals = append(s.Nals, nals)
 		s.Pkts = append(s.Pkts, s.curpkt)
 	}
@@ -138,6 +208,13 @@ func (s *H264Record) Report(root string) {
 	var pid string = strconv.Itoa(s.Pid)
 	var header string
 
+	s.ReportPesErrors(root)
+	s.reportParamSets(root)
+	s.reportPictures(root)
+	s.au.Report(root, s.Pid)
+	s.captions.Report(root, s.Pid)
+	s.afd.Report(root, s.Pid)
+
 	fname = filepath.Join(root, pid+".csv")
 	w, err = os.Create(fname)
 	if err != nil {
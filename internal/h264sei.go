@@ -0,0 +1,290 @@
+package mpts
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log"
+)
+
+// H.264 Annex D payload types
+var H264SeiTypeString map[int]string = map[int]string{
+	0:   "buffering_period",
+	1:   "pic_timing",
+	2:   "pan_scan_rect",
+	3:   "filler_payload",
+	4:   "user_data_registered_itu_t_t35",
+	5:   "user_data_unregistered",
+	6:   "recovery_point",
+	7:   "dec_ref_pic_marking_repetition",
+	9:   "scene_info",
+	19:  "film_grain_characteristics",
+	45:  "frame_packing_arrangement",
+	47:  "display_orientation",
+	137: "mastering_display_colour_volume",
+	144: "content_light_level_info",
+	147: "alternative_transfer_characteristics",
+}
+
+type BufferingPeriod struct {
+	SeqParameterSetId               int
+	NalInitialCpbRemovalDelay       []int64 `json:",omitempty"`
+	NalInitialCpbRemovalDelayOffset []int64 `json:",omitempty"`
+	VclInitialCpbRemovalDelay       []int64 `json:",omitempty"`
+	VclInitialCpbRemovalDelayOffset []int64 `json:",omitempty"`
+}
+
+type PicTiming struct {
+	CpbRemovalDelay int64
+	DpbOutputDelay  int64
+	PicStruct       int
+	// HEVC only
+	SourceScanType  int              `json:",omitempty"`
+	DuplicateFlag   bool             `json:",omitempty"`
+	ClockTimestamps []ClockTimestamp `json:",omitempty"`
+}
+
+// ClockTimestamp is one clock timestamp of a pic_timing or HEVC time_code
+// message.
+type ClockTimestamp struct {
+	CtType             int
+	NuitFieldBasedFlag bool
+	CountingType       int
+	DiscontinuityFlag  bool
+	CntDroppedFlag     bool
+	NFrames            int
+	Hours              int
+	Minutes            int
+	Seconds            int
+	TimeOffset         int
+}
+
+func (t ClockTimestamp) String() string {
+	sep := ":"
+	if t.CntDroppedFlag {
+		sep = ";"
+	}
+	return fmt.Sprintf("%02d:%02d:%02d%s%02d", t.Hours, t.Minutes, t.Seconds, sep, t.NFrames)
+}
+
+type RecoveryPoint struct {
+	RecoveryFrameCnt      int
+	ExactMatchFlag        bool
+	BrokenLinkFlag        bool
+	ChangingSliceGroupIdc int
+}
+
+// UserDataUnregistered is identified by a UUID, and often carries the
+// encoder name and settings as text.
+type UserDataUnregistered struct {
+	Uuid string
+	Text string `json:",omitempty"`
+}
+
+type FramePacking struct {
+	Id                        int
+	CancelFlag                bool
+	Type                      int
+	QuincunxSamplingFlag      bool
+	ContentInterpretationType int
+	SpatialFlippingFlag       bool
+	Frame0FlippedFlag         bool
+	FieldViewsFlag            bool
+	CurrentFrameIsFrame0Flag  bool
+	RepetitionPeriod          int
+}
+
+// Table D-1, NumClockTS by pic_struct
+var numClockTs []int = []int{1, 1, 1, 2, 2, 3, 3, 2, 3}
+
+func ParseBufferingPeriod(payload []byte, spsList map[int]*H264Sps) *BufferingPeriod {
+	r := NewReader(payload)
+	bp := &BufferingPeriod{}
+	bp.SeqParameterSetId = r.ReadUE()
+	sps, ok := spsList[bp.SeqParameterSetId]
+	if !ok || sps.Vui == nil {
+		return bp
+	}
+	if hrd := sps.Vui.NalHrd; hrd != nil {
+		for i := 0; i < hrd.CpbCnt; i++ {
+			bp.NalInitialCpbRemovalDelay = append(bp.NalInitialCpbRemovalDelay, r.ReadBit64(hrd.InitialCpbRemovalDelayLength))
+			bp.NalInitialCpbRemovalDelayOffset = append(bp.NalInitialCpbRemovalDelayOffset, r.ReadBit64(hrd.InitialCpbRemovalDelayLength))
+		}
+	}
+	if hrd := sps.Vui.VclHrd; hrd != nil {
+		for i := 0; i < hrd.CpbCnt; i++ {
+			bp.VclInitialCpbRemovalDelay = append(bp.VclInitialCpbRemovalDelay, r.ReadBit64(hrd.InitialCpbRemovalDelayLength))
+			bp.VclInitialCpbRemovalDelayOffset = append(bp.VclInitialCpbRemovalDelayOffset, r.ReadBit64(hrd.InitialCpbRemovalDelayLength))
+		}
+	}
+	return bp
+}
+
+// ParsePicTiming parses a pic_timing message with the VUI of the active SPS.
+func ParsePicTiming(payload []byte, sps *H264Sps) *PicTiming {
+	pt := &PicTiming{}
+	if sps == nil || sps.Vui == nil {
+		return pt
+	}
+	r := NewReader(payload)
+	hrd := sps.Vui.NalHrd
+	if hrd == nil {
+		hrd = sps.Vui.VclHrd
+	}
+	if hrd != nil {
+		pt.CpbRemovalDelay = r.ReadBit64(hrd.CpbRemovalDelayLength)
+		pt.DpbOutputDelay = r.ReadBit64(hrd.DpbOutputDelayLength)
+	}
+	if !sps.Vui.PicStructPresentFlag {
+		return pt
+	}
+	pt.PicStruct = r.ReadBit(4)
+	if pt.PicStruct >= len(numClockTs) {
+		return pt
+	}
+	timeOffsetLength := 24
+	if hrd != nil {
+		timeOffsetLength = hrd.TimeOffsetLength
+	}
+	for i := 0; i < numClockTs[pt.PicStruct]; i++ {
+		if !r.ReadFlag() {
+			continue
+		}
+		t := ClockTimestamp{}
+		t.CtType = r.ReadBit(2)
+		t.NuitFieldBasedFlag = r.ReadFlag()
+		t.CountingType = r.ReadBit(5)
+		full := r.ReadFlag()
+		t.DiscontinuityFlag = r.ReadFlag()
+		t.CntDroppedFlag = r.ReadFlag()
+		t.NFrames = r.ReadBit(8)
+		if full {
+			t.Seconds = r.ReadBit(6)
+			t.Minutes = r.ReadBit(6)
+			t.Hours = r.ReadBit(5)
+		} else if r.ReadFlag() {
+			t.Seconds = r.ReadBit(6)
+			if r.ReadFlag() {
+				t.Minutes = r.ReadBit(6)
+				if r.ReadFlag() {
+					t.Hours = r.ReadBit(5)
+				}
+			}
+		}
+		if timeOffsetLength > 0 {
+			v := r.ReadBit(timeOffsetLength)
+			// Two's complement of timeOffsetLength bits
+			if v >= 1<<uint(timeOffsetLength-1) {
+				v -= 1 << uint(timeOffsetLength)
+			}
+			t.TimeOffset = v
+		}
+		pt.ClockTimestamps = append(pt.ClockTimestamps, t)
+	}
+	return pt
+}
+
+func ParseRecoveryPoint(payload []byte) *RecoveryPoint {
+	r := NewReader(payload)
+	rp := &RecoveryPoint{}
+	rp.RecoveryFrameCnt = r.ReadUE()
+	rp.ExactMatchFlag = r.ReadFlag()
+	rp.BrokenLinkFlag = r.ReadFlag()
+	rp.ChangingSliceGroupIdc = r.ReadBit(2)
+	return rp
+}
+
+func ParseUserDataUnregistered(payload []byte) *UserDataUnregistered {
+	if len(payload) < 16 {
+		return nil
+	}
+	u := &UserDataUnregistered{}
+	h := hex.EncodeToString(payload[:16])
+	u.Uuid = h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
+	text := payload[16:]
+	for len(text) > 0 && text[len(text)-1] == 0 {
+		text = text[:len(text)-1]
+	}
+	printable := len(text) > 0
+	for _, c := range text {
+		if c < 0x20 && c != '\n' && c != '\t' || c > 0x7E {
+			printable = false
+			break
+		}
+	}
+	if printable {
+		u.Text = string(text)
+	}
+	return u
+}
+
+func ParseFramePacking(payload []byte) *FramePacking {
+	r := NewReader(payload)
+	fp := &FramePacking{}
+	fp.Id = r.ReadUE()
+	fp.CancelFlag = r.ReadFlag()
+	if fp.CancelFlag {
+		return fp
+	}
+	fp.Type = r.ReadBit(7)
+	fp.QuincunxSamplingFlag = r.ReadFlag()
+	fp.ContentInterpretationType = r.ReadBit(6)
+	fp.SpatialFlippingFlag = r.ReadFlag()
+	fp.Frame0FlippedFlag = r.ReadFlag()
+	fp.FieldViewsFlag = r.ReadFlag()
+	fp.CurrentFrameIsFrame0Flag = r.ReadFlag()
+	r.SkipBit(2) // frame0_self_contained_flag, frame1_self_contained_flag
+	if !fp.QuincunxSamplingFlag && fp.Type != 5 {
+		r.SkipBit(16) // frame grid positions
+	}
+	r.SkipBit(8) // frame_packing_arrangement_reserved_byte
+	fp.RepetitionPeriod = r.ReadUE()
+	return fp
+}
+
+// parseH264Sei decodes the messages of an SEI NAL unit.
+func (s *H264Record) parseH264Sei(p *PesPkt, rbsp []byte) []SeiInfo {
+	var infos []SeiInfo
+	for _, msg := range ParseSeiMessages(rbsp, 1) {
+		info := SeiInfo{Pos: p.Pos, Pts: p.Pts, PayloadType: msg.PayloadType}
+		info.Name = H264SeiTypeString[msg.PayloadType]
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					log.Println("SEI parsing error at", p.Pos, r)
+				}
+			}()
+			switch msg.PayloadType {
+			case 0:
+				info.BufferingPeriod = ParseBufferingPeriod(msg.Payload, s.Sps)
+			case 1:
+				info.PicTiming = ParsePicTiming(msg.Payload, s.activeSps())
+			case 4:
+				info.Registered = ParseItuT35(msg.Payload)
+			case 5:
+				info.Unregistered = ParseUserDataUnregistered(msg.Payload)
+			case 6:
+				info.RecoveryPoint = ParseRecoveryPoint(msg.Payload)
+			case 45:
+				info.FramePacking = ParseFramePacking(msg.Payload)
+			default:
+				info.Payload = hex.EncodeToString(msg.Payload)
+			}
+		}()
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// activeSps returns the SPS of the last parsed slice, or the SPS with the
+// lowest id when no slice has been parsed yet.
+func (s *H264Record) activeSps() *H264Sps {
+	if s.lastSps != nil {
+		return s.lastSps
+	}
+	for id := 0; id < 32; id++ {
+		if sps, ok := s.Sps[id]; ok {
+			return sps
+		}
+	}
+	return nil
+}
@@ -0,0 +1,444 @@
+package mpts
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// slice_type % 5, Table 7-6
+var H264SliceTypeString []string = []string{"P", "B", "I", "SP", "SI"}
+
+type H264SliceHeader struct {
+	NalRefIdc              int
+	NalUnitType            int
+	FirstMbInSlice         int
+	SliceType              int
+	PicParameterSetId      int
+	ColourPlaneId          int
+	FrameNum               int
+	FieldPicFlag           bool
+	BottomFieldFlag        bool
+	IdrPicId               int
+	PicOrderCntLsb         int
+	DeltaPicOrderCntBottom int
+	DeltaPicOrderCnt       [2]int
+}
+
+func (h *H264SliceHeader) IsIntra() bool {
+	t := h.SliceType % 5
+	return t == 2 || t == 4
+}
+
+// ParseH264SliceHeader parses the start of a slice_header up to the picture
+// order count fields, using the parameter sets it refers to. It returns nil
+// if they have not been received.
+func ParseH264SliceHeader(rbsp []byte, spsList map[int]*H264Sps, ppsList map[int]*H264Pps) (*H264SliceHeader, *H264Sps, *H264Pps) {
+	h := &H264SliceHeader{}
+	r := NewReader(rbsp)
+	r.SkipBit(1)
+	h.NalRefIdc = r.ReadBit(2)
+	h.NalUnitType = r.ReadBit(5)
+	h.FirstMbInSlice = r.ReadUE()
+	h.SliceType = r.ReadUE()
+	h.PicParameterSetId = r.ReadUE()
+	pps, ok := ppsList[h.PicParameterSetId]
+	if !ok {
+		return nil, nil, nil
+	}
+	sps, ok := spsList[pps.SeqParameterSetId]
+	if !ok {
+		return nil, nil, nil
+	}
+	if sps.SeparateColourPlaneFlag {
+		h.ColourPlaneId = r.ReadBit(2)
+	}
+	h.FrameNum = r.ReadBit(sps.Log2MaxFrameNum)
+	if !sps.FrameMbsOnlyFlag {
+		h.FieldPicFlag = r.ReadFlag()
+		if h.FieldPicFlag {
+			h.BottomFieldFlag = r.ReadFlag()
+		}
+	}
+	if h.NalUnitType == 5 {
+		h.IdrPicId = r.ReadUE()
+	}
+	if sps.PicOrderCntType == 0 {
+		h.PicOrderCntLsb = r.ReadBit(sps.Log2MaxPicOrderCntLsb)
+		if pps.BottomFieldPicOrderInFramePresentFlag && !h.FieldPicFlag {
+			h.DeltaPicOrderCntBottom = r.ReadSE()
+		}
+	}
+	if sps.PicOrderCntType == 1 && !sps.DeltaPicOrderAlwaysZeroFlag {
+		h.DeltaPicOrderCnt[0] = r.ReadSE()
+		if pps.BottomFieldPicOrderInFramePresentFlag && !h.FieldPicFlag {
+			h.DeltaPicOrderCnt[1] = r.ReadSE()
+		}
+	}
+	return h, sps, pps
+}
+
+// H264Poc keeps the state of the picture order count decoding, 8.2.1.
+// Memory management operation 5 is not taken into account.
+type H264Poc struct {
+	prevPicOrderCntMsb int
+	prevPicOrderCntLsb int
+	prevFrameNumOffset int
+	prevFrameNum       int
+}
+
+// Next returns the picture order count of the picture starting with h.
+func (p *H264Poc) Next(h *H264SliceHeader, sps *H264Sps) int {
+	idr := h.NalUnitType == 5
+	maxFrameNum := 1 << uint(sps.Log2MaxFrameNum)
+	var top, bottom int
+
+	switch sps.PicOrderCntType {
+	case 0:
+		if idr {
+			p.prevPicOrderCntMsb = 0
+			p.prevPicOrderCntLsb = 0
+		}
+		maxLsb := 1 << uint(sps.Log2MaxPicOrderCntLsb)
+		lsb := h.PicOrderCntLsb
+		msb := p.prevPicOrderCntMsb
+		if lsb < p.prevPicOrderCntLsb && p.prevPicOrderCntLsb-lsb >= maxLsb/2 {
+			msb += maxLsb
+		} else if lsb > p.prevPicOrderCntLsb && lsb-p.prevPicOrderCntLsb > maxLsb/2 {
+			msb -= maxLsb
+		}
+		top = msb + lsb
+		bottom = top + h.DeltaPicOrderCntBottom
+		if h.FieldPicFlag {
+			bottom = msb + lsb
+		}
+		if h.NalRefIdc != 0 {
+			p.prevPicOrderCntMsb = msb
+			p.prevPicOrderCntLsb = lsb
+		}
+	case 1, 2:
+		frameNumOffset := 0
+		if !idr {
+			frameNumOffset = p.prevFrameNumOffset
+			if p.prevFrameNum > h.FrameNum {
+				frameNumOffset += maxFrameNum
+			}
+		}
+		if sps.PicOrderCntType == 2 {
+			if !idr {
+				top = 2 * (frameNumOffset + h.FrameNum)
+				if h.NalRefIdc == 0 {
+					top -= 1
+				}
+			}
+			bottom = top
+		} else {
+			n := len(sps.OffsetForRefFrame)
+			absFrameNum := 0
+			if n != 0 {
+				absFrameNum = frameNumOffset + h.FrameNum
+			}
+			if h.NalRefIdc == 0 && absFrameNum > 0 {
+				absFrameNum -= 1
+			}
+			expected := 0
+			if absFrameNum > 0 {
+				deltaPerCycle := 0
+				for _, offset := range sps.OffsetForRefFrame {
+					deltaPerCycle += offset
+				}
+				expected = (absFrameNum - 1) / n * deltaPerCycle
+				for i := 0; i <= (absFrameNum-1)%n; i++ {
+					expected += sps.OffsetForRefFrame[i]
+				}
+			}
+			if h.NalRefIdc == 0 {
+				expected += sps.OffsetForNonRefPic
+			}
+			top = expected + h.DeltaPicOrderCnt[0]
+			bottom = top + sps.OffsetForTopToBottomField + h.DeltaPicOrderCnt[1]
+			if h.FieldPicFlag {
+				bottom = expected + sps.OffsetForTopToBottomField + h.DeltaPicOrderCnt[0]
+			}
+		}
+		p.prevFrameNumOffset = frameNumOffset
+		p.prevFrameNum = h.FrameNum
+	}
+
+	if h.FieldPicFlag {
+		if h.BottomFieldFlag {
+			return bottom
+		}
+		return top
+	}
+	if bottom < top {
+		return bottom
+	}
+	return top
+}
+
+// H264Picture describes a coded frame or field. Pts and Dts are those of
+// the PES packet it starts in, and -1 for the pictures following the first
+// one of a PES packet.
+type H264Picture struct {
+	Pos           int64
+	Pts           int64
+	Dts           int64
+	Type          string
+	Idr           bool
+	RecoveryPoint bool
+	FrameNum      int
+	Poc           int
+	Field         bool
+	BottomField   bool
+	Mbaff         bool
+	NalRefIdc     int
+	Slices        int
+	header        *H264SliceHeader // of the first slice
+	pair          *H264Picture     // the first field, if this is the second
+}
+
+// opensGop reports whether the picture is an IDR, I or recovery point
+// picture, and not the second field of one.
+func (pic *H264Picture) opensGop() bool {
+	if pic.pair != nil && pic.pair.opensGop() {
+		return false
+	}
+	return pic.Idr || pic.IsIntra() || pic.RecoveryPoint
+}
+
+// startsPicture reports whether slice h is the first slice of a picture
+// following the one prev belongs to, 7.4.1.2.4. Slices are expected in
+// order, so a first_mb_in_slice of 0 starts a picture as well.
+func startsPicture(prev, h *H264SliceHeader) bool {
+	idr := h.NalUnitType == 5
+	return h.FirstMbInSlice == 0 ||
+		h.FrameNum != prev.FrameNum ||
+		h.PicParameterSetId != prev.PicParameterSetId ||
+		h.FieldPicFlag != prev.FieldPicFlag ||
+		h.BottomFieldFlag != prev.BottomFieldFlag ||
+		(h.NalRefIdc == 0) != (prev.NalRefIdc == 0) ||
+		idr != (prev.NalUnitType == 5) ||
+		idr && h.IdrPicId != prev.IdrPicId ||
+		h.PicOrderCntLsb != prev.PicOrderCntLsb ||
+		h.DeltaPicOrderCntBottom != prev.DeltaPicOrderCntBottom ||
+		h.DeltaPicOrderCnt != prev.DeltaPicOrderCnt
+}
+
+// addSlice merges the slice into the picture type: I when all slices are
+// intra, B when any slice is bi-predicted, P otherwise.
+func (pic *H264Picture) addSlice(h *H264SliceHeader) {
+	t := H264SliceTypeString[h.SliceType%5]
+	switch {
+	case pic.Slices == 0:
+		pic.Type = t
+	case t == "B" || pic.Type == "B":
+		pic.Type = "B"
+	case !h.IsIntra() || (pic.Type != "I" && pic.Type != "SI"):
+		pic.Type = "P"
+	}
+	pic.Slices += 1
+}
+
+func (pic *H264Picture) IsIntra() bool {
+	return pic.Type == "I" || pic.Type == "SI"
+}
+
+// parseSlice adds the slice to the last of pics, or appends the picture it
+// starts.
+func (s *H264Record) parseSlice(p *PesPkt, nal NalUnit, pics *[]*H264Picture) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Println("Slice header parsing error at", p.Pos, r)
+		}
+	}()
+	h, sps, _ := ParseH264SliceHeader(nal.Rbsp, s.Sps, s.Pps)
+	if h == nil {
+		return
+	}
+	s.lastSps = sps
+	n := len(*pics)
+	if n == 0 || startsPicture((*pics)[n-1].header, h) {
+		pic := &H264Picture{
+			Pos:         p.Pos,
+			Pts:         p.Pts,
+			Dts:         p.Dts,
+			Idr:         h.NalUnitType == 5,
+			FrameNum:    h.FrameNum,
+			Poc:         s.poc.Next(h, sps),
+			Field:       h.FieldPicFlag,
+			BottomField: h.BottomFieldFlag,
+			Mbaff:       sps.MbAdaptiveFrameFieldFlag && !h.FieldPicFlag,
+			NalRefIdc:   h.NalRefIdc,
+			header:      h,
+		}
+		prev := s.lastPicture(*pics)
+		if n > 0 {
+			pic.Pts, pic.Dts = -1, -1
+		}
+		// The second field of a frame follows the first with the same
+		// frame_num and the opposite parity
+		if prev != nil && prev.pair == nil && prev.Field && pic.Field &&
+			prev.BottomField != pic.BottomField && prev.FrameNum == pic.FrameNum {
+			pic.pair = prev
+		}
+		*pics = append(*pics, pic)
+	}
+	(*pics)[len(*pics)-1].addSlice(h)
+}
+
+// lastPicture returns the picture decoded last, from pics or before them.
+func (s *H264Record) lastPicture(pics []*H264Picture) *H264Picture {
+	if len(pics) > 0 {
+		return pics[len(pics)-1]
+	}
+	if len(s.Pictures) > 0 {
+		return s.Pictures[len(s.Pictures)-1]
+	}
+	return nil
+}
+
+// logKeyFrame logs IDR pictures, I pictures and recovery points, once per
+// frame for field pairs. Without parameter sets, only the IDR NAL units can
+// be told.
+func (s *H264Record) logKeyFrame(p *PesPkt, nals []string, pics []*H264Picture) {
+	if len(pics) == 0 {
+		info := IFrameInfo{Pos: p.Pos, Pts: p.Pts}
+		for _, nal := range nals {
+			if nal == "slice_idr" {
+				info.Key = true
+				info.Type = "IDR"
+				s.LogIFrame(info)
+				return
+			}
+		}
+		return
+	}
+	for _, pic := range pics {
+		if !pic.opensGop() {
+			continue
+		}
+		info := IFrameInfo{Pos: pic.Pos, Pts: pic.Pts}
+		switch {
+		case pic.Idr:
+			info.Key = true
+			info.Type = "IDR"
+		case pic.IsIntra():
+			info.Type = "I"
+		default:
+			info.Type = "RecoveryPoint"
+		}
+		s.LogIFrame(info)
+	}
+}
+
+// H264Gop is a group of pictures starting with an IDR, I or recovery point
+// picture. Pattern lists the picture types in presentation order; the GOP
+// is open when pictures following the first one are presented before it.
+// Pictures with no PTS of their own are placed after the one decoded
+// before them.
+type H264Gop struct {
+	Pos     int64
+	Pts     int64
+	Length  int
+	Pattern string
+	Closed  bool
+}
+
+func (s *H264Record) Gops() []H264Gop {
+	var gops []H264Gop
+	var pics []*H264Picture
+	flush := func() {
+		if len(pics) == 0 {
+			return
+		}
+		first := pics[0]
+		gop := H264Gop{Pos: first.Pos, Pts: first.Pts, Length: len(pics), Closed: true}
+		pts := make(map[*H264Picture]int64)
+		for i, pic := range pics {
+			pts[pic] = pic.Pts
+			if pic.Pts < 0 && i > 0 {
+				pts[pic] = pts[pics[i-1]]
+			}
+		}
+		sorted := append([]*H264Picture{}, pics...)
+		sort.SliceStable(sorted, func(i, j int) bool {
+			return ptsDiff(pts[sorted[i]], pts[sorted[j]]) < 0
+		})
+		for _, pic := range sorted {
+			gop.Pattern += pic.Type
+			if pic.Pts >= 0 && first.Pts >= 0 && ptsDiff(pic.Pts, first.Pts) < 0 && !first.Idr {
+				gop.Closed = false
+			}
+		}
+		gops = append(gops, gop)
+		pics = nil
+	}
+	for _, pic := range s.Pictures {
+		if pic.opensGop() {
+			flush()
+		}
+		pics = append(pics, pic)
+	}
+	flush()
+	return gops
+}
+
+func (s *H264Record) reportPictures(root string) {
+	if len(s.Pictures) == 0 {
+		return
+	}
+	pid := strconv.Itoa(s.Pid)
+
+	fname := filepath.Join(root, pid+"-picture.csv")
+	w, err := os.Create(fname)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Fprintln(w, "Pos, PTS, DTS, Type, IDR, RecoveryPoint, FrameNum, POC, Field, MBAFF, RefIdc")
+	for _, pic := range s.Pictures {
+		structure := "frame"
+		if pic.Field && pic.BottomField {
+			structure = "bottom"
+		} else if pic.Field {
+			structure = "top"
+		}
+		cols := []string{
+			strconv.FormatInt(pic.Pos, 10),
+			strconv.FormatInt(pic.Pts, 10),
+			strconv.FormatInt(pic.Dts, 10),
+			pic.Type,
+			strconv.FormatBool(pic.Idr),
+			strconv.FormatBool(pic.RecoveryPoint),
+			strconv.Itoa(pic.FrameNum),
+			strconv.Itoa(pic.Poc),
+			structure,
+			strconv.FormatBool(pic.Mbaff),
+			strconv.Itoa(pic.NalRefIdc),
+		}
+		fmt.Fprintln(w, strings.Join(cols, ", "))
+	}
+	w.Close()
+
+	fname = filepath.Join(root, pid+"-gop.csv")
+	w, err = os.Create(fname)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Fprintln(w, "Pos, PTS, Length, Pattern, Closed")
+	for _, gop := range s.Gops() {
+		cols := []string{
+			strconv.FormatInt(gop.Pos, 10),
+			strconv.FormatInt(gop.Pts, 10),
+			strconv.Itoa(gop.Length),
+			gop.Pattern,
+			strconv.FormatBool(gop.Closed),
+		}
+		fmt.Fprintln(w, strings.Join(cols, ", "))
+	}
+	w.Close()
+}
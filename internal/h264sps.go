@@ -0,0 +1,479 @@
+package mpts
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// H.264 profile_idc values, Annex A
+var H264ProfileString map[int]string = map[int]string{
+	44:  "CAVLC 4:4:4 Intra",
+	66:  "Baseline",
+	77:  "Main",
+	83:  "Scalable Baseline",
+	86:  "Scalable High",
+	88:  "Extended",
+	100: "High",
+	110: "High 10",
+	118: "Multiview High",
+	122: "High 4:2:2",
+	128: "Stereo High",
+	244: "High 4:4:4 Predictive",
+}
+
+var ChromaFormatString map[int]string = map[int]string{
+	0: "4:0:0",
+	1: "4:2:0",
+	2: "4:2:2",
+	3: "4:4:4",
+}
+
+// Table E-1
+var sampleAspectRatio [][2]int = [][2]int{
+	{0, 0}, {1, 1}, {12, 11}, {10, 11}, {16, 11}, {40, 33}, {24, 11}, {20, 11},
+	{32, 11}, {80, 33}, {18, 11}, {15, 11}, {64, 33}, {160, 99}, {4, 3}, {3, 2}, {2, 1},
+}
+
+const extendedSar = 255
+
+type H264Sps struct {
+	ProfileIdc                      int
+	Profile                         string
+	ConstraintFlags                 int
+	LevelIdc                        int
+	SeqParameterSetId               int
+	ChromaFormatIdc                 int
+	ChromaFormat                    string
+	SeparateColourPlaneFlag         bool
+	BitDepthLuma                    int
+	BitDepthChroma                  int
+	QpprimeYZeroTransformBypassFlag bool
+	SeqScalingMatrixPresentFlag     bool
+	Log2MaxFrameNum                 int
+	PicOrderCntType                 int
+	Log2MaxPicOrderCntLsb           int
+	DeltaPicOrderAlwaysZeroFlag     bool
+	OffsetForNonRefPic              int
+	OffsetForTopToBottomField       int
+	OffsetForRefFrame               []int
+	MaxNumRefFrames                 int
+	GapsInFrameNumAllowedFlag       bool
+	PicWidthInMbs                   int
+	PicHeightInMapUnits             int
+	FrameMbsOnlyFlag                bool
+	MbAdaptiveFrameFieldFlag        bool
+	Direct8x8InferenceFlag          bool
+	FrameCroppingFlag               bool
+	FrameCropLeftOffset             int
+	FrameCropRightOffset            int
+	FrameCropTopOffset              int
+	FrameCropBottomOffset           int
+	VuiParametersPresentFlag        bool
+	Vui                             *H264Vui `json:",omitempty"`
+	// Derived from the fields above
+	Width     int
+	Height    int
+	FrameRate float64
+}
+
+type H264Vui struct {
+	AspectRatioInfoPresentFlag         bool
+	AspectRatioIdc                     int
+	SarWidth                           int
+	SarHeight                          int
+	OverscanInfoPresentFlag            bool
+	OverscanAppropriateFlag            bool
+	VideoSignalTypePresentFlag         bool
+	VideoFormat                        int
+	VideoFullRangeFlag                 bool
+	ColourDescriptionPresentFlag       bool
+	ColourPrimaries                    int
+	TransferCharacteristics            int
+	MatrixCoefficients                 int
+	ChromaLocInfoPresentFlag           bool
+	ChromaSampleLocTypeTopField        int
+	ChromaSampleLocTypeBottomField     int
+	TimingInfoPresentFlag              bool
+	NumUnitsInTick                     int64
+	TimeScale                          int64
+	FixedFrameRateFlag                 bool
+	NalHrd                             *H264Hrd `json:",omitempty"`
+	VclHrd                             *H264Hrd `json:",omitempty"`
+	LowDelayHrdFlag                    bool
+	PicStructPresentFlag               bool
+	BitstreamRestrictionFlag           bool
+	MotionVectorsOverPicBoundariesFlag bool
+	MaxBytesPerPicDenom                int
+	MaxBitsPerMbDenom                  int
+	Log2MaxMvLengthHorizontal          int
+	Log2MaxMvLengthVertical            int
+	MaxNumReorderFrames                int
+	MaxDecFrameBuffering               int
+}
+
+type H264Hrd struct {
+	CpbCnt                       int
+	BitRateScale                 int
+	CpbSizeScale                 int
+	BitRate                      []int64
+	CpbSize                      []int64
+	CbrFlag                      []bool
+	InitialCpbRemovalDelayLength int
+	CpbRemovalDelayLength        int
+	DpbOutputDelayLength         int
+	TimeOffsetLength             int
+}
+
+type H264Pps struct {
+	PicParameterSetId                     int
+	SeqParameterSetId                     int
+	EntropyCodingModeFlag                 bool
+	BottomFieldPicOrderInFramePresentFlag bool
+	NumSliceGroups                        int
+	SliceGroupMapType                     int
+	NumRefIdxL0DefaultActive              int
+	NumRefIdxL1DefaultActive              int
+	WeightedPredFlag                      bool
+	WeightedBipredIdc                     int
+	PicInitQp                             int
+	PicInitQs                             int
+	ChromaQpIndexOffset                   int
+	DeblockingFilterControlPresentFlag    bool
+	ConstrainedIntraPredFlag              bool
+	RedundantPicCntPresentFlag            bool
+	Transform8x8ModeFlag                  bool
+	PicScalingMatrixPresentFlag           bool
+	SecondChromaQpIndexOffset             int
+}
+
+// hasChromaInfo lists the profiles whose SPS carries chroma_format_idc.
+func hasChromaInfo(profile int) bool {
+	switch profile {
+	case 100, 110, 122, 244, 44, 83, 86, 118, 128, 138, 139, 134, 135:
+		return true
+	}
+	return false
+}
+
+// skipScalingList reads past a scaling_list() of the given size.
+func skipScalingList(r *Reader, size int) {
+	last, next := 8, 8
+	for j := 0; j < size; j++ {
+		if next != 0 {
+			delta := r.ReadSE()
+			next = (last + delta + 256) % 256
+		}
+		if next != 0 {
+			last = next
+		}
+	}
+}
+
+// ParseH264Sps parses the RBSP of a seq_parameter_set, NAL header included.
+func ParseH264Sps(rbsp []byte) *H264Sps {
+	sps := &H264Sps{}
+	r := NewReader(rbsp)
+	r.SkipByte(1)
+	sps.ProfileIdc = r.ReadBit(8)
+	sps.Profile = H264ProfileString[sps.ProfileIdc]
+	sps.ConstraintFlags = r.ReadBit(8)
+	sps.LevelIdc = r.ReadBit(8)
+	sps.SeqParameterSetId = r.ReadUE()
+	sps.ChromaFormatIdc = 1
+	sps.BitDepthLuma = 8
+	sps.BitDepthChroma = 8
+	if hasChromaInfo(sps.ProfileIdc) {
+		sps.ChromaFormatIdc = r.ReadUE()
+		if sps.ChromaFormatIdc == 3 {
+			sps.SeparateColourPlaneFlag = r.ReadFlag()
+		}
+		sps.BitDepthLuma = r.ReadUE() + 8
+		sps.BitDepthChroma = r.ReadUE() + 8
+		sps.QpprimeYZeroTransformBypassFlag = r.ReadFlag()
+		sps.SeqScalingMatrixPresentFlag = r.ReadFlag()
+		if sps.SeqScalingMatrixPresentFlag {
+			n := 8
+			if sps.ChromaFormatIdc == 3 {
+				n = 12
+			}
+			for i := 0; i < n; i++ {
+				if r.ReadFlag() {
+					if i < 6 {
+						skipScalingList(r, 16)
+					} else {
+						skipScalingList(r, 64)
+					}
+				}
+			}
+		}
+	}
+	sps.ChromaFormat = ChromaFormatString[sps.ChromaFormatIdc]
+	sps.Log2MaxFrameNum = r.ReadUE() + 4
+	sps.PicOrderCntType = r.ReadUE()
+	if sps.PicOrderCntType == 0 {
+		sps.Log2MaxPicOrderCntLsb = r.ReadUE() + 4
+	} else if sps.PicOrderCntType == 1 {
+		sps.DeltaPicOrderAlwaysZeroFlag = r.ReadFlag()
+		sps.OffsetForNonRefPic = r.ReadSE()
+		sps.OffsetForTopToBottomField = r.ReadSE()
+		n := r.ReadUE()
+		for i := 0; i < n; i++ {
+			sps.OffsetForRefFrame = append(sps.OffsetForRefFrame, r.ReadSE())
+		}
+	}
+	sps.MaxNumRefFrames = r.ReadUE()
+	sps.GapsInFrameNumAllowedFlag = r.ReadFlag()
+	sps.PicWidthInMbs = r.ReadUE() + 1
+	sps.PicHeightInMapUnits = r.ReadUE() + 1
+	sps.FrameMbsOnlyFlag = r.ReadFlag()
+	if !sps.FrameMbsOnlyFlag {
+		sps.MbAdaptiveFrameFieldFlag = r.ReadFlag()
+	}
+	sps.Direct8x8InferenceFlag = r.ReadFlag()
+	sps.FrameCroppingFlag = r.ReadFlag()
+	if sps.FrameCroppingFlag {
+		sps.FrameCropLeftOffset = r.ReadUE()
+		sps.FrameCropRightOffset = r.ReadUE()
+		sps.FrameCropTopOffset = r.ReadUE()
+		sps.FrameCropBottomOffset = r.ReadUE()
+	}
+	sps.VuiParametersPresentFlag = r.ReadFlag()
+	if sps.VuiParametersPresentFlag {
+		sps.Vui = parseH264Vui(r)
+	}
+
+	// Frame size after cropping, 7.4.2.1.1
+	frameHeightInMbs := sps.PicHeightInMapUnits
+	if !sps.FrameMbsOnlyFlag {
+		frameHeightInMbs *= 2
+	}
+	cropX, cropY := 1, 2-b2i(sps.FrameMbsOnlyFlag)
+	if sps.ChromaFormatIdc != 0 && !sps.SeparateColourPlaneFlag {
+		subWidth, subHeight := 2, 2
+		if sps.ChromaFormatIdc == 2 {
+			subHeight = 1
+		} else if sps.ChromaFormatIdc == 3 {
+			subWidth, subHeight = 1, 1
+		}
+		cropX, cropY = subWidth, subHeight*cropY
+	}
+	sps.Width = sps.PicWidthInMbs*16 - cropX*(sps.FrameCropLeftOffset+sps.FrameCropRightOffset)
+	sps.Height = frameHeightInMbs*16 - cropY*(sps.FrameCropTopOffset+sps.FrameCropBottomOffset)
+	if sps.Vui != nil && sps.Vui.TimingInfoPresentFlag && sps.Vui.NumUnitsInTick > 0 {
+		sps.FrameRate = float64(sps.Vui.TimeScale) / float64(2*sps.Vui.NumUnitsInTick)
+	}
+	return sps
+}
+
+func parseH264Vui(r *Reader) *H264Vui {
+	vui := &H264Vui{}
+	vui.AspectRatioInfoPresentFlag = r.ReadFlag()
+	if vui.AspectRatioInfoPresentFlag {
+		vui.AspectRatioIdc = r.ReadBit(8)
+		if vui.AspectRatioIdc == extendedSar {
+			vui.SarWidth = r.ReadBit(16)
+			vui.SarHeight = r.ReadBit(16)
+		} else if vui.AspectRatioIdc < len(sampleAspectRatio) {
+			vui.SarWidth = sampleAspectRatio[vui.AspectRatioIdc][0]
+			vui.SarHeight = sampleAspectRatio[vui.AspectRatioIdc][1]
+		}
+	}
+	vui.OverscanInfoPresentFlag = r.ReadFlag()
+	if vui.OverscanInfoPresentFlag {
+		vui.OverscanAppropriateFlag = r.ReadFlag()
+	}
+	vui.VideoSignalTypePresentFlag = r.ReadFlag()
+	if vui.VideoSignalTypePresentFlag {
+		vui.VideoFormat = r.ReadBit(3)
+		vui.VideoFullRangeFlag = r.ReadFlag()
+		vui.ColourDescriptionPresentFlag = r.ReadFlag()
+		if vui.ColourDescriptionPresentFlag {
+			vui.ColourPrimaries = r.ReadBit(8)
+			vui.TransferCharacteristics = r.ReadBit(8)
+			vui.MatrixCoefficients = r.ReadBit(8)
+		}
+	}
+	vui.ChromaLocInfoPresentFlag = r.ReadFlag()
+	if vui.ChromaLocInfoPresentFlag {
+		vui.ChromaSampleLocTypeTopField = r.ReadUE()
+		vui.ChromaSampleLocTypeBottomField = r.ReadUE()
+	}
+	vui.TimingInfoPresentFlag = r.ReadFlag()
+	if vui.TimingInfoPresentFlag {
+		vui.NumUnitsInTick = r.ReadBit64(32)
+		vui.TimeScale = r.ReadBit64(32)
+		vui.FixedFrameRateFlag = r.ReadFlag()
+	}
+	if r.ReadFlag() {
+		vui.NalHrd = parseH264Hrd(r)
+	}
+	if r.ReadFlag() {
+		vui.VclHrd = parseH264Hrd(r)
+	}
+	if vui.NalHrd != nil || vui.VclHrd != nil {
+		vui.LowDelayHrdFlag = r.ReadFlag()
+	}
+	vui.PicStructPresentFlag = r.ReadFlag()
+	vui.BitstreamRestrictionFlag = r.ReadFlag()
+	if vui.BitstreamRestrictionFlag {
+		vui.MotionVectorsOverPicBoundariesFlag = r.ReadFlag()
+		vui.MaxBytesPerPicDenom = r.ReadUE()
+		vui.MaxBitsPerMbDenom = r.ReadUE()
+		vui.Log2MaxMvLengthHorizontal = r.ReadUE()
+		vui.Log2MaxMvLengthVertical = r.ReadUE()
+		vui.MaxNumReorderFrames = r.ReadUE()
+		vui.MaxDecFrameBuffering = r.ReadUE()
+	}
+	return vui
+}
+
+// parseH264Hrd reads hrd_parameters(); bit rates and CPB sizes are in bits.
+func parseH264Hrd(r *Reader) *H264Hrd {
+	hrd := &H264Hrd{}
+	hrd.CpbCnt = r.ReadUE() + 1
+	hrd.BitRateScale = r.ReadBit(4)
+	hrd.CpbSizeScale = r.ReadBit(4)
+	for i := 0; i < hrd.CpbCnt; i++ {
+		bitRate := int64(r.ReadUE()) + 1
+		cpbSize := int64(r.ReadUE()) + 1
+		hrd.BitRate = append(hrd.BitRate, bitRate<<uint(6+hrd.BitRateScale))
+		hrd.CpbSize = append(hrd.CpbSize, cpbSize<<uint(4+hrd.CpbSizeScale))
+		hrd.CbrFlag = append(hrd.CbrFlag, r.ReadFlag())
+	}
+	hrd.InitialCpbRemovalDelayLength = r.ReadBit(5) + 1
+	hrd.CpbRemovalDelayLength = r.ReadBit(5) + 1
+	hrd.DpbOutputDelayLength = r.ReadBit(5) + 1
+	hrd.TimeOffsetLength = r.ReadBit(5)
+	return hrd
+}
+
+// ParseH264Pps parses the RBSP of a pic_parameter_set, NAL header included.
+func ParseH264Pps(rbsp []byte) *H264Pps {
+	pps := &H264Pps{}
+	r := NewReader(rbsp)
+	r.SkipByte(1)
+	pps.PicParameterSetId = r.ReadUE()
+	pps.SeqParameterSetId = r.ReadUE()
+	pps.EntropyCodingModeFlag = r.ReadFlag()
+	pps.BottomFieldPicOrderInFramePresentFlag = r.ReadFlag()
+	pps.NumSliceGroups = r.ReadUE() + 1
+	if pps.NumSliceGroups > 1 {
+		pps.SliceGroupMapType = r.ReadUE()
+		switch pps.SliceGroupMapType {
+		case 0:
+			for i := 0; i < pps.NumSliceGroups; i++ {
+				r.ReadUE() // run_length_minus1
+			}
+		case 2:
+			for i := 0; i < pps.NumSliceGroups-1; i++ {
+				r.ReadUE() // top_left
+				r.ReadUE() // bottom_right
+			}
+		case 3, 4, 5:
+			r.SkipBit(1)
+			r.ReadUE() // slice_group_change_rate_minus1
+		case 6:
+			n := r.ReadUE() + 1
+			bits := 0
+			for 1<<uint(bits) < pps.NumSliceGroups {
+				bits++
+			}
+			r.SkipBit(n * bits)
+		}
+	}
+	pps.NumRefIdxL0DefaultActive = r.ReadUE() + 1
+	pps.NumRefIdxL1DefaultActive = r.ReadUE() + 1
+	pps.WeightedPredFlag = r.ReadFlag()
+	pps.WeightedBipredIdc = r.ReadBit(2)
+	pps.PicInitQp = r.ReadSE() + 26
+	pps.PicInitQs = r.ReadSE() + 26
+	pps.ChromaQpIndexOffset = r.ReadSE()
+	pps.DeblockingFilterControlPresentFlag = r.ReadFlag()
+	pps.ConstrainedIntraPredFlag = r.ReadFlag()
+	pps.RedundantPicCntPresentFlag = r.ReadFlag()
+	pps.SecondChromaQpIndexOffset = pps.ChromaQpIndexOffset
+	if r.MoreRbspData() {
+		pps.Transform8x8ModeFlag = r.ReadFlag()
+		pps.PicScalingMatrixPresentFlag = r.ReadFlag()
+		// The number of lists depends on the SPS chroma format, which is
+		// not known here; only 4:2:0 and 4:2:2 lists are handled
+		if pps.PicScalingMatrixPresentFlag {
+			n := 6 + 2*b2i(pps.Transform8x8ModeFlag)
+			for i := 0; i < n; i++ {
+				if r.ReadFlag() {
+					if i < 6 {
+						skipScalingList(r, 16)
+					} else {
+						skipScalingList(r, 64)
+					}
+				}
+			}
+		}
+		pps.SecondChromaQpIndexOffset = r.ReadSE()
+	}
+	return pps
+}
+
+// H264ParamSet is an SPS or PPS that is new or differs from the previous
+// one with the same id.
+type H264ParamSet struct {
+	Pos int64
+	Pts int64
+	Sps *H264Sps `json:",omitempty"`
+	Pps *H264Pps `json:",omitempty"`
+}
+
+func (s *H264Record) parseSps(p *PesPkt, nal NalUnit) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Println("SPS parsing error at", p.Pos, r)
+		}
+	}()
+	sps := ParseH264Sps(nal.Rbsp)
+	if s.Sps == nil {
+		s.Sps = make(map[int]*H264Sps)
+		s.rawSps = make(map[int]string)
+	}
+	if s.rawSps[sps.SeqParameterSetId] != string(nal.Data) {
+		s.rawSps[sps.SeqParameterSetId] = string(nal.Data)
+		s.ParamSets = append(s.ParamSets, H264ParamSet{Pos: p.Pos, Pts: p.Pts, Sps: sps})
+	}
+	s.Sps[sps.SeqParameterSetId] = sps
+}
+
+func (s *H264Record) parsePps(p *PesPkt, nal NalUnit) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Println("PPS parsing error at", p.Pos, r)
+		}
+	}()
+	pps := ParseH264Pps(nal.Rbsp)
+	if s.Pps == nil {
+		s.Pps = make(map[int]*H264Pps)
+		s.rawPps = make(map[int]string)
+	}
+	if s.rawPps[pps.PicParameterSetId] != string(nal.Data) {
+		s.rawPps[pps.PicParameterSetId] = string(nal.Data)
+		s.ParamSets = append(s.ParamSets, H264ParamSet{Pos: p.Pos, Pts: p.Pts, Pps: pps})
+	}
+	s.Pps[pps.PicParameterSetId] = pps
+}
+
+func (s *H264Record) reportParamSets(root string) {
+	if len(s.ParamSets) == 0 {
+		return
+	}
+	fname := filepath.Join(root, strconv.Itoa(s.Pid)+"-sps.json")
+	w, err := os.Create(fname)
+	if err != nil {
+		panic(err)
+	}
+	defer w.Close()
+
+	buf, _ := json.MarshalIndent(s.ParamSets, "", "  ")
+	fmt.Fprintln(w, string(buf))
+}
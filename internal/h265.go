@@ -88,16 +88,16 @@ func GetHevcNalUnitType(b int) string {
 
 func ParseHevcNalUnits(data []byte) []string {
 	var nals []string
-	var pos int
-	var startcode = []byte{0, 0, 1}
-	var startlen = len(startcode)
-	for pos+5 < len(data) {
-		if bytes.Compare(startcode, data[pos:pos+startlen]) == 0 {
-			pos += startlen
-			nal := GetHevcNalUnitType(int(data[pos]))
-			nals = append(nals, nal)
-		}
-		pos += 1
+	for _, nal := range SplitNalUnits(data) {
+		nals = append(nals, GetHevcNalUnitType(int(nal[0])))
+	}
+	return nals
+}
+
+func hevcNalUnitTypes(units []NalUnit) []string {
+	var nals []string
+	for _, nal := range units {
+		nals = append(nals, GetHevcNalUnitType(int(nal.Data[0])))
 	}
 	return nals
 }
@@ -107,6 +107,21 @@ type H265Record struct {
 	curpkt *PesPkt
 	Pkts   []*PesPkt
 	Nals   [][]string
+	// Active parameter sets by id, and every change seen
+	Vps       map[int]*H265Vps
+	Sps       map[int]*H265Sps
+	Pps       map[int]*H265Pps
+	ParamSets []H265ParamSet
+	Pictures  []*H265Picture
+	poc       H265Poc
+	splitter  NalSplitter
+	au        AuTimer
+	captions  CaptionExtractor
+	afd       AfdTimeline
+	lastSps   *H265Sps
+	rawVps    map[int]string
+	rawSps    map[int]string
+	rawPps    map[int]string
 	// Workaround PES parsing error
 	WorkaroundPESFlag bool
 	WorkaroundPES     []byte
@@ -115,19 +130,22 @@ type H265Record struct {
 const minHevcPesHeaderLen = 19
 
 func (s *H265Record) Process(pkt *TsPkt) {
+	ccOk, duplicate := s.CheckCC(pkt)
+	if duplicate {
+		return
+	}
 	s.LogAdaptFieldPrivData(pkt)
 	if pkt.PUSI == 1 {
 		if s.curpkt != nil {
-			nals := ParseHevcNalUnits(s.curpkt.Data)
-			for _, nal := range nals {
-				if nal == "idr_w_radl" || nal == "idr_n_lp" {
-					info := IFrameInfo{}
-					info.Pos = s.curpkt.Pos
-					info.Pts = s.curpkt.Pts
-					info.Key = true
-					s.LogIFrame(info)
-				}
+			s.curpkt.CCError = s.curpkt.CCError || !ccOk
+			s.CheckPes(s.curpkt)
+			units := s.splitter.Write(s.curpkt.Data)
+			if pesPayloadStartsNal(pkt.Data) {
+				units = append(units, s.splitter.Flush()...)
 			}
+			nals := hevcNalUnitTypes(units)
+			pics := s.parseNals(s.curpkt, units)
+			s.logKeyFrame(s.curpkt, nals, pics)
 			s.Nals = append(s.Nals, nals)
 			s.Pkts = append(s.Pkts, s.curpkt)
 		}
@@ -142,6 +160,7 @@ func (s *H265Record) Process(pkt *TsPkt) {
 				pkt.Data = pkt.Data[hlen:]
 			} else {
 				log.Println("PES start code error")
+				s.curpkt.StartCodeError = true
 			}
 		} else {
 			log.Println("Workaround for pkt:", pkt.Pos, "size:", len(pkt.Data))
@@ -150,6 +169,10 @@ func (s *H265Record) Process(pkt *TsPkt) {
 		}
 	}
 
+	if s.curpkt != nil && !ccOk && pkt.PUSI == 0 {
+		s.curpkt.CCError = true
+	}
+
 	if s.WorkaroundPESFlag {
 		s.WorkaroundPES = append(s.WorkaroundPES, pkt.Data...)
 		pkt.Data = nil
@@ -161,6 +184,7 @@ func (s *H265Record) Process(pkt *TsPkt) {
 				s.WorkaroundPESFlag = false
 			} else {
 				log.Println("PES start code error")
+				s.curpkt.StartCodeError = true
 			}
 		}
 	}
@@ -171,9 +195,53 @@ func (s *H265Record) Process(pkt *TsPkt) {
 	}
 }
 
+// parseNals decodes the NAL units of a PES packet the record keeps state
+// for, and returns the pictures they make up, none if their slices could
+// not be parsed. Malformed units are logged and skipped. Only the base
+// layer is decoded.
+func (s *H265Record) parseNals(p *PesPkt, units []NalUnit) []*H265Picture {
+	var pics []*H265Picture
+	for _, nal := range units {
+		kind, vcl := hevcAuKind(nal)
+		t := int(nal.Data[0]>>1) & 0x3F
+		s.au.Add(p, nal, kind, vcl, t >= 16 && t <= 20)
+		if len(nal.Data) < 3 || nal.Data[0]&0x01 != 0 || nal.Data[1]&0xF8 != 0 {
+			continue
+		}
+		switch {
+		case t <= 9 || t >= 16 && t <= 21:
+			s.parseSlice(p, nal, &pics)
+		case t == 32:
+			s.parseVps(p, nal)
+		case t == 33:
+			s.parseSps(p, nal)
+		case t == 34:
+			s.parsePps(p, nal)
+		case t == 39 || t == 40:
+			for _, info := range s.parseH265Sei(p, nal.Rbsp, t == 40) {
+				s.LogSei(info)
+				if u := info.a53(); u != nil {
+					if u.CcData != nil {
+						s.captions.Add(s.au.cur.Pts, u.CcData)
+					}
+					s.afd.Add(s.au.cur.Pos, s.au.cur.Pts, u)
+				}
+			}
+		case t == 36:
+			s.poc.endOfSequence = true
+		}
+	}
+	s.Pictures = append(s.Pictures, pics...)
+	return pics
+}
+
 func (s *H265Record) Flush() {
 	if s.curpkt != nil {
-		nals := ParseHevcNalUnits(s.curpkt.Data)
+		s.CheckPes(s.curpkt)
+		units := append(s.splitter.Write(s.curpkt.Data), s.splitter.Flush()...)
+		nals := hevcNalUnitTypes(units)
+		pics := s.parseNals(s.curpkt, units)
+		s.logKeyFrame(s.curpkt, nals, pics)
 		s.Nals = append(s.Nals, nals)
 		s.Pkts = append(s.Pkts, s.curpkt)
 	}
@@ -186,6 +254,13 @@ func (s *H265Record) Report(root string) {
 	var pid string = strconv.Itoa(s.Pid)
 	var header string
 
+	s.ReportPesErrors(root)
+	s.reportParamSets(root)
+	s.reportPictures(root)
+	s.au.Report(root, s.Pid)
+	s.captions.Report(root, s.Pid)
+	s.afd.Report(root, s.Pid)
+
 	fname = filepath.Join(root, pid+".csv")
 	w, err = os.Create(fname)
 	if err != nil {
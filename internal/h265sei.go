@@ -0,0 +1,160 @@
+package mpts
+
+import (
+	"encoding/hex"
+	"log"
+)
+
+// HEVC payload types, Annex D
+var H265SeiTypeString map[int]string = map[int]string{
+	0:   "buffering_period",
+	1:   "pic_timing",
+	2:   "pan_scan_rect",
+	3:   "filler_payload",
+	4:   "user_data_registered_itu_t_t35",
+	5:   "user_data_unregistered",
+	6:   "recovery_point",
+	9:   "scene_info",
+	15:  "picture_snapshot",
+	16:  "progressive_refinement_segment_start",
+	17:  "progressive_refinement_segment_end",
+	19:  "film_grain_characteristics",
+	22:  "post_filter_hint",
+	23:  "tone_mapping_info",
+	45:  "frame_packing_arrangement",
+	47:  "display_orientation",
+	56:  "green_metadata",
+	128: "structure_of_pictures_info",
+	129: "active_parameter_sets",
+	130: "decoding_unit_info",
+	131: "temporal_sub_layer_zero_idx",
+	132: "decoded_picture_hash",
+	133: "scalable_nesting",
+	134: "region_refresh_info",
+	135: "no_display",
+	136: "time_code",
+	137: "mastering_display_colour_volume",
+	138: "segmented_rect_frame_packing_arrangement",
+	139: "temporal_motion_constrained_tile_sets",
+	140: "chroma_resampling_filter_hint",
+	141: "knee_function_info",
+	142: "colour_remapping_info",
+	143: "deinterlaced_field_identification",
+	144: "content_light_level_info",
+	145: "dependent_rap_indication",
+	146: "coded_region_completion",
+	147: "alternative_transfer_characteristics",
+	148: "ambient_viewing_environment",
+}
+
+// ParseH265PicTiming parses a pic_timing message with the VUI of the active
+// SPS, up to the decoding unit information.
+func ParseH265PicTiming(payload []byte, sps *H265Sps) *PicTiming {
+	pt := &PicTiming{}
+	if sps == nil || sps.Vui == nil {
+		return pt
+	}
+	r := NewReader(payload)
+	if sps.Vui.FrameFieldInfoPresentFlag {
+		pt.PicStruct = r.ReadBit(4)
+		pt.SourceScanType = r.ReadBit(2)
+		pt.DuplicateFlag = r.ReadFlag()
+	}
+	hrd := sps.Vui.Hrd
+	if hrd != nil && (hrd.NalHrdParametersPresentFlag || hrd.VclHrdParametersPresentFlag) {
+		pt.CpbRemovalDelay = r.ReadBit64(hrd.AuCpbRemovalDelayLength) + 1
+		pt.DpbOutputDelay = r.ReadBit64(hrd.DpbOutputDelayLength)
+	}
+	return pt
+}
+
+// ParseTimeCode parses a time_code message, D.2.27.
+func ParseTimeCode(payload []byte) []ClockTimestamp {
+	var ts []ClockTimestamp
+	r := NewReader(payload)
+	n := r.ReadBit(2)
+	for i := 0; i < n; i++ {
+		if !r.ReadFlag() {
+			continue
+		}
+		t := ClockTimestamp{}
+		t.NuitFieldBasedFlag = r.ReadFlag()
+		t.CountingType = r.ReadBit(5)
+		full := r.ReadFlag()
+		t.DiscontinuityFlag = r.ReadFlag()
+		t.CntDroppedFlag = r.ReadFlag()
+		t.NFrames = r.ReadBit(9)
+		if full {
+			t.Seconds = r.ReadBit(6)
+			t.Minutes = r.ReadBit(6)
+			t.Hours = r.ReadBit(5)
+		} else if r.ReadFlag() {
+			t.Seconds = r.ReadBit(6)
+			if r.ReadFlag() {
+				t.Minutes = r.ReadBit(6)
+				if r.ReadFlag() {
+					t.Hours = r.ReadBit(5)
+				}
+			}
+		}
+		if length := r.ReadBit(5); length > 0 {
+			v := r.ReadBit(length)
+			if v >= 1<<uint(length-1) {
+				v -= 1 << uint(length)
+			}
+			t.TimeOffset = v
+		}
+		ts = append(ts, t)
+	}
+	return ts
+}
+
+// parseH265Sei decodes the messages of a prefix or suffix SEI NAL unit.
+func (s *H265Record) parseH265Sei(p *PesPkt, rbsp []byte, suffix bool) []SeiInfo {
+	var infos []SeiInfo
+	for _, msg := range ParseSeiMessages(rbsp, 2) {
+		info := SeiInfo{Pos: p.Pos, Pts: p.Pts, PayloadType: msg.PayloadType, Suffix: suffix}
+		info.Name = H265SeiTypeString[msg.PayloadType]
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					log.Println("SEI parsing error at", p.Pos, r)
+				}
+			}()
+			switch msg.PayloadType {
+			case 1:
+				info.PicTiming = ParseH265PicTiming(msg.Payload, s.activeSps())
+			case 4:
+				info.Registered = ParseItuT35(msg.Payload)
+			case 5:
+				info.Unregistered = ParseUserDataUnregistered(msg.Payload)
+			case 136:
+				info.TimeCode = ParseTimeCode(msg.Payload)
+			case 137:
+				info.MasteringDisplay = ParseMasteringDisplayColourVolume(msg.Payload)
+			case 144:
+				info.ContentLightLevel = ParseContentLightLevel(msg.Payload)
+			case 147:
+				info.AlternativeTransfer = ParseAlternativeTransfer(msg.Payload)
+			default:
+				info.Payload = hex.EncodeToString(msg.Payload)
+			}
+		}()
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// activeSps returns the SPS of the last parsed slice, or the SPS with the
+// lowest id when no slice has been parsed yet.
+func (s *H265Record) activeSps() *H265Sps {
+	if s.lastSps != nil {
+		return s.lastSps
+	}
+	for id := 0; id < 16; id++ {
+		if sps, ok := s.Sps[id]; ok {
+			return sps
+		}
+	}
+	return nil
+}
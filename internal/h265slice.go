@@ -0,0 +1,278 @@
+package mpts
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// slice_type, Table 7-7
+var H265SliceTypeString []string = []string{"B", "P", "I"}
+
+type H265SliceHeader struct {
+	NalUnitType                int
+	NuhLayerId                 int
+	TemporalId                 int
+	FirstSliceSegmentInPicFlag bool
+	NoOutputOfPriorPicsFlag    bool
+	SlicePicParameterSetId     int
+	DependentSliceSegmentFlag  bool
+	SliceSegmentAddress        int
+	SliceType                  int
+	PicOutputFlag              bool
+	ColourPlaneId              int
+	SlicePicOrderCntLsb        int
+}
+
+func (h *H265SliceHeader) IsIntra() bool {
+	return h.SliceType == 2
+}
+
+// IsH265Irap tells the IRAP NAL unit types: BLA, IDR and CRA.
+func IsH265Irap(nalUnitType int) bool {
+	return nalUnitType >= 16 && nalUnitType <= 23
+}
+
+// ParseH265SliceHeader parses the start of a slice_segment_header up to the
+// picture order count, using the parameter sets it refers to. It returns
+// nil if they have not been received. Dependent slice segments carry no
+// slice type; only the fields before it are set.
+func ParseH265SliceHeader(rbsp []byte, spsList map[int]*H265Sps, ppsList map[int]*H265Pps) (*H265SliceHeader, *H265Sps, *H265Pps) {
+	h := &H265SliceHeader{}
+	r := NewReader(rbsp)
+	r.SkipBit(1)
+	h.NalUnitType = r.ReadBit(6)
+	h.NuhLayerId = r.ReadBit(6)
+	h.TemporalId = r.ReadBit(3) - 1
+	h.FirstSliceSegmentInPicFlag = r.ReadFlag()
+	if IsH265Irap(h.NalUnitType) {
+		h.NoOutputOfPriorPicsFlag = r.ReadFlag()
+	}
+	h.SlicePicParameterSetId = r.ReadUE()
+	pps, ok := ppsList[h.SlicePicParameterSetId]
+	if !ok {
+		return nil, nil, nil
+	}
+	sps, ok := spsList[pps.PpsSeqParameterSetId]
+	if !ok {
+		return nil, nil, nil
+	}
+	h.PicOutputFlag = true
+	if !h.FirstSliceSegmentInPicFlag {
+		if pps.DependentSliceSegmentsEnabledFlag {
+			h.DependentSliceSegmentFlag = r.ReadFlag()
+		}
+		ctbLog2 := uint(sps.Log2MinLumaCodingBlockSize + sps.Log2DiffMaxMinLumaCodingBlockSize)
+		ctbSize := 1 << ctbLog2
+		ctbs := ((sps.PicWidthInLumaSamples + ctbSize - 1) >> ctbLog2) * ((sps.PicHeightInLumaSamples + ctbSize - 1) >> ctbLog2)
+		bits := 0
+		for 1<<uint(bits) < ctbs {
+			bits++
+		}
+		h.SliceSegmentAddress = r.ReadBit(bits)
+	}
+	if h.DependentSliceSegmentFlag {
+		return h, sps, pps
+	}
+	r.SkipBit(pps.NumExtraSliceHeaderBits)
+	h.SliceType = r.ReadUE()
+	if pps.OutputFlagPresentFlag {
+		h.PicOutputFlag = r.ReadFlag()
+	}
+	if sps.SeparateColourPlaneFlag {
+		h.ColourPlaneId = r.ReadBit(2)
+	}
+	if h.NalUnitType != 19 && h.NalUnitType != 20 {
+		h.SlicePicOrderCntLsb = r.ReadBit(sps.Log2MaxPicOrderCntLsb)
+	}
+	return h, sps, pps
+}
+
+// H265Poc keeps the state of the picture order count decoding, 8.3.1.
+type H265Poc struct {
+	started       bool
+	endOfSequence bool
+	prevTid0Lsb   int
+	prevTid0Msb   int
+}
+
+// Next returns the picture order count of the picture starting with h.
+func (p *H265Poc) Next(h *H265SliceHeader, sps *H265Sps) int {
+	t := h.NalUnitType
+	// CRA pictures only reset the POC at the start of the bitstream or
+	// after an end of sequence
+	noRaslOutput := IsH265Irap(t) && (t < 21 || !p.started || p.endOfSequence)
+	p.started = true
+	p.endOfSequence = false
+
+	maxLsb := 1 << uint(sps.Log2MaxPicOrderCntLsb)
+	lsb := h.SlicePicOrderCntLsb
+	msb := 0
+	if !noRaslOutput {
+		msb = p.prevTid0Msb
+		if lsb < p.prevTid0Lsb && p.prevTid0Lsb-lsb >= maxLsb/2 {
+			msb += maxLsb
+		} else if lsb > p.prevTid0Lsb && lsb-p.prevTid0Lsb > maxLsb/2 {
+			msb -= maxLsb
+		}
+	}
+	// RADL, RASL and sub-layer non-reference pictures are not used as
+	// prevTid0Pic
+	subLayerNonRef := t <= 14 && t%2 == 0
+	if h.TemporalId == 0 && !(t >= 6 && t <= 9) && !subLayerNonRef {
+		p.prevTid0Lsb = lsb
+		p.prevTid0Msb = msb
+	}
+	return msb + lsb
+}
+
+// H265Picture describes a coded picture. Pts and Dts are those of the PES
+// packet it starts in, and -1 for the pictures following the first one of a
+// PES packet.
+type H265Picture struct {
+	Pos         int64
+	Pts         int64
+	Dts         int64
+	Type        string
+	NalUnitType int
+	Irap        bool
+	Poc         int
+	TemporalId  int
+	Slices      int
+}
+
+// addSlice merges the slice into the picture type: I when all slices are
+// intra, B when any slice is bi-predicted, P otherwise. Dependent slice
+// segments belong to the previous slice and are not counted.
+func (pic *H265Picture) addSlice(h *H265SliceHeader) {
+	if h.DependentSliceSegmentFlag || h.SliceType > 2 {
+		return
+	}
+	t := H265SliceTypeString[h.SliceType]
+	switch {
+	case pic.Slices == 0:
+		pic.Type = t
+	case t == "B" || pic.Type == "B":
+		pic.Type = "B"
+	case !h.IsIntra() || pic.Type != "I":
+		pic.Type = "P"
+	}
+	pic.Slices += 1
+}
+
+func (pic *H265Picture) IsIntra() bool {
+	return pic.Type == "I"
+}
+
+// IrapType names the kind of IRAP picture of a NAL unit type, or returns an
+// empty string.
+func IrapType(nalUnitType int) string {
+	switch {
+	case nalUnitType >= 16 && nalUnitType <= 18:
+		return "BLA"
+	case nalUnitType == 19 || nalUnitType == 20:
+		return "IDR"
+	case nalUnitType == 21:
+		return "CRA"
+	}
+	return ""
+}
+
+// parseSlice adds the slice segment to the last of pics, or appends the
+// picture it starts.
+func (s *H265Record) parseSlice(p *PesPkt, nal NalUnit, pics *[]*H265Picture) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Println("Slice header parsing error at", p.Pos, r)
+		}
+	}()
+	h, sps, _ := ParseH265SliceHeader(nal.Rbsp, s.Sps, s.Pps)
+	if h == nil {
+		return
+	}
+	s.lastSps = sps
+	n := len(*pics)
+	if h.FirstSliceSegmentInPicFlag {
+		pic := &H265Picture{
+			Pos:         p.Pos,
+			Pts:         p.Pts,
+			Dts:         p.Dts,
+			NalUnitType: h.NalUnitType,
+			Irap:        IsH265Irap(h.NalUnitType),
+			Poc:         s.poc.Next(h, sps),
+			TemporalId:  h.TemporalId,
+		}
+		if n > 0 {
+			pic.Pts, pic.Dts = -1, -1
+		}
+		*pics = append(*pics, pic)
+	} else if n == 0 {
+		// The first slice segment was lost
+		return
+	}
+	(*pics)[len(*pics)-1].addSlice(h)
+}
+
+// logKeyFrame logs IRAP pictures and the other intra pictures. IDR and BLA
+// pictures are key frames; CRA pictures may be followed by skipped leading
+// pictures, as in an open GOP. Without parameter sets, IRAP pictures are
+// told from their NAL unit type.
+func (s *H265Record) logKeyFrame(p *PesPkt, nals []string, pics []*H265Picture) {
+	if len(pics) == 0 {
+		info := IFrameInfo{Pos: p.Pos, Pts: p.Pts}
+		for _, nal := range nals {
+			for t := 16; t <= 21; t++ {
+				if nal == HevcNalUnitType[t] {
+					info.Type = IrapType(t)
+					info.Key = info.Type != "CRA"
+					s.LogIFrame(info)
+					return
+				}
+			}
+		}
+		return
+	}
+	for _, pic := range pics {
+		info := IFrameInfo{Pos: pic.Pos, Pts: pic.Pts}
+		switch {
+		case pic.Irap:
+			info.Type = IrapType(pic.NalUnitType)
+			info.Key = info.Type != "CRA"
+		case pic.IsIntra():
+			info.Type = "I"
+		default:
+			continue
+		}
+		s.LogIFrame(info)
+	}
+}
+
+func (s *H265Record) reportPictures(root string) {
+	if len(s.Pictures) == 0 {
+		return
+	}
+	fname := filepath.Join(root, strconv.Itoa(s.Pid)+"-picture.csv")
+	w, err := os.Create(fname)
+	if err != nil {
+		panic(err)
+	}
+	defer w.Close()
+	fmt.Fprintln(w, "Pos, PTS, DTS, Type, NalUnitType, IRAP, POC, TemporalId, Slices")
+	for _, pic := range s.Pictures {
+		cols := []string{
+			strconv.FormatInt(pic.Pos, 10),
+			strconv.FormatInt(pic.Pts, 10),
+			strconv.FormatInt(pic.Dts, 10),
+			pic.Type,
+			HevcNalUnitType[pic.NalUnitType],
+			strconv.FormatBool(pic.Irap),
+			strconv.Itoa(pic.Poc),
+			strconv.Itoa(pic.TemporalId),
+			strconv.Itoa(pic.Slices),
+		}
+		fmt.Fprintln(w, strings.Join(cols, ", "))
+	}
+}
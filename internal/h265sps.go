@@ -0,0 +1,819 @@
+package mpts
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// HEVC general_profile_idc values, Annex A
+var H265ProfileString map[int]string = map[int]string{
+	1:  "Main",
+	2:  "Main 10",
+	3:  "Main Still Picture",
+	4:  "Format Range Extensions",
+	5:  "High Throughput",
+	6:  "Multiview Main",
+	7:  "Scalable Main",
+	8:  "3D Main",
+	9:  "Screen Content Coding",
+	10: "Scalable Format Range Extensions",
+	11: "High Throughput Screen Content Coding",
+}
+
+// colour_primaries, Table E-3
+var ColourPrimariesString map[int]string = map[int]string{
+	1:  "BT.709",
+	4:  "BT.470M",
+	5:  "BT.470BG",
+	6:  "SMPTE 170M",
+	7:  "SMPTE 240M",
+	8:  "Generic film",
+	9:  "BT.2020",
+	10: "SMPTE ST 428-1",
+	11: "SMPTE RP 431-2",
+	12: "SMPTE EG 432-1",
+	22: "EBU Tech 3213",
+}
+
+// transfer_characteristics, Table E-4
+var TransferCharacteristicsString map[int]string = map[int]string{
+	1:  "BT.709",
+	4:  "BT.470M",
+	5:  "BT.470BG",
+	6:  "SMPTE 170M",
+	7:  "SMPTE 240M",
+	8:  "Linear",
+	11: "IEC 61966-2-4",
+	12: "BT.1361",
+	13: "IEC 61966-2-1",
+	14: "BT.2020 10-bit",
+	15: "BT.2020 12-bit",
+	16: "SMPTE ST 2084",
+	17: "SMPTE ST 428-1",
+	18: "ARIB STD-B67",
+}
+
+// matrix_coeffs, Table E-5
+var MatrixCoefficientsString map[int]string = map[int]string{
+	0:  "Identity",
+	1:  "BT.709",
+	4:  "FCC",
+	5:  "BT.470BG",
+	6:  "SMPTE 170M",
+	7:  "SMPTE 240M",
+	8:  "YCgCo",
+	9:  "BT.2020 NCL",
+	10: "BT.2020 CL",
+	14: "ICtCp",
+}
+
+// DynamicRange names the transfer function family: PQ for HDR10, HLG, or
+// SDR for everything else.
+func DynamicRange(transferCharacteristics int) string {
+	switch transferCharacteristics {
+	case 16:
+		return "PQ"
+	case 18:
+		return "HLG"
+	}
+	return "SDR"
+}
+
+// H265ProfileTierLevel holds the general part of profile_tier_level();
+// sub-layer information is skipped.
+type H265ProfileTierLevel struct {
+	GeneralProfileSpace              int
+	GeneralTierFlag                  bool
+	GeneralProfileIdc                int
+	GeneralProfileCompatibilityFlags uint32
+	GeneralProgressiveSourceFlag     bool
+	GeneralInterlacedSourceFlag      bool
+	GeneralNonPackedConstraintFlag   bool
+	GeneralFrameOnlyConstraintFlag   bool
+	GeneralLevelIdc                  int
+	// Derived from the fields above
+	Profile string
+	Tier    string
+	Level   string
+}
+
+type H265Vps struct {
+	VpsVideoParameterSetId     int
+	VpsMaxLayers               int
+	VpsMaxSubLayers            int
+	VpsTemporalIdNestingFlag   bool
+	ProfileTierLevel           H265ProfileTierLevel
+	VpsTimingInfoPresentFlag   bool
+	VpsNumUnitsInTick          int64
+	VpsTimeScale               int64
+	VpsPocProportionalToTiming bool
+}
+
+type H265Sps struct {
+	SpsVideoParameterSetId            int
+	SpsMaxSubLayers                   int
+	SpsTemporalIdNestingFlag          bool
+	ProfileTierLevel                  H265ProfileTierLevel
+	SpsSeqParameterSetId              int
+	ChromaFormatIdc                   int
+	ChromaFormat                      string
+	SeparateColourPlaneFlag           bool
+	PicWidthInLumaSamples             int
+	PicHeightInLumaSamples            int
+	ConformanceWindowFlag             bool
+	ConfWinLeftOffset                 int
+	ConfWinRightOffset                int
+	ConfWinTopOffset                  int
+	ConfWinBottomOffset               int
+	BitDepthLuma                      int
+	BitDepthChroma                    int
+	Log2MaxPicOrderCntLsb             int
+	SpsMaxDecPicBuffering             []int
+	SpsMaxNumReorderPics              []int
+	SpsMaxLatencyIncrease             []int
+	Log2MinLumaCodingBlockSize        int
+	Log2DiffMaxMinLumaCodingBlockSize int
+	Log2MinLumaTransformBlockSize     int
+	Log2DiffMaxMinLumaTransformSize   int
+	MaxTransformHierarchyDepthInter   int
+	MaxTransformHierarchyDepthIntra   int
+	ScalingListEnabledFlag            bool
+	AmpEnabledFlag                    bool
+	SampleAdaptiveOffsetEnabledFlag   bool
+	PcmEnabledFlag                    bool
+	NumShortTermRefPicSets            int
+	LongTermRefPicsPresentFlag        bool
+	NumLongTermRefPicsSps             int
+	SpsTemporalMvpEnabledFlag         bool
+	StrongIntraSmoothingEnabledFlag   bool
+	VuiParametersPresentFlag          bool
+	Vui                               *H265Vui `json:",omitempty"`
+	// Derived from the fields above
+	Width        int
+	Height       int
+	FrameRate    float64
+	DynamicRange string
+	// Short-term reference picture sets, needed by later sets
+	stRps []h265StRps
+}
+
+type H265Vui struct {
+	AspectRatioInfoPresentFlag     bool
+	AspectRatioIdc                 int
+	SarWidth                       int
+	SarHeight                      int
+	OverscanInfoPresentFlag        bool
+	OverscanAppropriateFlag        bool
+	VideoSignalTypePresentFlag     bool
+	VideoFormat                    int
+	VideoFullRangeFlag             bool
+	ColourDescriptionPresentFlag   bool
+	ColourPrimaries                int
+	TransferCharacteristics        int
+	MatrixCoeffs                   int
+	ChromaLocInfoPresentFlag       bool
+	ChromaSampleLocTypeTopField    int
+	ChromaSampleLocTypeBottomField int
+	NeutralChromaIndicationFlag    bool
+	FieldSeqFlag                   bool
+	FrameFieldInfoPresentFlag      bool
+	DefaultDisplayWindowFlag       bool
+	DefDispWinLeftOffset           int
+	DefDispWinRightOffset          int
+	DefDispWinTopOffset            int
+	DefDispWinBottomOffset         int
+	VuiTimingInfoPresentFlag       bool
+	VuiNumUnitsInTick              int64
+	VuiTimeScale                   int64
+	VuiPocProportionalToTimingFlag bool
+	VuiNumTicksPocDiffOne          int
+	Hrd                            *H265Hrd `json:",omitempty"`
+	BitstreamRestrictionFlag       bool
+	TilesFixedStructureFlag        bool
+	MotionVectorsOverPicBoundaries bool
+	RestrictedRefPicListsFlag      bool
+	MinSpatialSegmentationIdc      int
+	MaxBytesPerPicDenom            int
+	MaxBitsPerMinCuDenom           int
+	Log2MaxMvLengthHorizontal      int
+	Log2MaxMvLengthVertical        int
+	ColourPrimariesName            string `json:",omitempty"`
+	TransferCharacteristicsName    string `json:",omitempty"`
+	MatrixCoeffsName               string `json:",omitempty"`
+}
+
+// H265Hrd holds hrd_parameters(); the CPB specifications are those of the
+// highest sub-layer, NAL HRD first. Bit rates and CPB sizes are in bits.
+type H265Hrd struct {
+	NalHrdParametersPresentFlag      bool
+	VclHrdParametersPresentFlag      bool
+	SubPicHrdParamsPresentFlag       bool
+	TickDivisor                      int
+	DuCpbRemovalDelayIncrementLength int
+	SubPicCpbParamsInPicTimingSei    bool
+	DpbOutputDelayDuLength           int
+	BitRateScale                     int
+	CpbSizeScale                     int
+	InitialCpbRemovalDelayLength     int
+	AuCpbRemovalDelayLength          int
+	DpbOutputDelayLength             int
+	FixedPicRateFlag                 bool
+	ElementalDurationInTc            int
+	LowDelayHrdFlag                  bool
+	CpbCnt                           int
+	BitRate                          []int64
+	CpbSize                          []int64
+	CbrFlag                          []bool
+}
+
+type H265Pps struct {
+	PpsPicParameterSetId              int
+	PpsSeqParameterSetId              int
+	DependentSliceSegmentsEnabledFlag bool
+	OutputFlagPresentFlag             bool
+	NumExtraSliceHeaderBits           int
+	SignDataHidingEnabledFlag         bool
+	CabacInitPresentFlag              bool
+	NumRefIdxL0DefaultActive          int
+	NumRefIdxL1DefaultActive          int
+	InitQp                            int
+	ConstrainedIntraPredFlag          bool
+	TransformSkipEnabledFlag          bool
+	CuQpDeltaEnabledFlag              bool
+	DiffCuQpDeltaDepth                int
+	PpsCbQpOffset                     int
+	PpsCrQpOffset                     int
+	PpsSliceChromaQpOffsetsPresent    bool
+	WeightedPredFlag                  bool
+	WeightedBipredFlag                bool
+	TransquantBypassEnabledFlag       bool
+	TilesEnabledFlag                  bool
+	EntropyCodingSyncEnabledFlag      bool
+	NumTileColumns                    int
+	NumTileRows                       int
+	UniformSpacingFlag                bool
+	LoopFilterAcrossTilesEnabledFlag  bool
+	LoopFilterAcrossSlicesEnabledFlag bool
+	DeblockingFilterControlPresent    bool
+	DeblockingFilterOverrideEnabled   bool
+	PpsDeblockingFilterDisabledFlag   bool
+	PpsBetaOffset                     int
+	PpsTcOffset                       int
+	PpsScalingListDataPresentFlag     bool
+	ListsModificationPresentFlag      bool
+	Log2ParallelMergeLevel            int
+	SliceSegmentHeaderExtensionFlag   bool
+}
+
+// h265StRps is a short-term reference picture set as delta POCs, 7.4.8.
+type h265StRps struct {
+	s0 []int
+	s1 []int
+}
+
+func parseH265ProfileTierLevel(r *Reader, maxSubLayers int) H265ProfileTierLevel {
+	p := H265ProfileTierLevel{}
+	p.GeneralProfileSpace = r.ReadBit(2)
+	p.GeneralTierFlag = r.ReadFlag()
+	p.GeneralProfileIdc = r.ReadBit(5)
+	p.GeneralProfileCompatibilityFlags = uint32(r.ReadBit64(32))
+	p.GeneralProgressiveSourceFlag = r.ReadFlag()
+	p.GeneralInterlacedSourceFlag = r.ReadFlag()
+	p.GeneralNonPackedConstraintFlag = r.ReadFlag()
+	p.GeneralFrameOnlyConstraintFlag = r.ReadFlag()
+	r.SkipBit(44)
+	p.GeneralLevelIdc = r.ReadBit(8)
+
+	profilePresent := make([]bool, maxSubLayers)
+	levelPresent := make([]bool, maxSubLayers)
+	for i := 0; i < maxSubLayers-1; i++ {
+		profilePresent[i] = r.ReadFlag()
+		levelPresent[i] = r.ReadFlag()
+	}
+	if maxSubLayers > 1 {
+		r.SkipBit(2 * (9 - maxSubLayers)) // reserved_zero_2bits
+	}
+	for i := 0; i < maxSubLayers-1; i++ {
+		if profilePresent[i] {
+			r.SkipBit(88)
+		}
+		if levelPresent[i] {
+			r.SkipBit(8)
+		}
+	}
+
+	p.Profile = H265ProfileString[p.GeneralProfileIdc]
+	if p.Profile == "" {
+		// Profiles are also signalled by the compatibility flags alone
+		for i := 1; i < 32; i++ {
+			if p.GeneralProfileCompatibilityFlags&(1<<uint(31-i)) != 0 {
+				p.Profile = H265ProfileString[i]
+				break
+			}
+		}
+	}
+	p.Tier = "Main"
+	if p.GeneralTierFlag {
+		p.Tier = "High"
+	}
+	p.Level = strconv.FormatFloat(float64(p.GeneralLevelIdc)/30, 'g', 2, 64)
+	return p
+}
+
+// skipH265ScalingListData reads past a scaling_list_data().
+func skipH265ScalingListData(r *Reader) {
+	for sizeId := 0; sizeId < 4; sizeId++ {
+		step := 1
+		if sizeId == 3 {
+			step = 3
+		}
+		for matrixId := 0; matrixId < 6; matrixId += step {
+			if !r.ReadFlag() {
+				r.ReadUE() // scaling_list_pred_matrix_id_delta
+				continue
+			}
+			coefNum := 1 << uint(4+sizeId<<1)
+			if coefNum > 64 {
+				coefNum = 64
+			}
+			if sizeId > 1 {
+				r.ReadSE() // scaling_list_dc_coef_minus8
+			}
+			for i := 0; i < coefNum; i++ {
+				r.ReadSE()
+			}
+		}
+	}
+}
+
+// parseH265StRps reads st_ref_pic_set(idx) of an SPS, deriving its delta
+// POCs from the sets already read when predicted, 7.4.8.
+func parseH265StRps(r *Reader, idx int, sets []h265StRps) h265StRps {
+	rps := h265StRps{}
+	if idx != 0 && r.ReadFlag() {
+		sign := r.ReadBit(1)
+		deltaRps := (1 - 2*sign) * (r.ReadUE() + 1)
+		ref := sets[idx-1]
+		n := len(ref.s0) + len(ref.s1)
+		useDelta := make([]bool, n+1)
+		for j := 0; j <= n; j++ {
+			used := r.ReadFlag()
+			useDelta[j] = used || r.ReadFlag()
+		}
+		for j := len(ref.s1) - 1; j >= 0; j-- {
+			if d := ref.s1[j] + deltaRps; d < 0 && useDelta[len(ref.s0)+j] {
+				rps.s0 = append(rps.s0, d)
+			}
+		}
+		if deltaRps < 0 && useDelta[n] {
+			rps.s0 = append(rps.s0, deltaRps)
+		}
+		for j := 0; j < len(ref.s0); j++ {
+			if d := ref.s0[j] + deltaRps; d < 0 && useDelta[j] {
+				rps.s0 = append(rps.s0, d)
+			}
+		}
+		for j := len(ref.s0) - 1; j >= 0; j-- {
+			if d := ref.s0[j] + deltaRps; d > 0 && useDelta[j] {
+				rps.s1 = append(rps.s1, d)
+			}
+		}
+		if deltaRps > 0 && useDelta[n] {
+			rps.s1 = append(rps.s1, deltaRps)
+		}
+		for j := 0; j < len(ref.s1); j++ {
+			if d := ref.s1[j] + deltaRps; d > 0 && useDelta[len(ref.s0)+j] {
+				rps.s1 = append(rps.s1, d)
+			}
+		}
+		return rps
+	}
+	numNegative := r.ReadUE()
+	numPositive := r.ReadUE()
+	poc := 0
+	for i := 0; i < numNegative; i++ {
+		poc -= r.ReadUE() + 1
+		r.SkipBit(1) // used_by_curr_pic_s0_flag
+		rps.s0 = append(rps.s0, poc)
+	}
+	poc = 0
+	for i := 0; i < numPositive; i++ {
+		poc += r.ReadUE() + 1
+		r.SkipBit(1) // used_by_curr_pic_s1_flag
+		rps.s1 = append(rps.s1, poc)
+	}
+	return rps
+}
+
+// ParseH265Vps parses the RBSP of a video_parameter_set, NAL header included,
+// up to the timing information.
+func ParseH265Vps(rbsp []byte) *H265Vps {
+	vps := &H265Vps{}
+	r := NewReader(rbsp)
+	r.SkipByte(2)
+	vps.VpsVideoParameterSetId = r.ReadBit(4)
+	r.SkipBit(2) // vps_base_layer_internal_flag, vps_base_layer_available_flag
+	vps.VpsMaxLayers = r.ReadBit(6) + 1
+	vps.VpsMaxSubLayers = r.ReadBit(3) + 1
+	vps.VpsTemporalIdNestingFlag = r.ReadFlag()
+	r.SkipBit(16) // vps_reserved_0xffff_16bits
+	vps.ProfileTierLevel = parseH265ProfileTierLevel(r, vps.VpsMaxSubLayers)
+	orderingInfoPresent := r.ReadFlag()
+	start := vps.VpsMaxSubLayers - 1
+	if orderingInfoPresent {
+		start = 0
+	}
+	for i := start; i < vps.VpsMaxSubLayers; i++ {
+		r.ReadUE() // vps_max_dec_pic_buffering_minus1
+		r.ReadUE() // vps_max_num_reorder_pics
+		r.ReadUE() // vps_max_latency_increase_plus1
+	}
+	maxLayerId := r.ReadBit(6)
+	numLayerSets := r.ReadUE() + 1
+	r.SkipBit((numLayerSets - 1) * (maxLayerId + 1))
+	vps.VpsTimingInfoPresentFlag = r.ReadFlag()
+	if vps.VpsTimingInfoPresentFlag {
+		vps.VpsNumUnitsInTick = r.ReadBit64(32)
+		vps.VpsTimeScale = r.ReadBit64(32)
+		vps.VpsPocProportionalToTiming = r.ReadFlag()
+	}
+	return vps
+}
+
+// ParseH265Sps parses the RBSP of a seq_parameter_set, NAL header included.
+func ParseH265Sps(rbsp []byte) *H265Sps {
+	sps := &H265Sps{}
+	r := NewReader(rbsp)
+	r.SkipByte(2)
+	sps.SpsVideoParameterSetId = r.ReadBit(4)
+	sps.SpsMaxSubLayers = r.ReadBit(3) + 1
+	sps.SpsTemporalIdNestingFlag = r.ReadFlag()
+	sps.ProfileTierLevel = parseH265ProfileTierLevel(r, sps.SpsMaxSubLayers)
+	sps.SpsSeqParameterSetId = r.ReadUE()
+	sps.ChromaFormatIdc = r.ReadUE()
+	if sps.ChromaFormatIdc == 3 {
+		sps.SeparateColourPlaneFlag = r.ReadFlag()
+	}
+	sps.ChromaFormat = ChromaFormatString[sps.ChromaFormatIdc]
+	sps.PicWidthInLumaSamples = r.ReadUE()
+	sps.PicHeightInLumaSamples = r.ReadUE()
+	sps.ConformanceWindowFlag = r.ReadFlag()
+	if sps.ConformanceWindowFlag {
+		sps.ConfWinLeftOffset = r.ReadUE()
+		sps.ConfWinRightOffset = r.ReadUE()
+		sps.ConfWinTopOffset = r.ReadUE()
+		sps.ConfWinBottomOffset = r.ReadUE()
+	}
+	sps.BitDepthLuma = r.ReadUE() + 8
+	sps.BitDepthChroma = r.ReadUE() + 8
+	sps.Log2MaxPicOrderCntLsb = r.ReadUE() + 4
+	orderingInfoPresent := r.ReadFlag()
+	start := sps.SpsMaxSubLayers - 1
+	if orderingInfoPresent {
+		start = 0
+	}
+	for i := start; i < sps.SpsMaxSubLayers; i++ {
+		sps.SpsMaxDecPicBuffering = append(sps.SpsMaxDecPicBuffering, r.ReadUE()+1)
+		sps.SpsMaxNumReorderPics = append(sps.SpsMaxNumReorderPics, r.ReadUE())
+		sps.SpsMaxLatencyIncrease = append(sps.SpsMaxLatencyIncrease, r.ReadUE())
+	}
+	sps.Log2MinLumaCodingBlockSize = r.ReadUE() + 3
+	sps.Log2DiffMaxMinLumaCodingBlockSize = r.ReadUE()
+	sps.Log2MinLumaTransformBlockSize = r.ReadUE() + 2
+	sps.Log2DiffMaxMinLumaTransformSize = r.ReadUE()
+	sps.MaxTransformHierarchyDepthInter = r.ReadUE()
+	sps.MaxTransformHierarchyDepthIntra = r.ReadUE()
+	sps.ScalingListEnabledFlag = r.ReadFlag()
+	if sps.ScalingListEnabledFlag && r.ReadFlag() {
+		skipH265ScalingListData(r)
+	}
+	sps.AmpEnabledFlag = r.ReadFlag()
+	sps.SampleAdaptiveOffsetEnabledFlag = r.ReadFlag()
+	sps.PcmEnabledFlag = r.ReadFlag()
+	if sps.PcmEnabledFlag {
+		r.SkipBit(8) // pcm_sample_bit_depth_luma_minus1, pcm_sample_bit_depth_chroma_minus1
+		r.ReadUE()   // log2_min_pcm_luma_coding_block_size_minus3
+		r.ReadUE()   // log2_diff_max_min_pcm_luma_coding_block_size
+		r.SkipBit(1) // pcm_loop_filter_disabled_flag
+	}
+	sps.NumShortTermRefPicSets = r.ReadUE()
+	for i := 0; i < sps.NumShortTermRefPicSets; i++ {
+		sps.stRps = append(sps.stRps, parseH265StRps(r, i, sps.stRps))
+	}
+	sps.LongTermRefPicsPresentFlag = r.ReadFlag()
+	if sps.LongTermRefPicsPresentFlag {
+		sps.NumLongTermRefPicsSps = r.ReadUE()
+		r.SkipBit(sps.NumLongTermRefPicsSps * (sps.Log2MaxPicOrderCntLsb + 1))
+	}
+	sps.SpsTemporalMvpEnabledFlag = r.ReadFlag()
+	sps.StrongIntraSmoothingEnabledFlag = r.ReadFlag()
+	sps.VuiParametersPresentFlag = r.ReadFlag()
+	if sps.VuiParametersPresentFlag {
+		sps.Vui = parseH265Vui(r, sps.SpsMaxSubLayers)
+	}
+
+	// Frame size after the conformance window, 7.4.3.2.1
+	subWidth, subHeight := 1, 1
+	if !sps.SeparateColourPlaneFlag {
+		switch sps.ChromaFormatIdc {
+		case 1:
+			subWidth, subHeight = 2, 2
+		case 2:
+			subWidth = 2
+		}
+	}
+	sps.Width = sps.PicWidthInLumaSamples - subWidth*(sps.ConfWinLeftOffset+sps.ConfWinRightOffset)
+	sps.Height = sps.PicHeightInLumaSamples - subHeight*(sps.ConfWinTopOffset+sps.ConfWinBottomOffset)
+	sps.DynamicRange = "SDR"
+	if vui := sps.Vui; vui != nil {
+		if vui.VuiTimingInfoPresentFlag && vui.VuiNumUnitsInTick > 0 {
+			sps.FrameRate = float64(vui.VuiTimeScale) / float64(vui.VuiNumUnitsInTick)
+		}
+		if vui.ColourDescriptionPresentFlag {
+			sps.DynamicRange = DynamicRange(vui.TransferCharacteristics)
+		}
+	}
+	return sps
+}
+
+func parseH265Vui(r *Reader, maxSubLayers int) *H265Vui {
+	vui := &H265Vui{}
+	vui.AspectRatioInfoPresentFlag = r.ReadFlag()
+	if vui.AspectRatioInfoPresentFlag {
+		vui.AspectRatioIdc = r.ReadBit(8)
+		if vui.AspectRatioIdc == extendedSar {
+			vui.SarWidth = r.ReadBit(16)
+			vui.SarHeight = r.ReadBit(16)
+		} else if vui.AspectRatioIdc < len(sampleAspectRatio) {
+			vui.SarWidth = sampleAspectRatio[vui.AspectRatioIdc][0]
+			vui.SarHeight = sampleAspectRatio[vui.AspectRatioIdc][1]
+		}
+	}
+	vui.OverscanInfoPresentFlag = r.ReadFlag()
+	if vui.OverscanInfoPresentFlag {
+		vui.OverscanAppropriateFlag = r.ReadFlag()
+	}
+	vui.VideoSignalTypePresentFlag = r.ReadFlag()
+	if vui.VideoSignalTypePresentFlag {
+		vui.VideoFormat = r.ReadBit(3)
+		vui.VideoFullRangeFlag = r.ReadFlag()
+		vui.ColourDescriptionPresentFlag = r.ReadFlag()
+		if vui.ColourDescriptionPresentFlag {
+			vui.ColourPrimaries = r.ReadBit(8)
+			vui.TransferCharacteristics = r.ReadBit(8)
+			vui.MatrixCoeffs = r.ReadBit(8)
+			vui.ColourPrimariesName = ColourPrimariesString[vui.ColourPrimaries]
+			vui.TransferCharacteristicsName = TransferCharacteristicsString[vui.TransferCharacteristics]
+			vui.MatrixCoeffsName = MatrixCoefficientsString[vui.MatrixCoeffs]
+		}
+	}
+	vui.ChromaLocInfoPresentFlag = r.ReadFlag()
+	if vui.ChromaLocInfoPresentFlag {
+		vui.ChromaSampleLocTypeTopField = r.ReadUE()
+		vui.ChromaSampleLocTypeBottomField = r.ReadUE()
+	}
+	vui.NeutralChromaIndicationFlag = r.ReadFlag()
+	vui.FieldSeqFlag = r.ReadFlag()
+	vui.FrameFieldInfoPresentFlag = r.ReadFlag()
+	vui.DefaultDisplayWindowFlag = r.ReadFlag()
+	if vui.DefaultDisplayWindowFlag {
+		vui.DefDispWinLeftOffset = r.ReadUE()
+		vui.DefDispWinRightOffset = r.ReadUE()
+		vui.DefDispWinTopOffset = r.ReadUE()
+		vui.DefDispWinBottomOffset = r.ReadUE()
+	}
+	vui.VuiTimingInfoPresentFlag = r.ReadFlag()
+	if vui.VuiTimingInfoPresentFlag {
+		vui.VuiNumUnitsInTick = r.ReadBit64(32)
+		vui.VuiTimeScale = r.ReadBit64(32)
+		vui.VuiPocProportionalToTimingFlag = r.ReadFlag()
+		if vui.VuiPocProportionalToTimingFlag {
+			vui.VuiNumTicksPocDiffOne = r.ReadUE() + 1
+		}
+		if r.ReadFlag() {
+			vui.Hrd = parseH265Hrd(r, maxSubLayers)
+		}
+	}
+	vui.BitstreamRestrictionFlag = r.ReadFlag()
+	if vui.BitstreamRestrictionFlag {
+		vui.TilesFixedStructureFlag = r.ReadFlag()
+		vui.MotionVectorsOverPicBoundaries = r.ReadFlag()
+		vui.RestrictedRefPicListsFlag = r.ReadFlag()
+		vui.MinSpatialSegmentationIdc = r.ReadUE()
+		vui.MaxBytesPerPicDenom = r.ReadUE()
+		vui.MaxBitsPerMinCuDenom = r.ReadUE()
+		vui.Log2MaxMvLengthHorizontal = r.ReadUE()
+		vui.Log2MaxMvLengthVertical = r.ReadUE()
+	}
+	return vui
+}
+
+// parseH265Hrd reads hrd_parameters(1, maxSubLayers-1), E.2.2.
+func parseH265Hrd(r *Reader, maxSubLayers int) *H265Hrd {
+	hrd := &H265Hrd{}
+	hrd.NalHrdParametersPresentFlag = r.ReadFlag()
+	hrd.VclHrdParametersPresentFlag = r.ReadFlag()
+	// Defaults when the common information is absent, E.3.2
+	hrd.InitialCpbRemovalDelayLength = 24
+	hrd.AuCpbRemovalDelayLength = 24
+	hrd.DpbOutputDelayLength = 24
+	if hrd.NalHrdParametersPresentFlag || hrd.VclHrdParametersPresentFlag {
+		hrd.SubPicHrdParamsPresentFlag = r.ReadFlag()
+		if hrd.SubPicHrdParamsPresentFlag {
+			hrd.TickDivisor = r.ReadBit(8) + 2
+			hrd.DuCpbRemovalDelayIncrementLength = r.ReadBit(5) + 1
+			hrd.SubPicCpbParamsInPicTimingSei = r.ReadFlag()
+			hrd.DpbOutputDelayDuLength = r.ReadBit(5) + 1
+		}
+		hrd.BitRateScale = r.ReadBit(4)
+		hrd.CpbSizeScale = r.ReadBit(4)
+		if hrd.SubPicHrdParamsPresentFlag {
+			r.SkipBit(4) // cpb_size_du_scale
+		}
+		hrd.InitialCpbRemovalDelayLength = r.ReadBit(5) + 1
+		hrd.AuCpbRemovalDelayLength = r.ReadBit(5) + 1
+		hrd.DpbOutputDelayLength = r.ReadBit(5) + 1
+	}
+	for i := 0; i < maxSubLayers; i++ {
+		fixedPicRate := r.ReadFlag()
+		if !fixedPicRate {
+			fixedPicRate = r.ReadFlag()
+		}
+		lowDelay := false
+		if fixedPicRate {
+			hrd.ElementalDurationInTc = r.ReadUE() + 1
+		} else {
+			lowDelay = r.ReadFlag()
+		}
+		cpbCnt := 1
+		if !lowDelay {
+			cpbCnt = r.ReadUE() + 1
+		}
+		hrd.FixedPicRateFlag = fixedPicRate
+		hrd.LowDelayHrdFlag = lowDelay
+		hrd.CpbCnt = cpbCnt
+		hrd.BitRate, hrd.CpbSize, hrd.CbrFlag = nil, nil, nil
+		for n := 0; n < b2i(hrd.NalHrdParametersPresentFlag)+b2i(hrd.VclHrdParametersPresentFlag); n++ {
+			for k := 0; k < cpbCnt; k++ {
+				bitRate := int64(r.ReadUE()) + 1
+				cpbSize := int64(r.ReadUE()) + 1
+				if hrd.SubPicHrdParamsPresentFlag {
+					r.ReadUE() // cpb_size_du_value_minus1
+					r.ReadUE() // bit_rate_du_value_minus1
+				}
+				cbr := r.ReadFlag()
+				if n == 0 {
+					hrd.BitRate = append(hrd.BitRate, bitRate<<uint(6+hrd.BitRateScale))
+					hrd.CpbSize = append(hrd.CpbSize, cpbSize<<uint(4+hrd.CpbSizeScale))
+					hrd.CbrFlag = append(hrd.CbrFlag, cbr)
+				}
+			}
+		}
+	}
+	return hrd
+}
+
+// ParseH265Pps parses the RBSP of a pic_parameter_set, NAL header included,
+// up to the extension flags.
+func ParseH265Pps(rbsp []byte) *H265Pps {
+	pps := &H265Pps{}
+	r := NewReader(rbsp)
+	r.SkipByte(2)
+	pps.PpsPicParameterSetId = r.ReadUE()
+	pps.PpsSeqParameterSetId = r.ReadUE()
+	pps.DependentSliceSegmentsEnabledFlag = r.ReadFlag()
+	pps.OutputFlagPresentFlag = r.ReadFlag()
+	pps.NumExtraSliceHeaderBits = r.ReadBit(3)
+	pps.SignDataHidingEnabledFlag = r.ReadFlag()
+	pps.CabacInitPresentFlag = r.ReadFlag()
+	pps.NumRefIdxL0DefaultActive = r.ReadUE() + 1
+	pps.NumRefIdxL1DefaultActive = r.ReadUE() + 1
+	pps.InitQp = r.ReadSE() + 26
+	pps.ConstrainedIntraPredFlag = r.ReadFlag()
+	pps.TransformSkipEnabledFlag = r.ReadFlag()
+	pps.CuQpDeltaEnabledFlag = r.ReadFlag()
+	if pps.CuQpDeltaEnabledFlag {
+		pps.DiffCuQpDeltaDepth = r.ReadUE()
+	}
+	pps.PpsCbQpOffset = r.ReadSE()
+	pps.PpsCrQpOffset = r.ReadSE()
+	pps.PpsSliceChromaQpOffsetsPresent = r.ReadFlag()
+	pps.WeightedPredFlag = r.ReadFlag()
+	pps.WeightedBipredFlag = r.ReadFlag()
+	pps.TransquantBypassEnabledFlag = r.ReadFlag()
+	pps.TilesEnabledFlag = r.ReadFlag()
+	pps.EntropyCodingSyncEnabledFlag = r.ReadFlag()
+	pps.NumTileColumns, pps.NumTileRows = 1, 1
+	if pps.TilesEnabledFlag {
+		pps.NumTileColumns = r.ReadUE() + 1
+		pps.NumTileRows = r.ReadUE() + 1
+		pps.UniformSpacingFlag = r.ReadFlag()
+		if !pps.UniformSpacingFlag {
+			for i := 0; i < pps.NumTileColumns-1+pps.NumTileRows-1; i++ {
+				r.ReadUE() // column_width_minus1, row_height_minus1
+			}
+		}
+		pps.LoopFilterAcrossTilesEnabledFlag = r.ReadFlag()
+	}
+	pps.LoopFilterAcrossSlicesEnabledFlag = r.ReadFlag()
+	pps.DeblockingFilterControlPresent = r.ReadFlag()
+	if pps.DeblockingFilterControlPresent {
+		pps.DeblockingFilterOverrideEnabled = r.ReadFlag()
+		pps.PpsDeblockingFilterDisabledFlag = r.ReadFlag()
+		if !pps.PpsDeblockingFilterDisabledFlag {
+			pps.PpsBetaOffset = r.ReadSE() * 2
+			pps.PpsTcOffset = r.ReadSE() * 2
+		}
+	}
+	pps.PpsScalingListDataPresentFlag = r.ReadFlag()
+	if pps.PpsScalingListDataPresentFlag {
+		skipH265ScalingListData(r)
+	}
+	pps.ListsModificationPresentFlag = r.ReadFlag()
+	pps.Log2ParallelMergeLevel = r.ReadUE() + 2
+	pps.SliceSegmentHeaderExtensionFlag = r.ReadFlag()
+	return pps
+}
+
+// H265ParamSet is a VPS, SPS or PPS that is new or differs from the previous
+// one with the same id.
+type H265ParamSet struct {
+	Pos int64
+	Pts int64
+	Vps *H265Vps `json:",omitempty"`
+	Sps *H265Sps `json:",omitempty"`
+	Pps *H265Pps `json:",omitempty"`
+}
+
+func (s *H265Record) parseVps(p *PesPkt, nal NalUnit) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Println("VPS parsing error at", p.Pos, r)
+		}
+	}()
+	vps := ParseH265Vps(nal.Rbsp)
+	if s.Vps == nil {
+		s.Vps = make(map[int]*H265Vps)
+		s.rawVps = make(map[int]string)
+	}
+	if s.rawVps[vps.VpsVideoParameterSetId] != string(nal.Data) {
+		s.rawVps[vps.VpsVideoParameterSetId] = string(nal.Data)
+		s.ParamSets = append(s.ParamSets, H265ParamSet{Pos: p.Pos, Pts: p.Pts, Vps: vps})
+	}
+	s.Vps[vps.VpsVideoParameterSetId] = vps
+}
+
+func (s *H265Record) parseSps(p *PesPkt, nal NalUnit) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Println("SPS parsing error at", p.Pos, r)
+		}
+	}()
+	sps := ParseH265Sps(nal.Rbsp)
+	if s.Sps == nil {
+		s.Sps = make(map[int]*H265Sps)
+		s.rawSps = make(map[int]string)
+	}
+	if s.rawSps[sps.SpsSeqParameterSetId] != string(nal.Data) {
+		s.rawSps[sps.SpsSeqParameterSetId] = string(nal.Data)
+		s.ParamSets = append(s.ParamSets, H265ParamSet{Pos: p.Pos, Pts: p.Pts, Sps: sps})
+	}
+	s.Sps[sps.SpsSeqParameterSetId] = sps
+}
+
+func (s *H265Record) parsePps(p *PesPkt, nal NalUnit) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Println("PPS parsing error at", p.Pos, r)
+		}
+	}()
+	pps := ParseH265Pps(nal.Rbsp)
+	if s.Pps == nil {
+		s.Pps = make(map[int]*H265Pps)
+		s.rawPps = make(map[int]string)
+	}
+	if s.rawPps[pps.PpsPicParameterSetId] != string(nal.Data) {
+		s.rawPps[pps.PpsPicParameterSetId] = string(nal.Data)
+		s.ParamSets = append(s.ParamSets, H265ParamSet{Pos: p.Pos, Pts: p.Pts, Pps: pps})
+	}
+	s.Pps[pps.PpsPicParameterSetId] = pps
+}
+
+func (s *H265Record) reportParamSets(root string) {
+	if len(s.ParamSets) == 0 {
+		return
+	}
+	fname := filepath.Join(root, strconv.Itoa(s.Pid)+"-sps.json")
+	w, err := os.Create(fname)
+	if err != nil {
+		panic(err)
+	}
+	defer w.Close()
+
+	buf, _ := json.MarshalIndent(s.ParamSets, "", "  ")
+	fmt.Fprintln(w, string(buf))
+}
@@ -0,0 +1,172 @@
+package mpts
+
+import (
+	"fmt"
+	"log"
+)
+
+// ITU-T T.35 terminal provider of HDR10+, Samsung
+const Hdr10PlusProviderCode = 0x003C
+
+// MasteringDisplayColourVolume is the SMPTE ST 2086 metadata of an SEI
+// message. Primaries are in G, B, R order, in units of 0.00002, and
+// luminances in units of 0.0001 cd/m2.
+type MasteringDisplayColourVolume struct {
+	DisplayPrimariesX            [3]int
+	DisplayPrimariesY            [3]int
+	WhitePointX                  int
+	WhitePointY                  int
+	MaxDisplayMasteringLuminance int64
+	MinDisplayMasteringLuminance int64
+	// Derived, in the notation of x265 --master-display
+	MasterDisplay string
+}
+
+// ContentLightLevel holds MaxCLL and MaxFALL in cd/m2.
+type ContentLightLevel struct {
+	MaxContentLightLevel    int
+	MaxPicAverageLightLevel int
+}
+
+type AlternativeTransfer struct {
+	PreferredTransferCharacteristics int
+	Name                             string `json:",omitempty"`
+}
+
+// Hdr10Plus is the SMPTE ST 2094-40 dynamic metadata of a T.35 payload.
+// Luminances are in cd/m2, maxRGB values in units of 0.00001.
+type Hdr10Plus struct {
+	ApplicationIdentifier                    int
+	ApplicationVersion                       int
+	NumWindows                               int
+	TargetedSystemDisplayMaximumLuminance    int
+	TargetedSystemDisplayActualPeakLuminance [][]int `json:",omitempty"`
+	Windows                                  []Hdr10PlusWindow
+	MasteringDisplayActualPeakLuminance      [][]int `json:",omitempty"`
+}
+
+type Hdr10PlusWindow struct {
+	Maxscl                  [3]int
+	AverageMaxrgb           int
+	DistributionPercentages []int `json:",omitempty"`
+	DistributionPercentiles []int `json:",omitempty"`
+	FractionBrightPixels    int
+	ToneMappingFlag         bool
+	KneePointX              int   `json:",omitempty"`
+	KneePointY              int   `json:",omitempty"`
+	BezierCurveAnchors      []int `json:",omitempty"`
+	ColorSaturationMapping  bool
+	ColorSaturationWeight   int `json:",omitempty"`
+}
+
+func ParseMasteringDisplayColourVolume(payload []byte) *MasteringDisplayColourVolume {
+	r := NewReader(payload)
+	m := &MasteringDisplayColourVolume{}
+	for c := 0; c < 3; c++ {
+		m.DisplayPrimariesX[c] = r.ReadBit(16)
+		m.DisplayPrimariesY[c] = r.ReadBit(16)
+	}
+	m.WhitePointX = r.ReadBit(16)
+	m.WhitePointY = r.ReadBit(16)
+	m.MaxDisplayMasteringLuminance = r.ReadBit64(32)
+	m.MinDisplayMasteringLuminance = r.ReadBit64(32)
+	m.MasterDisplay = fmt.Sprintf("G(%d,%d)B(%d,%d)R(%d,%d)WP(%d,%d)L(%d,%d)",
+		m.DisplayPrimariesX[0], m.DisplayPrimariesY[0],
+		m.DisplayPrimariesX[1], m.DisplayPrimariesY[1],
+		m.DisplayPrimariesX[2], m.DisplayPrimariesY[2],
+		m.WhitePointX, m.WhitePointY,
+		m.MaxDisplayMasteringLuminance, m.MinDisplayMasteringLuminance)
+	return m
+}
+
+func ParseContentLightLevel(payload []byte) *ContentLightLevel {
+	r := NewReader(payload)
+	c := &ContentLightLevel{}
+	c.MaxContentLightLevel = r.ReadBit(16)
+	c.MaxPicAverageLightLevel = r.ReadBit(16)
+	return c
+}
+
+func ParseAlternativeTransfer(payload []byte) *AlternativeTransfer {
+	r := NewReader(payload)
+	a := &AlternativeTransfer{}
+	a.PreferredTransferCharacteristics = r.ReadBit(8)
+	a.Name = TransferCharacteristicsString[a.PreferredTransferCharacteristics]
+	return a
+}
+
+func readLuminanceTable(r *Reader) [][]int {
+	rows := r.ReadBit(5)
+	cols := r.ReadBit(5)
+	table := make([][]int, rows)
+	for i := range table {
+		for j := 0; j < cols; j++ {
+			table[i] = append(table[i], r.ReadBit(4))
+		}
+	}
+	return table
+}
+
+// ParseHdr10Plus parses the ST 2094-40 payload following the T.35 terminal
+// provider code. It returns nil for other applications.
+func ParseHdr10Plus(data []byte) (h *Hdr10Plus) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Println("HDR10+ metadata truncated")
+		}
+	}()
+	r := NewReader(data)
+	if r.ReadBit(16) != 0x0001 { // terminal_provider_oriented_code
+		return nil
+	}
+	h = &Hdr10Plus{}
+	h.ApplicationIdentifier = r.ReadBit(8)
+	if h.ApplicationIdentifier != 4 {
+		return nil
+	}
+	h.ApplicationVersion = r.ReadBit(8)
+	h.NumWindows = r.ReadBit(2)
+	for w := 1; w < h.NumWindows; w++ {
+		// Window geometry: corners, ellipse center, rotation, axes and
+		// overlap_process_option
+		r.SkipBit(16*6 + 8 + 16*3 + 1)
+	}
+	h.TargetedSystemDisplayMaximumLuminance = r.ReadBit(27)
+	if r.ReadFlag() {
+		h.TargetedSystemDisplayActualPeakLuminance = readLuminanceTable(r)
+	}
+	h.Windows = make([]Hdr10PlusWindow, h.NumWindows)
+	for w := range h.Windows {
+		win := &h.Windows[w]
+		for i := 0; i < 3; i++ {
+			win.Maxscl[i] = r.ReadBit(17)
+		}
+		win.AverageMaxrgb = r.ReadBit(17)
+		n := r.ReadBit(4)
+		for i := 0; i < n; i++ {
+			win.DistributionPercentages = append(win.DistributionPercentages, r.ReadBit(7))
+			win.DistributionPercentiles = append(win.DistributionPercentiles, r.ReadBit(17))
+		}
+		win.FractionBrightPixels = r.ReadBit(10)
+	}
+	if r.ReadFlag() {
+		h.MasteringDisplayActualPeakLuminance = readLuminanceTable(r)
+	}
+	for w := range h.Windows {
+		win := &h.Windows[w]
+		win.ToneMappingFlag = r.ReadFlag()
+		if win.ToneMappingFlag {
+			win.KneePointX = r.ReadBit(12)
+			win.KneePointY = r.ReadBit(12)
+			n := r.ReadBit(4)
+			for i := 0; i < n; i++ {
+				win.BezierCurveAnchors = append(win.BezierCurveAnchors, r.ReadBit(10))
+			}
+		}
+		win.ColorSaturationMapping = r.ReadFlag()
+		if win.ColorSaturationMapping {
+			win.ColorSaturationWeight = r.ReadBit(6)
+		}
+	}
+	return h
+}
@@ -16,6 +16,7 @@ type Mp2vUserData struct {
 	AFD     *int
 	Caption bool
 	Bar     bool
+	BarData *BarData `json:",omitempty"`
 }
 
 type Mp2vTimeCode struct {
@@ -29,7 +30,10 @@ type Mp2vTimeCode struct {
 type Mp2vHeaders struct {
 	*Mp2vGopHeader
 	*Mp2vPicHeader
-	UserData []*Mp2vUserData
+	UserData     []*Mp2vUserData
+	SeqHeader    *Mp2vSeqHeader
+	SeqExt       *Mp2vSeqExt
+	PicCodingExt *Mp2vPicCodingExt
 }
 
 type Mp2vGopHeader struct {
@@ -52,12 +56,17 @@ func ParseATSC(data []byte) (cc bool, bar bool) {
 	return
 }
 
+// ParseAFD returns the active_format of afd_data, which is only present
+// when active_format_flag is set.
 func ParseAFD(data []byte) (int, error) {
-	if data[0] == 0x41 {
-		format := data[1] & 0x0F
-		return int(format), nil
+	if len(data) < 2 {
+		return 0, errors.New("Active format does not exist")
+	}
+	afd := ParseAfdData(NewReader(data))
+	if !afd.ActiveFormatFlag {
+		return 0, errors.New("Active format does not exist")
 	}
-	return 0, errors.New("Active format does not exist")
+	return afd.ActiveFormat, nil
 }
 
 func ParseMp2vUserData(data []byte) *Mp2vUserData {
@@ -66,6 +75,11 @@ func ParseMp2vUserData(data []byte) *Mp2vUserData {
 	var idAFD = []byte("DTG1")  // 0x44544731
 	if bytes.Compare(idATSC, data[0:4]) == 0 {
 		result.Caption, result.Bar = ParseATSC(data[4:])
+		if result.Bar {
+			if u := ParseA53UserData(data); u != nil {
+				result.BarData = u.Bar
+			}
+		}
 	} else if bytes.Compare(idAFD, data[0:4]) == 0 {
 		afd, err := ParseAFD(data[4:])
 		if err == nil {
@@ -92,6 +106,15 @@ func ParseMp2vHeaders(data []byte) Mp2vHeaders {
 				result.Mp2vPicHeader = ParseMp2vPicHeader(elem)
 			} else if code == 0xB8 {
 				result.Mp2vGopHeader = ParseMp2vGopHeader(elem)
+			} else if code == 0xB3 && len(elem) >= 8 {
+				result.SeqHeader = ParseMp2vSeqHeader(elem)
+			} else if code == 0xB5 && len(elem) >= 6 {
+				switch ParseMp2vExtensionId(elem) {
+				case mp2vSequenceExtensionId:
+					result.SeqExt = ParseMp2vSeqExt(elem)
+				case mp2vPictureCodingExtensionId:
+					result.PicCodingExt = ParseMp2vPicCodingExt(elem)
+				}
 			}
 		}
 		pos += 1
@@ -125,17 +148,52 @@ type Mp2vRecord struct {
 	curpkt   *PesPkt
 	Pkts     []*PesPkt
 	UserData []*Mp2vUserData
+	// Sequences as they change, and coded pictures
+	Sequences  []*Mp2vSequence
+	Pictures   []*Mp2vPicture
+	seqHeader  *Mp2vSeqHeader
+	seqExt     *Mp2vSeqExt
+	seqPos     int64
+	rawSeq     string
+	lastRawSeq string
+	picExt     bool
+	splitter   NalSplitter
+	au         AuTimer
+	captions   CaptionExtractor
+	afd        AfdTimeline
+}
+
+// addUnits accounts the start code units of a PES packet to access units.
+func (s *Mp2vRecord) addUnits(p *PesPkt, units []NalUnit) {
+	for _, unit := range units {
+		kind, vcl := mp2vAuKind(unit)
+		intra := unit.Data[0] == 0x00 && len(unit.Data) > 2 && (unit.Data[2]>>3)&0x07 == 1
+		s.au.Add(p, unit, kind, vcl, intra)
+		s.addHeaders(p, unit)
+	}
 }
 
 func (s *Mp2vRecord) Process(pkt *TsPkt) {
+	ccOk, duplicate := s.CheckCC(pkt)
+	if duplicate {
+		return
+	}
 	s.LogAdaptFieldPrivData(pkt)
 	if pkt.PUSI == 1 {
 		if s.curpkt != nil {
+			s.curpkt.CCError = s.curpkt.CCError || !ccOk
+			s.CheckPes(s.curpkt)
+			units := s.splitter.Write(s.curpkt.Data)
+			if pesPayloadStartsNal(pkt.Data) {
+				units = append(units, s.splitter.Flush()...)
+			}
+			s.addUnits(s.curpkt, units)
 			headers := ParseMp2vHeaders(s.curpkt.Data)
 			if headers.Mp2vPicHeader != nil && headers.Mp2vPicHeader.PictureCodingType == 1 {
 				i := IFrameInfo{}
 				i.Pos = s.curpkt.Pos
 				i.Pts = s.curpkt.Pts
+				i.Type = "I"
 				if headers.Mp2vGopHeader != nil {
 					i.Key = headers.Mp2vGopHeader.ClosedGop == 1
 				}
@@ -155,7 +213,11 @@ func (s *Mp2vRecord) Process(pkt *TsPkt) {
 		if 0 == bytes.Compare(startcode, pkt.Data[0:3]) {
 			hlen := s.curpkt.Read(pkt.Data)
 			pkt.Data = pkt.Data[hlen:]
+		} else {
+			s.curpkt.StartCodeError = true
 		}
+	} else if s.curpkt != nil && !ccOk {
+		s.curpkt.CCError = true
 	}
 	if s.curpkt != nil {
 		s.curpkt.Size += int64(len(pkt.Data))
@@ -165,6 +227,8 @@ func (s *Mp2vRecord) Process(pkt *TsPkt) {
 
 func (s *Mp2vRecord) Flush() {
 	if s.curpkt != nil {
+		s.CheckPes(s.curpkt)
+		s.addUnits(s.curpkt, append(s.splitter.Write(s.curpkt.Data), s.splitter.Flush()...))
 		s.Pkts = append(s.Pkts, s.curpkt)
 	}
 }
@@ -176,6 +240,13 @@ func (s *Mp2vRecord) Report(root string) {
 	var pid string = strconv.Itoa(s.Pid)
 	var header string
 
+	s.ReportPesErrors(root)
+	s.reportSequences(root)
+	s.reportPictures(root)
+	s.au.Report(root, s.Pid)
+	s.captions.Report(root, s.Pid)
+	s.afd.Report(root, s.Pid)
+
 	fname = filepath.Join(root, pid+".csv")
 	w, err = os.Create(fname)
 	if err != nil {
@@ -0,0 +1,434 @@
+package mpts
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// aspect_ratio_information, Table 6-3
+var Mp2vAspectRatioString = map[int]string{
+	1: "1:1",
+	2: "4:3",
+	3: "16:9",
+	4: "2.21:1",
+}
+
+// frame_rate_code, Table 6-4
+var Mp2vFrameRate = map[int][2]int{
+	1: {24000, 1001},
+	2: {24, 1},
+	3: {25, 1},
+	4: {30000, 1001},
+	5: {30, 1},
+	6: {50, 1},
+	7: {60000, 1001},
+	8: {60, 1},
+}
+
+// profile_and_level_indication, Tables 8-2 and 8-3
+var Mp2vProfileString = map[int]string{
+	1: "High",
+	2: "Spatially Scalable",
+	3: "SNR Scalable",
+	4: "Main",
+	5: "Simple",
+}
+
+var Mp2vLevelString = map[int]string{
+	4:  "High",
+	6:  "High 1440",
+	8:  "Main",
+	10: "Low",
+}
+
+// chroma_format, Table 6-5
+var Mp2vChromaFormatString = map[int]string{
+	1: "4:2:0",
+	2: "4:2:2",
+	3: "4:4:4",
+}
+
+// picture_coding_type, Table 6-12
+var Mp2vPictureCodingTypeString = map[int]string{
+	1: "I",
+	2: "P",
+	3: "B",
+	4: "D",
+}
+
+// picture_structure, Table 6-14
+var Mp2vPictureStructureString = map[int]string{
+	1: "top",
+	2: "bottom",
+	3: "frame",
+}
+
+// Extension start code identifiers, Table 6-2
+const (
+	mp2vSequenceExtensionId      = 1
+	mp2vPictureCodingExtensionId = 8
+)
+
+type Mp2vSeqHeader struct {
+	HorizontalSizeValue       int
+	VerticalSizeValue         int
+	AspectRatioInformation    int
+	FrameRateCode             int
+	BitRateValue              int
+	VbvBufferSizeValue        int
+	ConstrainedParametersFlag int
+}
+
+type Mp2vSeqExt struct {
+	ProfileAndLevelIndication int
+	ProgressiveSequence       int
+	ChromaFormat              int
+	HorizontalSizeExtension   int
+	VerticalSizeExtension     int
+	BitRateExtension          int
+	VbvBufferSizeExtension    int
+	LowDelay                  int
+	FrameRateExtensionN       int
+	FrameRateExtensionD       int
+}
+
+type Mp2vPicCodingExt struct {
+	FCode                    [2][2]int
+	IntraDcPrecision         int
+	PictureStructure         int
+	TopFieldFirst            int
+	FramePredFrameDct        int
+	ConcealmentMotionVectors int
+	QScaleType               int
+	IntraVlcFormat           int
+	AlternateScan            int
+	RepeatFirstField         int
+	Chroma420Type            int
+	ProgressiveFrame         int
+}
+
+func ParseMp2vSeqHeader(data []byte) *Mp2vSeqHeader {
+	r := &Reader{Data: data}
+	h := &Mp2vSeqHeader{}
+	h.HorizontalSizeValue = r.ReadBit(12)
+	h.VerticalSizeValue = r.ReadBit(12)
+	h.AspectRatioInformation = r.ReadBit(4)
+	h.FrameRateCode = r.ReadBit(4)
+	h.BitRateValue = r.ReadBit(18)
+	r.ReadBit(1) // marker_bit
+	h.VbvBufferSizeValue = r.ReadBit(10)
+	h.ConstrainedParametersFlag = r.ReadBit(1)
+	return h
+}
+
+// ParseMp2vExtensionId returns the extension_start_code_identifier.
+func ParseMp2vExtensionId(data []byte) int {
+	return int(data[0] >> 4)
+}
+
+func ParseMp2vSeqExt(data []byte) *Mp2vSeqExt {
+	r := &Reader{Data: data}
+	h := &Mp2vSeqExt{}
+	r.ReadBit(4)
+	h.ProfileAndLevelIndication = r.ReadBit(8)
+	h.ProgressiveSequence = r.ReadBit(1)
+	h.ChromaFormat = r.ReadBit(2)
+	h.HorizontalSizeExtension = r.ReadBit(2)
+	h.VerticalSizeExtension = r.ReadBit(2)
+	h.BitRateExtension = r.ReadBit(12)
+	r.ReadBit(1) // marker_bit
+	h.VbvBufferSizeExtension = r.ReadBit(8)
+	h.LowDelay = r.ReadBit(1)
+	h.FrameRateExtensionN = r.ReadBit(2)
+	h.FrameRateExtensionD = r.ReadBit(5)
+	return h
+}
+
+func ParseMp2vPicCodingExt(data []byte) *Mp2vPicCodingExt {
+	r := &Reader{Data: data}
+	h := &Mp2vPicCodingExt{}
+	r.ReadBit(4)
+	for s := 0; s < 2; s++ {
+		for t := 0; t < 2; t++ {
+			h.FCode[s][t] = r.ReadBit(4)
+		}
+	}
+	h.IntraDcPrecision = r.ReadBit(2)
+	h.PictureStructure = r.ReadBit(2)
+	h.TopFieldFirst = r.ReadBit(1)
+	h.FramePredFrameDct = r.ReadBit(1)
+	h.ConcealmentMotionVectors = r.ReadBit(1)
+	h.QScaleType = r.ReadBit(1)
+	h.IntraVlcFormat = r.ReadBit(1)
+	h.AlternateScan = r.ReadBit(1)
+	h.RepeatFirstField = r.ReadBit(1)
+	h.Chroma420Type = r.ReadBit(1)
+	h.ProgressiveFrame = r.ReadBit(1)
+	return h
+}
+
+// Mp2vSequence is a sequence header with its extension, absent in MPEG-1,
+// and the values derived from them.
+type Mp2vSequence struct {
+	Pos    int64
+	Header *Mp2vSeqHeader
+	Ext    *Mp2vSeqExt `json:",omitempty"`
+	// Derived
+	Width         int
+	Height        int
+	AspectRatio   string
+	FrameRate     float64
+	BitRate       int    // in bits/s
+	VbvBufferSize int    // in bits
+	Profile       string `json:",omitempty"`
+	Level         string `json:",omitempty"`
+	ChromaFormat  string
+	Progressive   bool
+}
+
+func newMp2vSequence(pos int64, h *Mp2vSeqHeader, ext *Mp2vSeqExt) *Mp2vSequence {
+	seq := &Mp2vSequence{Pos: pos, Header: h, Ext: ext}
+	seq.Width = h.HorizontalSizeValue
+	seq.Height = h.VerticalSizeValue
+	seq.AspectRatio = Mp2vAspectRatioString[h.AspectRatioInformation]
+	rate := Mp2vFrameRate[h.FrameRateCode]
+	bitRate := h.BitRateValue
+	vbv := h.VbvBufferSizeValue
+	// MPEG-1 is progressive 4:2:0
+	seq.ChromaFormat = Mp2vChromaFormatString[1]
+	seq.Progressive = true
+	if ext != nil {
+		seq.Width |= ext.HorizontalSizeExtension << 12
+		seq.Height |= ext.VerticalSizeExtension << 12
+		rate[0] *= ext.FrameRateExtensionN + 1
+		rate[1] *= ext.FrameRateExtensionD + 1
+		bitRate |= ext.BitRateExtension << 18
+		vbv |= ext.VbvBufferSizeExtension << 10
+		if ext.ProfileAndLevelIndication&0x80 == 0 {
+			seq.Profile = Mp2vProfileString[ext.ProfileAndLevelIndication>>4&0x07]
+			seq.Level = Mp2vLevelString[ext.ProfileAndLevelIndication&0x0F]
+		}
+		seq.ChromaFormat = Mp2vChromaFormatString[ext.ChromaFormat]
+		seq.Progressive = ext.ProgressiveSequence == 1
+	}
+	if rate[1] != 0 {
+		seq.FrameRate = float64(rate[0]) / float64(rate[1])
+	}
+	seq.BitRate = bitRate * 400
+	seq.VbvBufferSize = vbv * 16 * 1024
+	return seq
+}
+
+// Mp2vPicture is a coded picture; the picture coding extension fields are
+// those implied for MPEG-1 when it is absent.
+type Mp2vPicture struct {
+	Pos               int64
+	Pts               int64
+	Dts               int64
+	Type              string
+	TemporalReference int
+	Structure         string
+	TopFieldFirst     bool
+	RepeatFirstField  bool
+	ProgressiveFrame  bool
+}
+
+// Fields returns the number of field periods the picture is displayed for,
+// 6.3.10.
+func (pic *Mp2vPicture) Fields(seq *Mp2vSequence) int {
+	switch {
+	case pic.Structure != "frame":
+		return 1
+	case seq != nil && seq.Progressive && pic.RepeatFirstField && pic.TopFieldFirst:
+		return 6
+	case seq != nil && seq.Progressive && pic.RepeatFirstField:
+		return 4
+	case pic.RepeatFirstField:
+		return 3
+	}
+	return 2
+}
+
+// Mp2vScan summarizes the picture structure of a video PID. Scan is
+// progressive, interlaced or mixed; Pulldown names the field repetition
+// found in progressive frames of an interlaced sequence. PictureRate is the
+// rate of coded frames, lower than the frame rate with pulldown.
+type Mp2vScan struct {
+	Pictures          int
+	FramePictures     int
+	FieldPictures     int
+	ProgressiveFrames int
+	TopFieldFirst     int
+	RepeatFirstField  int
+	Scan              string
+	Pulldown          string `json:",omitempty"`
+	FrameRate         float64
+	PictureRate       float64
+}
+
+func (s *Mp2vRecord) scanSummary() Mp2vScan {
+	var sum Mp2vScan
+	var seq *Mp2vSequence
+	if len(s.Sequences) > 0 {
+		seq = s.Sequences[len(s.Sequences)-1]
+		sum.FrameRate = seq.FrameRate
+	}
+	fields := 0
+	for _, pic := range s.Pictures {
+		sum.Pictures += 1
+		if pic.Structure == "frame" {
+			sum.FramePictures += 1
+		} else {
+			sum.FieldPictures += 1
+		}
+		if pic.ProgressiveFrame {
+			sum.ProgressiveFrames += 1
+		}
+		if pic.TopFieldFirst {
+			sum.TopFieldFirst += 1
+		}
+		if pic.RepeatFirstField {
+			sum.RepeatFirstField += 1
+		}
+		fields += pic.Fields(seq)
+	}
+	switch {
+	case seq != nil && seq.Progressive, sum.ProgressiveFrames == sum.Pictures:
+		sum.Scan = "progressive"
+	case sum.ProgressiveFrames == 0:
+		sum.Scan = "interlaced"
+	default:
+		sum.Scan = "mixed"
+	}
+	// 3:2 pulldown repeats a field on every other progressive frame: 4
+	// frames make 10 fields
+	if seq != nil && !seq.Progressive && sum.FramePictures > 0 {
+		ratio := float64(sum.RepeatFirstField) / float64(sum.FramePictures)
+		if sum.ProgressiveFrames == sum.FramePictures && ratio > 0.4 && ratio < 0.6 {
+			sum.Pulldown = "3:2"
+		} else if sum.RepeatFirstField > 0 {
+			sum.Pulldown = "irregular"
+		}
+	} else if sum.RepeatFirstField > 0 {
+		sum.Pulldown = "frame repeat"
+	}
+	if fields > 0 {
+		frames := float64(sum.FramePictures) + float64(sum.FieldPictures)/2
+		sum.PictureRate = sum.FrameRate * 2 * frames / float64(fields)
+	}
+	return sum
+}
+
+// addHeaders decodes a sequence header, picture header, extension or user
+// data unit of PES packet p. A sequence is recorded at its first picture when it changes.
+func (s *Mp2vRecord) addHeaders(p *PesPkt, unit NalUnit) {
+	// Restore the zero bytes the splitter took for trailing stuffing
+	data := make([]byte, len(unit.Data)+8)
+	copy(data, unit.Data[1:])
+	switch unit.Data[0] {
+	case 0xB2:
+		if u := ParseA53UserData(data); u != nil {
+			if u.CcData != nil {
+				s.captions.Add(s.au.cur.Pts, u.CcData)
+			}
+			s.afd.Add(s.au.cur.Pos, s.au.cur.Pts, u)
+		}
+	case 0xB3:
+		s.seqHeader = ParseMp2vSeqHeader(data)
+		s.seqExt = nil
+		s.seqPos = p.Pos
+		s.rawSeq = string(data[:8])
+	case 0xB5:
+		switch ParseMp2vExtensionId(data) {
+		case mp2vSequenceExtensionId:
+			if s.seqHeader != nil && s.seqExt == nil {
+				s.seqExt = ParseMp2vSeqExt(data)
+				s.rawSeq += string(data[:6])
+			}
+		case mp2vPictureCodingExtensionId:
+			if n := len(s.Pictures); n > 0 && s.picExt {
+				ext := ParseMp2vPicCodingExt(data)
+				pic := s.Pictures[n-1]
+				pic.Structure = Mp2vPictureStructureString[ext.PictureStructure]
+				pic.TopFieldFirst = ext.TopFieldFirst == 1
+				pic.RepeatFirstField = ext.RepeatFirstField == 1
+				pic.ProgressiveFrame = ext.ProgressiveFrame == 1
+				s.picExt = false
+			}
+		}
+	case 0x00:
+		if s.seqHeader != nil && s.rawSeq != s.lastRawSeq {
+			s.Sequences = append(s.Sequences, newMp2vSequence(s.seqPos, s.seqHeader, s.seqExt))
+			s.lastRawSeq = s.rawSeq
+		}
+		h := ParseMp2vPicHeader(data)
+		pic := &Mp2vPicture{
+			Pos:               p.Pos,
+			Pts:               s.au.cur.Pts,
+			Dts:               s.au.cur.Dts,
+			Type:              Mp2vPictureCodingTypeString[h.PictureCodingType],
+			TemporalReference: h.TemporalReference,
+			Structure:         "frame",
+			ProgressiveFrame:  true,
+		}
+		s.Pictures = append(s.Pictures, pic)
+		s.picExt = true
+	}
+}
+
+func (s *Mp2vRecord) reportSequences(root string) {
+	if len(s.Sequences) == 0 {
+		return
+	}
+	fname := filepath.Join(root, strconv.Itoa(s.Pid)+"-sequence.json")
+	w, err := os.Create(fname)
+	if err != nil {
+		panic(err)
+	}
+	defer w.Close()
+
+	buf, _ := json.MarshalIndent(s.Sequences, "", "  ")
+	fmt.Fprintln(w, string(buf))
+}
+
+func (s *Mp2vRecord) reportPictures(root string) {
+	if len(s.Pictures) == 0 {
+		return
+	}
+	pid := strconv.Itoa(s.Pid)
+
+	fname := filepath.Join(root, pid+"-picture.csv")
+	w, err := os.Create(fname)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Fprintln(w, "Pos, PTS, DTS, Type, TemporalReference, Structure, TFF, RFF, ProgressiveFrame")
+	for _, pic := range s.Pictures {
+		cols := []string{
+			strconv.FormatInt(pic.Pos, 10),
+			formatTimestamp(pic.Pts),
+			formatTimestamp(pic.Dts),
+			pic.Type,
+			strconv.Itoa(pic.TemporalReference),
+			pic.Structure,
+			strconv.FormatBool(pic.TopFieldFirst),
+			strconv.FormatBool(pic.RepeatFirstField),
+			strconv.FormatBool(pic.ProgressiveFrame),
+		}
+		fmt.Fprintln(w, strings.Join(cols, ", "))
+	}
+	w.Close()
+
+	fname = filepath.Join(root, pid+"-scan.json")
+	w, err = os.Create(fname)
+	if err != nil {
+		panic(err)
+	}
+	defer w.Close()
+	buf, _ := json.MarshalIndent(s.scanSummary(), "", "  ")
+	fmt.Fprintln(w, string(buf))
+}
@@ -0,0 +1,437 @@
+package mpts
+
+import (
+	"fmt"
+	"io"
+)
+
+// MuxOptions configures a single program transport stream. Intervals and
+// delays are in 27 MHz units; zero values select the defaults.
+type MuxOptions struct {
+	TransportStreamId int
+	ProgramNumber     int
+	PmtPid            int
+	PcrPid            int   // defaults to the first stream added
+	PcrInterval       int64 // defaults to 40 ms
+	PsiInterval       int64 // defaults to 100 ms
+	Delay             int64 // DTS - PCR of each access unit, defaults to 700 ms
+	MuxRate           int64 // in bits per second, defaults to 10 Mbit/s
+}
+
+// AccessUnit is one unit of elementary stream data. Pts and Dts are in
+// 90 kHz units; a zero Dts means Dts equals Pts.
+type AccessUnit struct {
+	Data         []byte
+	Pts          int64
+	Dts          int64
+	RandomAccess bool
+	PrivateData  []AdaptFieldPrivData
+}
+
+type muxStream struct {
+	Stream
+	streamId int
+}
+
+// Muxer writes a single program transport stream from access units and
+// sections. Access units must be written in DTS order across streams,
+// since the PCR is derived from the DTS.
+//
+// The stream has a constant rate of MuxRate: the clock advances with every
+// packet written, each PCR is the clock at its byte position, and null
+// packets fill the time until the next access unit is due. An access unit
+// that does not fit in the rate is written late, shortening its Delay.
+type Muxer struct {
+	MuxOptions
+	Out     io.Writer
+	streams map[int]*muxStream
+	order   []int
+	cc      map[int]int
+	start   int64 // clock at the first byte
+	bytes   int64 // written so far
+	pcr     int64
+	psi     int64
+	started bool
+}
+
+func NewMuxer(out io.Writer, opt MuxOptions) *Muxer {
+	if opt.ProgramNumber == 0 {
+		opt.ProgramNumber = 1
+	}
+	if opt.PmtPid == 0 {
+		opt.PmtPid = 0x1000
+	}
+	if opt.PcrInterval == 0 {
+		opt.PcrInterval = 40 * 27000
+	}
+	if opt.PsiInterval == 0 {
+		opt.PsiInterval = 100 * 27000
+	}
+	if opt.Delay == 0 {
+		opt.Delay = 700 * 27000
+	}
+	if opt.MuxRate == 0 {
+		opt.MuxRate = 10000000
+	}
+	return &Muxer{
+		MuxOptions: opt,
+		Out:        out,
+		streams:    make(map[int]*muxStream),
+		cc:         make(map[int]int),
+		pcr:        -1,
+		psi:        -1,
+	}
+}
+
+// AddStream declares an elementary stream of the program. Streams must be
+// added before anything is written.
+func (m *Muxer) AddStream(pid int, streamTypeId int, descriptors ...Descriptor) {
+	if m.started {
+		panic("mux: stream added after start")
+	}
+	s := &muxStream{}
+	s.StreamTypeId = streamTypeId
+	s.StreamType = GetStreamType(s.Stream)
+	s.Pid = pid
+	s.Descriptors = descriptors
+	switch {
+	case IsVideoStreamType(s.StreamType):
+		s.streamId = 0xE0 + m.countStreamId(0xE0)
+	case s.StreamType == "AC-3 Audio" || s.StreamType == "E-AC-3 Audio" || s.StreamType == "Private PES":
+		s.streamId = 0xBD
+	default:
+		s.streamId = 0xC0 + m.countStreamId(0xC0)
+	}
+	m.streams[pid] = s
+	m.order = append(m.order, pid)
+	if m.PcrPid == 0 {
+		m.PcrPid = pid
+	}
+	m.cc[pid] = 0x0F
+}
+
+func (m *Muxer) countStreamId(base int) int {
+	n := 0
+	for _, s := range m.streams {
+		if s.streamId&0xF0 == base {
+			n++
+		}
+	}
+	return n
+}
+
+// NewDescriptor builds a descriptor for AddStream.
+func NewDescriptor(tag int, data []byte) Descriptor {
+	return Descriptor{Tag: tag, TagName: GetDescriptorTabString(tag), data: data}
+}
+
+func (m *Muxer) stream(pid int) *muxStream {
+	s, ok := m.streams[pid]
+	if !ok {
+		panic(fmt.Sprintf("mux: unknown pid %d", pid))
+	}
+	return s
+}
+
+// WriteAccessUnit packetizes au into one PES packet on pid. It returns an
+// error if the PES packet or the adaptation field private data is too long.
+func (m *Muxer) WriteAccessUnit(pid int, au AccessUnit) error {
+	s := m.stream(pid)
+	dts := au.Dts
+	if dts == 0 {
+		dts = au.Pts
+	}
+	pes, err := m.pesHeader(s, au)
+	if err != nil {
+		return err
+	}
+	pes = append(pes, au.Data...)
+
+	af := &muxAdaptField{pcr: -1, rai: au.RandomAccess}
+	if au.PrivateData != nil {
+		af.priv = EncodeAdaptFieldPrivData(au.PrivateData)
+		if af.length() > maxAdaptFieldLength {
+			return fmt.Errorf("mux: %d bytes of adaptation field private data, at most %d",
+				len(af.priv), maxAdaptFieldLength-af.length()+len(af.priv))
+		}
+	}
+	m.wait(dts*300 - m.Delay)
+	m.writePayload(pid, pes, af)
+	return nil
+}
+
+// WriteSection writes a complete section, e.g. a SCTE-35 splice_info_section,
+// on pid at the current position of the stream.
+func (m *Muxer) WriteSection(pid int, section []byte) {
+	m.stream(pid)
+	m.wait(m.clock(m.bytes))
+	m.writeSection(pid, section)
+}
+
+func (m *Muxer) writeSection(pid int, section []byte) {
+	cc := m.cc[pid]
+	for _, buf := range PacketizeSection(pid, section, &cc) {
+		m.writeDue(nil)
+		m.write(buf)
+	}
+	m.cc[pid] = cc
+}
+
+// clock returns the time in 27 MHz of a byte position.
+func (m *Muxer) clock(pos int64) int64 {
+	return m.start + pos*8*27000000/m.MuxRate
+}
+
+// pcrByte is the offset in a packet of the byte carrying the last bit of
+// program_clock_reference_base, which the PCR is the arrival time of.
+const pcrByte = 10
+
+func (m *Muxer) pcrDue() bool {
+	return m.pcr < 0 || m.clock(m.bytes+pcrByte)-m.pcr >= m.PcrInterval
+}
+
+// wait fills the stream with null packets, and the tables and PCR packets
+// that become due, until the clock reaches now.
+func (m *Muxer) wait(now int64) {
+	if !m.started {
+		m.started = true
+		m.start = now
+	}
+	for m.clock(m.bytes) < now {
+		if !m.writeDue(nil) {
+			m.write(NullPacket())
+		}
+	}
+}
+
+// writeDue writes the tables and the PCR packet that are due before the
+// next packet, and reports whether it wrote any. A due PCR is carried by
+// af instead, the adaptation field of the next packet, if it fits.
+func (m *Muxer) writeDue(af *muxAdaptField) bool {
+	wrote := false
+	if m.psi < 0 || m.clock(m.bytes)-m.psi >= m.PsiInterval {
+		m.psi = m.clock(m.bytes)
+		m.writePsi()
+		wrote = true
+	}
+	if m.pcrDue() {
+		if af != nil && af.length()+6 <= maxAdaptFieldLength {
+			af.pcr = 0
+		} else {
+			m.writePcr()
+			wrote = true
+		}
+	}
+	return wrote
+}
+
+func (m *Muxer) write(buf []byte) {
+	m.Out.Write(buf)
+	m.bytes += int64(len(buf))
+}
+
+func (m *Muxer) writePsi() {
+	pat := &Pat{
+		transport_stream_id: m.TransportStreamId,
+		programs:            make(map[int]Program),
+	}
+	pat.programs[m.PmtPid] = Program{Number: m.ProgramNumber, PmtPid: m.PmtPid}
+	cc := m.cc[0]
+	for _, buf := range PacketizeSection(0, pat.Section(), &cc) {
+		m.write(buf)
+	}
+	m.cc[0] = cc
+
+	pmt := &Pmt{
+		program_number: m.ProgramNumber,
+		pcr_pid:        m.PcrPid,
+		streams:        make(map[int]Stream),
+	}
+	for _, pid := range m.order {
+		pmt.streams[pid] = m.streams[pid].Stream
+	}
+	cc = m.cc[m.PmtPid]
+	for _, buf := range PacketizeSection(m.PmtPid, pmt.Section(), &cc) {
+		m.write(buf)
+	}
+	m.cc[m.PmtPid] = cc
+}
+
+func (m *Muxer) writePcr() {
+	buf, _ := m.packet(m.PcrPid, false, &muxAdaptField{pcr: 0}, nil)
+	m.write(buf)
+}
+
+func (m *Muxer) pesHeader(s *muxStream, au AccessUnit) ([]byte, error) {
+	hasDts := au.Dts != 0 && au.Dts != au.Pts
+	hlen := 5
+	if hasDts {
+		hlen = 10
+	}
+	length := 3 + hlen + len(au.Data)
+	if length > 0xFFFF {
+		if !IsVideoStreamId(s.streamId) {
+			return nil, fmt.Errorf("mux: PES packet of %d bytes on PID %d", length, s.Pid)
+		}
+		length = 0
+	}
+
+	w := NewWriter()
+	w.WriteBit(0x000001, 24)
+	w.WriteBit(s.streamId, 8)
+	w.WriteBit(length, 16)
+	w.WriteBit(2, 2)
+	w.WriteBit(0, 2) // PES_scrambling_control
+	w.WriteBit(0, 1) // PES_priority
+	w.WriteBit(1, 1) // data_alignment_indicator
+	w.WriteBit(0, 2) // copyright, original_or_copy
+	if hasDts {
+		w.WriteBit(3, 2)
+	} else {
+		w.WriteBit(2, 2)
+	}
+	w.WriteBit(0, 6)
+	w.WriteBit(hlen, 8)
+	if hasDts {
+		writePts(w, 3, au.Pts)
+		writePts(w, 1, au.Dts)
+	} else {
+		writePts(w, 2, au.Pts)
+	}
+	return w.Bytes(), nil
+}
+
+func writePts(w *Writer, prefix int, pts int64) {
+	w.WriteBit(prefix, 4)
+	w.WriteBit64(pts>>30&0x07, 3)
+	w.WriteBit(1, 1)
+	w.WriteBit64(pts>>15&0x7FFF, 15)
+	w.WriteBit(1, 1)
+	w.WriteBit64(pts&0x7FFF, 15)
+	w.WriteBit(1, 1)
+}
+
+// maxAdaptFieldLength leaves a byte of payload in a packet.
+const maxAdaptFieldLength = TSPacketSize - 4 - 1 - 1
+
+type muxAdaptField struct {
+	pcr  int64
+	rai  bool
+	priv []byte
+}
+
+// length returns the adaptation_field_length needed for the fields.
+func (af *muxAdaptField) length() int {
+	n := 1
+	if af.pcr >= 0 {
+		n += 6
+	}
+	if af.priv != nil {
+		n += 1 + len(af.priv)
+	}
+	return n
+}
+
+func (af *muxAdaptField) empty() bool {
+	return af.pcr < 0 && !af.rai && af.priv == nil
+}
+
+// writePayload splits data into packets, carrying af in the first one and
+// stuffing the last one. The first packet of the PCR PID carries the PCR
+// when one is due.
+func (m *Muxer) writePayload(pid int, data []byte, af *muxAdaptField) {
+	pusi := true
+	for len(data) > 0 {
+		if pid == m.PcrPid {
+			m.writeDue(af)
+		} else {
+			m.writeDue(nil)
+		}
+		buf, n := m.packet(pid, pusi, af, data)
+		m.write(buf)
+		data = data[n:]
+		af, pusi = nil, false
+	}
+}
+
+// packet builds one packet of pid with as much of data as fits, and
+// returns it with the number of bytes of data used.
+func (m *Muxer) packet(pid int, pusi bool, af *muxAdaptField, data []byte) ([]byte, int) {
+	if af != nil && af.empty() {
+		af = nil
+	}
+	if af != nil && af.pcr >= 0 {
+		af.pcr = m.clock(m.bytes + pcrByte)
+		m.pcr = af.pcr
+	}
+	room := TSPacketSize - 4
+	if af != nil {
+		room -= 1 + af.length()
+	}
+	if len(data) > room {
+		data = data[:room]
+	}
+	stuffing := room - len(data)
+	if af == nil && stuffing > 0 {
+		af = &muxAdaptField{pcr: -1}
+		// The adaptation_field_length byte alone takes one byte
+		stuffing -= 2
+	}
+
+	afctrl := 1
+	if af != nil {
+		afctrl = 3
+		if len(data) == 0 {
+			afctrl = 2
+		}
+	}
+	if afctrl != 2 {
+		m.cc[pid] = (m.cc[pid] + 1) & 0x0F
+	}
+
+	w := &Writer{Data: make([]byte, 0, TSPacketSize)}
+	w.WriteBit(0x47, 8)
+	w.WriteBit(0, 1)
+	w.WriteBit(b2i(pusi), 1)
+	w.WriteBit(0, 1)
+	w.WriteBit(pid, 13)
+	w.WriteBit(0, 2)
+	w.WriteBit(afctrl, 2)
+	w.WriteBit(m.cc[pid], 4)
+	if af != nil {
+		if stuffing < 0 {
+			// Only room for the adaptation_field_length byte
+			w.WriteBit(0, 8)
+		} else {
+			w.WriteBit(af.length()+stuffing, 8)
+			w.WriteBit(0, 1) // discontinuity_indicator
+			w.WriteBit(b2i(af.rai), 1)
+			w.WriteBit(0, 1) // elementary_stream_priority_indicator
+			w.WriteBit(b2i(af.pcr >= 0), 1)
+			w.WriteBit(0, 2) // OPCR_flag, splicing_point_flag
+			w.WriteBit(b2i(af.priv != nil), 1)
+			w.WriteBit(0, 1) // adaptation_field_extension_flag
+			if af.pcr >= 0 {
+				w.WriteBit64(af.pcr/300, 33)
+				w.WriteBit(0x3F, 6)
+				w.WriteBit64(af.pcr%300, 9)
+			}
+			if af.priv != nil {
+				w.WriteBit(len(af.priv), 8)
+				w.WriteBytes(af.priv)
+			}
+			for i := 0; i < stuffing; i++ {
+				w.WriteBit(0xFF, 8)
+			}
+		}
+	}
+	w.WriteBytes(data)
+	return w.Bytes(), len(data)
+}
+
+func b2i(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
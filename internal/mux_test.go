@@ -0,0 +1,259 @@
+package mpts
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// TestMuxRoundTrip muxes a program and reads it back with the parsers: PAT,
+// PMT, PES timestamps and payloads, adaptation field private data, SCTE-35
+// sections and PCR.
+func TestMuxRoundTrip(t *testing.T) {
+	const (
+		pmtPid   = 0x100
+		videoPid = 0x101
+		audioPid = 0x102
+		sctePid  = 0x103
+		rate     = 1000000
+	)
+	var out bytes.Buffer
+	opt := MuxOptions{TransportStreamId: 7, ProgramNumber: 3, PmtPid: pmtPid, MuxRate: rate}
+	m := NewMuxer(&out, opt)
+	reg := NewDescriptor(0x05, []byte("HDMV"))
+	m.AddStream(videoPid, 0x1B, reg)
+	m.AddStream(audioPid, 0x0F)
+	m.AddStream(sctePid, 0x86)
+
+	// Takes two packets
+	cue := NewSpliceInfoSection()
+	cue.SetCommand(NewSpliceInsert(1, true, 1000000, 30*90000))
+	for i := 0; i < 20; i++ {
+		cue.AddAvailDescriptor(int64(i))
+	}
+	cueData, err := cue.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cueData = append([]byte{}, cueData...)
+
+	priv := []AdaptFieldPrivData{
+		{FieldTag: 0x02, AuInfo: &AuInfo{
+			CodingFormat: 2, CodingType: 1, PtsPresent: true, Pts: 0x12345678,
+			ProfileInfoPresent: true, AuProfile: 100, AuLevel: 40,
+		}},
+		{FieldTag: 0xA0, DirecTvTimeCode: &DirecTvTimeCode{Hours: 1, Minutes: 2, Seconds: 3, Pictures: 4}},
+	}
+	type unit struct {
+		pid int
+		au  AccessUnit
+	}
+	var units []unit
+	for i := 0; i < 25; i++ {
+		pts := int64(900000 + i*3003)
+		video := AccessUnit{
+			Data: bytes.Repeat([]byte{byte(i)}, 100+i*40),
+			Pts:  pts + 6006,
+			Dts:  pts,
+		}
+		if i%10 == 0 {
+			video.RandomAccess = true
+			video.PrivateData = priv
+		}
+		units = append(units, unit{videoPid, video})
+		audio := AccessUnit{Data: bytes.Repeat([]byte{0xA0 | byte(i&0x0F)}, 300), Pts: pts + 1000}
+		units = append(units, unit{audioPid, audio})
+	}
+	for k, u := range units {
+		if k == 20 {
+			m.WriteSection(sctePid, cueData)
+		}
+		if err := m.WriteAccessUnit(u.pid, u.au); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if out.Len()%TSPacketSize != 0 {
+		t.Fatalf("output of %d bytes is not whole packets", out.Len())
+	}
+
+	psi := NewPsiParser()
+	cc := map[int]*ContinuityChecker{}
+	pes := map[int][]byte{}
+	got := map[int][]AccessUnit{}
+	flush := func(pid int) {
+		data := pes[pid]
+		if data == nil {
+			return
+		}
+		p := &PesPkt{}
+		n := p.Read(data)
+		if p.Length != 0 {
+			data = data[:6+p.Length]
+		}
+		got[pid] = append(got[pid], AccessUnit{Data: data[n:], Pts: p.Pts, Dts: p.Dts})
+		pes[pid] = nil
+	}
+	var privs [][]AdaptFieldPrivData
+	var cues [][]byte
+	var scte []byte
+	lastPcr, firstPcr, firstPcrPos := int64(-1), int64(-1), 0
+	// One packet takes 188*8 bits at rate
+	packetTime := int64(TSPacketSize * 8 * 27000000 / rate)
+	for pos := 0; pos < out.Len(); pos += TSPacketSize {
+		pkt := ParseTsPkt(out.Bytes()[pos : pos+TSPacketSize])
+		if pkt.SyncByte != 0x47 {
+			t.Fatalf("packet %d: sync byte %#x", pos/TSPacketSize, pkt.SyncByte)
+		}
+		if pkt.Pid == NullPid {
+			continue
+		}
+		if cc[pkt.Pid] == nil {
+			cc[pkt.Pid] = &ContinuityChecker{}
+		}
+		if ok, duplicate := cc[pkt.Pid].CheckCC(pkt); !ok || duplicate {
+			t.Errorf("packet %d: continuity_counter %d on PID %d", pos/TSPacketSize, pkt.CC, pkt.Pid)
+		}
+		if pcr, ok := pkt.PCR(); ok {
+			if pkt.Pid != videoPid {
+				t.Errorf("PCR on PID %d", pkt.Pid)
+			}
+			if lastPcr >= 0 && (pcr < lastPcr || pcr-lastPcr > 40*27000+packetTime) {
+				t.Errorf("PCR %d after %d", pcr, lastPcr)
+			}
+			// The PCR follows the byte position at the mux rate
+			if firstPcr < 0 {
+				firstPcr, firstPcrPos = pcr, pos
+			}
+			want := firstPcr + int64(pos-firstPcrPos)*8*27000000/rate
+			if pcr < want-1 || pcr > want+1 {
+				t.Errorf("PCR %d at byte %d, want %d", pcr, pos, want)
+			}
+			lastPcr = pcr
+		}
+		if pkt.Pid == sctePid {
+			if pkt.PUSI == 1 {
+				scte = nil
+			}
+			if psi.BufferData(pkt, &scte) {
+				cues = append(cues, scte)
+				scte = nil
+			}
+		}
+		if pkt.AdaptField != nil && pkt.AdaptField.PrivateData != nil {
+			privs = append(privs, ParseAdaptFieldPrivData(pkt.AdaptField.PrivateData))
+		}
+		psi.Parse(pkt)
+		if pkt.Pid != videoPid && pkt.Pid != audioPid || !pkt.HasPayload() {
+			continue
+		}
+		if pkt.PUSI == 1 {
+			if pkt.Pid == videoPid && lastPcr >= 0 {
+				// The PCR leads the DTS by the mux delay, within a PCR
+				// interval; the tables and a PCR packet may come first
+				p := &PesPkt{}
+				p.Read(pkt.Data)
+				lead := p.Dts*300 - lastPcr
+				if lead < 700*27000-4*packetTime || lead > 740*27000+packetTime {
+					t.Errorf("DTS %d leads PCR %d by %d", p.Dts, lastPcr, lead)
+				}
+			}
+			flush(pkt.Pid)
+			pes[pkt.Pid] = []byte{}
+		}
+		if pes[pkt.Pid] != nil {
+			pes[pkt.Pid] = append(pes[pkt.Pid], pkt.Data...)
+		}
+	}
+	flush(videoPid)
+	flush(audioPid)
+
+	if psi.Pat == nil || psi.Pat.transport_stream_id != 7 {
+		t.Fatalf("PAT %+v", psi.Pat)
+	}
+	if program := psi.Programs()[pmtPid]; program.Number != 3 || len(psi.Programs()) != 1 {
+		t.Errorf("programs %+v", psi.Programs())
+	}
+	pmt := psi.Pmts[pmtPid]
+	if pmt == nil {
+		t.Fatal("no PMT")
+	}
+	if pmt.program_number != 3 || pmt.pcr_pid != videoPid {
+		t.Errorf("PMT program_number %d, PCR_PID %d", pmt.program_number, pmt.pcr_pid)
+	}
+	if s := pmt.streams[videoPid]; s.StreamType != "MPEG-4 AVC Video" || len(s.Descriptors) != 1 ||
+		s.Descriptors[0].Tag != 0x05 || !bytes.Equal(s.Descriptors[0].data, []byte("HDMV")) {
+		t.Errorf("video stream %+v", s)
+	}
+	if s := pmt.streams[audioPid]; s.StreamType != "MPEG-2 AAC Audio (ADTS)" || len(s.Descriptors) != 0 {
+		t.Errorf("audio stream %+v", s)
+	}
+
+	i := map[int]int{}
+	for _, u := range units {
+		k := i[u.pid]
+		i[u.pid]++
+		if k >= len(got[u.pid]) {
+			t.Fatalf("PID %d: %d PES packets", u.pid, len(got[u.pid]))
+		}
+		g := got[u.pid][k]
+		wantDts := u.au.Dts
+		if wantDts == u.au.Pts {
+			wantDts = 0
+		}
+		if g.Pts != u.au.Pts || g.Dts != wantDts {
+			t.Errorf("PID %d PES %d: PTS %d DTS %d, want %d %d", u.pid, k, g.Pts, g.Dts, u.au.Pts, wantDts)
+		}
+		if !bytes.Equal(g.Data, u.au.Data) {
+			t.Errorf("PID %d PES %d: payload of %d bytes, want %d", u.pid, k, len(g.Data), len(u.au.Data))
+		}
+	}
+	for pid, n := range i {
+		if len(got[pid]) != n {
+			t.Errorf("PID %d: %d PES packets, want %d", pid, len(got[pid]), n)
+		}
+	}
+
+	if len(cues) != 1 {
+		t.Fatalf("%d SCTE-35 sections, want 1", len(cues))
+	}
+	if got, err := ParseSpliceInfoSection(cues[0]).Encode(); err != nil || !bytes.Equal(got, cueData) {
+		t.Errorf("SCTE-35 section\n%x\nwant\n%x %v", got, cueData, err)
+	}
+
+	if len(privs) != 3 {
+		t.Fatalf("%d packets with private data, want 3", len(privs))
+	}
+	for _, p := range privs {
+		if len(p) != len(priv) {
+			t.Fatalf("private data %+v", p)
+		}
+		for k := range priv {
+			if p[k].FieldTag != priv[k].FieldTag ||
+				!reflect.DeepEqual(p[k].AuInfo, priv[k].AuInfo) ||
+				!reflect.DeepEqual(p[k].DirecTvTimeCode, priv[k].DirecTvTimeCode) {
+				t.Errorf("private data %d: %+v, want %+v", k, p[k], priv[k])
+			}
+		}
+	}
+}
+
+// TestMuxPrivateDataTooLong checks that private data not leaving room for
+// payload in the first packet is refused.
+func TestMuxPrivateDataTooLong(t *testing.T) {
+	var out bytes.Buffer
+	m := NewMuxer(&out, MuxOptions{})
+	m.AddStream(0x101, 0x1B)
+	info := AdaptFieldPrivData{FieldTag: 0x02, AuInfo: &AuInfo{PtsPresent: true, ProfileInfoPresent: true}}
+	var priv []AdaptFieldPrivData
+	for len(EncodeAdaptFieldPrivData(priv)) <= maxAdaptFieldLength-2 {
+		priv = append(priv, info)
+	}
+	au := AccessUnit{Data: []byte{0, 0, 1, 9}, Pts: 90000, PrivateData: priv}
+	if err := m.WriteAccessUnit(0x101, au); err == nil {
+		t.Errorf("%d bytes of private data accepted", len(EncodeAdaptFieldPrivData(priv)))
+	}
+	au.PrivateData = priv[:len(priv)-1]
+	if err := m.WriteAccessUnit(0x101, au); err != nil {
+		t.Error(err)
+	}
+}
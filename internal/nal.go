@@ -0,0 +1,139 @@
+package mpts
+
+// NalUnit is a NAL unit of an Annex B byte stream, header included.
+type NalUnit struct {
+	Data   []byte // as found in the stream
+	Rbsp   []byte // with the emulation_prevention_three_bytes removed
+	Offset int64  // of the start code in the stream
+}
+
+func newNalUnit(data []byte, offset int64) NalUnit {
+	return NalUnit{Data: data, Rbsp: Rbsp(data), Offset: offset}
+}
+
+// NalSplitter splits an Annex B byte stream fed in chunks, such as the
+// payloads of consecutive PES packets, into NAL units. Both 3 and 4 byte
+// start codes are accepted, and a start code may span two chunks. A NAL unit
+// is only complete once the next start code is seen, so the last one of a
+// chunk is kept until the next Write or Flush.
+type NalSplitter struct {
+	buf     []byte
+	started bool
+	zeros   int
+	written int64 // bytes written so far
+	offset  int64 // of the start code of the NAL unit in progress
+}
+
+// Write returns the NAL units completed by data. Bytes before the first
+// start code of the stream are dropped.
+func (s *NalSplitter) Write(data []byte) []NalUnit {
+	var nals []NalUnit
+	start := 0
+	for i, b := range data {
+		if b == 1 && s.zeros >= 2 {
+			zeros := s.zeros
+			if zeros > 3 {
+				zeros = 3
+			}
+			offset := s.written + int64(i-zeros)
+			if s.started {
+				nal := data[start:i]
+				if len(s.buf) > 0 {
+					nal = append(s.buf, nal...)
+				}
+				// Drop the zero bytes of the start code, and any
+				// trailing_zero_8bits before it
+				if nal = trimTrailingZeros(nal); len(nal) > 0 {
+					nals = append(nals, newNalUnit(nal, s.offset))
+				}
+			}
+			s.buf = nil
+			s.started = true
+			s.offset = offset
+			start = i + 1
+		}
+		if b == 0 {
+			s.zeros++
+		} else {
+			s.zeros = 0
+		}
+	}
+	if s.started {
+		s.buf = append(s.buf, data[start:]...)
+	}
+	s.written += int64(len(data))
+	return nals
+}
+
+// Flush returns the NAL unit in progress, if any. The next one starts at
+// the next start code; offsets keep counting from the start of the stream.
+func (s *NalSplitter) Flush() []NalUnit {
+	var nals []NalUnit
+	if nal := trimTrailingZeros(s.buf); s.started && len(nal) > 0 {
+		nals = append(nals, newNalUnit(nal, s.offset))
+	}
+	s.buf = nil
+	s.started = false
+	s.zeros = 0
+	return nals
+}
+
+// SplitNalUnits returns the NAL units of an Annex B byte stream, header
+// included and start codes and trailing zero bytes removed.
+func SplitNalUnits(data []byte) [][]byte {
+	var nals [][]byte
+	s := &NalSplitter{}
+	for _, nal := range append(s.Write(data), s.Flush()...) {
+		nals = append(nals, nal.Data)
+	}
+	return nals
+}
+
+// HasStartCode reports whether data begins with a 3 or 4 byte start code.
+func HasStartCode(data []byte) bool {
+	if len(data) >= 4 && data[0] == 0 && data[1] == 0 && data[2] == 0 {
+		data = data[1:]
+	}
+	return len(data) >= 3 && data[0] == 0 && data[1] == 0 && data[2] == 1
+}
+
+// pesPayloadStartsNal reports whether the PES packet starting in the TS
+// payload data begins with a start code, which ends the NAL unit of the
+// previous PES packet. When the PES header is not complete in data, the
+// payload is assumed to be aligned.
+func pesPayloadStartsNal(data []byte) bool {
+	if len(data) < 9 || !HasStartCode(data) {
+		return true
+	}
+	hlen := 9 + int(data[8])
+	if hlen+4 > len(data) {
+		return true
+	}
+	return HasStartCode(data[hlen:])
+}
+
+func trimTrailingZeros(nal []byte) []byte {
+	for len(nal) > 0 && nal[len(nal)-1] == 0 {
+		nal = nal[:len(nal)-1]
+	}
+	return nal
+}
+
+// Rbsp removes the emulation_prevention_three_bytes of a NAL unit.
+func Rbsp(nal []byte) []byte {
+	rbsp := make([]byte, 0, len(nal))
+	zeros := 0
+	for _, b := range nal {
+		if zeros >= 2 && b == 3 {
+			zeros = 0
+			continue
+		}
+		rbsp = append(rbsp, b)
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+	}
+	return rbsp
+}
@@ -0,0 +1,57 @@
+package mpts
+
+const NullPid = 0x1FFF
+
+// NullPacket returns a null packet.
+func NullPacket() []byte {
+	buf := make([]byte, TSPacketSize)
+	buf[0] = 0x47
+	buf[1] = NullPid >> 8
+	buf[2] = NullPid & 0xFF
+	buf[3] = 0x10
+	for i := 4; i < TSPacketSize; i++ {
+		buf[i] = 0xFF
+	}
+	return buf
+}
+
+// SetPid rewrites the PID of a raw packet.
+func SetPid(buf []byte, pid int) {
+	buf[1] = buf[1]&0xE0 | byte(pid>>8)&0x1F
+	buf[2] = byte(pid)
+}
+
+// SetCC rewrites the continuity_counter of a raw packet.
+func SetCC(buf []byte, cc int) {
+	buf[3] = buf[3]&0xF0 | byte(cc)&0x0F
+}
+
+// PacketizeSection splits a section into packets, starting with a zero
+// pointer_field and padding the last packet with 0xFF. cc holds the
+// continuity counter of the previous packet and is updated.
+func PacketizeSection(pid int, section []byte, cc *int) [][]byte {
+	var pkts [][]byte
+	data := append([]byte{0}, section...)
+	pusi := 1
+	for len(data) > 0 {
+		*cc = (*cc + 1) & 0x0F
+		buf := make([]byte, TSPacketSize)
+		w := &Writer{Data: buf[:0]}
+		w.WriteBit(0x47, 8)
+		w.WriteBit(0, 1)
+		w.WriteBit(pusi, 1)
+		w.WriteBit(0, 1)
+		w.WriteBit(pid, 13)
+		w.WriteBit(0, 2)
+		w.WriteBit(1, 2)
+		w.WriteBit(*cc, 4)
+		n := copy(buf[4:], data)
+		for i := 4 + n; i < TSPacketSize; i++ {
+			buf[i] = 0xFF
+		}
+		data = data[n:]
+		pkts = append(pkts, buf)
+		pusi = 0
+	}
+	return pkts
+}
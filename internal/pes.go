@@ -16,9 +16,15 @@ type PesRecord struct {
 }
 
 func (s *PesRecord) Process(pkt *TsPkt) {
+	ccOk, duplicate := s.CheckCC(pkt)
+	if duplicate {
+		return
+	}
 	s.LogAdaptFieldPrivData(pkt)
 	if pkt.PUSI == 1 {
 		if s.curpkt != nil {
+			s.curpkt.CCError = s.curpkt.CCError || !ccOk
+			s.CheckPes(s.curpkt)
 			s.Pkts = append(s.Pkts, s.curpkt)
 		}
 		s.curpkt = &PesPkt{}
@@ -33,7 +39,11 @@ func (s *PesRecord) Process(pkt *TsPkt) {
 			} else {
 				pkt.Data = pkt.Data[hlen:]
 			}
+		} else {
+			s.curpkt.StartCodeError = true
 		}
+	} else if s.curpkt != nil && !ccOk {
+		s.curpkt.CCError = true
 	}
 	if s.curpkt != nil {
 		s.curpkt.Size += int64(len(pkt.Data))
@@ -42,6 +52,7 @@ func (s *PesRecord) Process(pkt *TsPkt) {
 
 func (s *PesRecord) Flush() {
 	if s.curpkt != nil {
+		s.CheckPes(s.curpkt)
 		s.Pkts = append(s.Pkts, s.curpkt)
 	}
 }
@@ -53,6 +64,7 @@ func (s *PesRecord) Report(root string) {
 		panic(err)
 	}
 	defer w.Close()
+	s.ReportPesErrors(root)
 
 	header := "Pos, Size, PCR, PcrPos, PTS, DTS, (DTS-PCR)"
 	fmt.Fprintln(w, header)
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 )
 
@@ -37,6 +38,14 @@ func GetStreamType(s Stream) string {
 	}
 }
 
+func IsVideoStreamType(t string) bool {
+	switch t {
+	case "MPEG-1 Video", "MPEG-2 Video", "MPEG-4 Video", "MPEG-4 AVC Video", "HEVC Video":
+		return true
+	}
+	return false
+}
+
 var DescriptorTagString map[int]string = map[int]string{
 	// ISO/IEC 13818-1
 	0:  "reserved",
@@ -157,6 +166,7 @@ func ParsePmt(data []byte) *Pmt {
 	pmt.pcr_pid = r.ReadBit(13)
 	r.SkipBit(4)
 	pmt.program_info_length = r.ReadBit(12)
+	pmt.program_info = r.Data[r.Base : r.Base+pmt.program_info_length]
 	r.SkipByte(pmt.program_info_length)
 	section_length := pmt.section_length
 	section_length -= 9 + pmt.program_info_length + 4
@@ -251,10 +261,91 @@ type Pmt struct {
 	last_section_number      int
 	pcr_pid                  int
 	program_info_length      int
+	program_info             []byte
 	crc                      int
 	streams                  map[int]Stream
 }
 
+// Section returns the PAT section with its CRC_32, programs sorted by
+// program_number.
+func (pat *Pat) Section() []byte {
+	var programs []Program
+	for _, program := range pat.programs {
+		programs = append(programs, program)
+	}
+	sort.Slice(programs, func(i, j int) bool {
+		return programs[i].Number < programs[j].Number
+	})
+
+	w := NewWriter()
+	w.WriteBit(0x00, 8) // table_id
+	w.WriteBit(1, 1)
+	w.WriteBit(0, 1)
+	w.WriteBit(3, 2)
+	w.WriteBit(5+4*len(programs)+4, 12)
+	w.WriteBit(pat.transport_stream_id, 16)
+	w.WriteBit(3, 2)
+	w.WriteBit(pat.version_number, 5)
+	w.WriteBit(1, 1)
+	w.WriteBit(0, 8)
+	w.WriteBit(0, 8)
+	for _, program := range programs {
+		w.WriteBit(program.Number, 16)
+		w.WriteBit(7, 3)
+		w.WriteBit(program.PmtPid, 13)
+	}
+	w.WriteBit64(int64(Crc32(w.Bytes())), 32)
+	return w.Bytes()
+}
+
+// Section returns the PMT section with its CRC_32, streams sorted by PID.
+func (pmt *Pmt) Section() []byte {
+	var streams []Stream
+	for _, stream := range pmt.streams {
+		streams = append(streams, stream)
+	}
+	sort.Slice(streams, func(i, j int) bool {
+		return streams[i].Pid < streams[j].Pid
+	})
+
+	es := NewWriter()
+	for _, stream := range streams {
+		info := NewWriter()
+		for _, d := range stream.Descriptors {
+			info.WriteBit(d.Tag, 8)
+			info.WriteBit(len(d.data), 8)
+			info.WriteBytes(d.data)
+		}
+		es.WriteBit(stream.StreamTypeId, 8)
+		es.WriteBit(7, 3)
+		es.WriteBit(stream.Pid, 13)
+		es.WriteBit(0xF, 4)
+		es.WriteBit(info.Len(), 12)
+		es.WriteBytes(info.Bytes())
+	}
+
+	w := NewWriter()
+	w.WriteBit(0x02, 8) // table_id
+	w.WriteBit(1, 1)
+	w.WriteBit(0, 1)
+	w.WriteBit(3, 2)
+	w.WriteBit(9+len(pmt.program_info)+es.Len()+4, 12)
+	w.WriteBit(pmt.program_number, 16)
+	w.WriteBit(3, 2)
+	w.WriteBit(pmt.version_number, 5)
+	w.WriteBit(1, 1)
+	w.WriteBit(0, 8)
+	w.WriteBit(0, 8)
+	w.WriteBit(7, 3)
+	w.WriteBit(pmt.pcr_pid, 13)
+	w.WriteBit(0xF, 4)
+	w.WriteBit(len(pmt.program_info), 12)
+	w.WriteBytes(pmt.program_info)
+	w.WriteBytes(es.Bytes())
+	w.WriteBit64(int64(Crc32(w.Bytes())), 32)
+	return w.Bytes()
+}
+
 type Descriptor struct {
 	Tag     int
 	TagName string
@@ -338,6 +429,14 @@ func (p *PsiParser) GetStreams() map[int]Stream {
 	return p.Strs
 }
 
+// Programs returns the programs of the PAT, keyed by PMT PID.
+func (p *PsiParser) Programs() map[int]Program {
+	if p.Pat == nil {
+		return map[int]Program{}
+	}
+	return p.Pat.programs
+}
+
 func (p *PsiParser) GetPcrs() map[int][]int {
 	return p.Pcrs
 }
@@ -354,6 +453,10 @@ func (p *PsiParser) BufferData(pkt *TsPkt, buf *[]byte) bool {
 			*buf = append(*buf, pkt.Data...)
 		}
 	}
+	// Nothing buffered yet, or no payload (adaptation field only)
+	if len(*buf) < 4 {
+		return false
+	}
 
 	// complete?
 	r := &Reader{Data: *buf}
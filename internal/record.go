@@ -27,7 +27,12 @@ type BaseRecord struct {
 	PcrTime               int64
 	PcrPos                int64
 	IFrameLog             *os.File
+	IFrames               []IFrameInfo
 	AdaptFieldPrivDataLog *os.File
+	SeiLog                *os.File
+	PesErrorLog           *os.File
+	PesErrorCount         map[string]int
+	ContinuityChecker
 }
 
 func (b *BaseRecord) NotifyTime(pcr int64, pos int64) {
@@ -36,6 +41,7 @@ func (b *BaseRecord) NotifyTime(pcr int64, pos int64) {
 }
 
 func (r *BaseRecord) LogIFrame(i IFrameInfo) {
+	r.IFrames = append(r.IFrames, i)
 	if r.IFrameLog == nil {
 		var pid string = strconv.Itoa(r.Pid)
 		var err error
@@ -44,17 +50,37 @@ func (r *BaseRecord) LogIFrame(i IFrameInfo) {
 		if err != nil {
 			panic(err)
 		}
-		header := "Pos, PTS, Key"
+		header := "Pos, PTS, Key, Type"
 		fmt.Fprintln(r.IFrameLog, header)
 	}
 	cols := []string{
 		strconv.FormatInt(i.Pos, 10),
 		strconv.FormatInt(i.Pts, 10),
 		strconv.FormatBool(i.Key),
+		i.Type,
 	}
 	fmt.Fprintln(r.IFrameLog, strings.Join(cols, ", "))
 }
 
+// GetIFrames returns the I-frames logged so far, in decoding order.
+func (r *BaseRecord) GetIFrames() []IFrameInfo {
+	return r.IFrames
+}
+
+// LogSei writes a decoded SEI message as one JSON line to <pid>-sei.jsonl.
+func (r *BaseRecord) LogSei(i SeiInfo) {
+	if r.SeiLog == nil {
+		fname := filepath.Join(r.Root, strconv.Itoa(r.Pid)+"-sei.jsonl")
+		var err error
+		r.SeiLog, err = os.Create(fname)
+		if err != nil {
+			panic(err)
+		}
+	}
+	c, _ := json.Marshal(i)
+	fmt.Fprintln(r.SeiLog, string(c))
+}
+
 func (logger *BaseRecord) LogAdaptFieldPrivData(pkt *TsPkt) {
 	if pkt.AdaptField == nil || pkt.AdaptField.PrivateData == nil {
 		return
@@ -76,6 +102,95 @@ func (logger *BaseRecord) LogAdaptFieldPrivData(pkt *TsPkt) {
 	}
 }
 
+// ContinuityChecker follows the continuity_counter of a PID.
+type ContinuityChecker struct {
+	lastCC  int
+	ccValid bool
+}
+
+// CheckCC returns false if the continuity counter of pkt does not follow the
+// previous payload packet of the PID. Packets with discontinuity_indicator
+// set are accepted. A duplicate packet, repeating the counter of the
+// previous one, is accepted too and reported as such; its payload must not
+// be used again.
+func (b *ContinuityChecker) CheckCC(pkt *TsPkt) (ok bool, duplicate bool) {
+	if !pkt.HasPayload() {
+		return true, false
+	}
+	ok = true
+	discontinuity := pkt.AdaptField != nil && pkt.AdaptField.Discontinuity
+	if b.ccValid && !discontinuity {
+		duplicate = pkt.CC == b.lastCC
+		ok = pkt.CC == (b.lastCC+1)&0x0F || duplicate
+	}
+	b.lastCC = pkt.CC
+	b.ccValid = true
+	return ok, duplicate
+}
+
+// CheckPes compares the declared PES_packet_length with the bytes collected
+// for p and logs every problem found.
+func (b *BaseRecord) CheckPes(p *PesPkt) {
+	if p.StartCodeError {
+		b.LogPesError(p, "StartCode", 0, 0)
+	} else {
+		declared := int64(p.Length)
+		// PES_packet_length counts the bytes following the field
+		actual := p.Size + int64(p.HeaderLen) - 6
+		if declared == 0 {
+			if !IsVideoStreamId(p.StreamId) {
+				b.LogPesError(p, "Unbounded", declared, actual)
+			}
+		} else if actual < declared {
+			b.LogPesError(p, "Truncated", declared, actual)
+		} else if actual > declared {
+			b.LogPesError(p, "Overlong", declared, actual)
+		}
+	}
+	if p.CCError {
+		b.LogPesError(p, "CC", 0, 0)
+	}
+}
+
+func (b *BaseRecord) LogPesError(p *PesPkt, e string, declared, actual int64) {
+	if b.PesErrorLog == nil {
+		fname := filepath.Join(b.Root, strconv.Itoa(b.Pid)+"-pes-error.csv")
+		var err error
+		b.PesErrorLog, err = os.Create(fname)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Fprintln(b.PesErrorLog, "Pos, Error, Declared, Actual")
+		b.PesErrorCount = make(map[string]int)
+	}
+	b.PesErrorCount[e] += 1
+	cols := []string{
+		strconv.FormatInt(p.Pos, 10),
+		e,
+		strconv.FormatInt(declared, 10),
+		strconv.FormatInt(actual, 10),
+	}
+	fmt.Fprintln(b.PesErrorLog, strings.Join(cols, ", "))
+}
+
+// ReportPesErrors writes the per-PID error counts, if any error was seen.
+func (b *BaseRecord) ReportPesErrors(root string) {
+	if b.PesErrorCount == nil {
+		return
+	}
+	fname := filepath.Join(root, strconv.Itoa(b.Pid)+"-pes-error.json")
+	w, err := os.Create(fname)
+	if err != nil {
+		panic(err)
+	}
+	defer w.Close()
+	if b.PesErrorLog != nil {
+		b.PesErrorLog.Close()
+	}
+	c, _ := json.MarshalIndent(b.PesErrorCount, "", "  ")
+	fmt.Fprintln(w, string(c))
+}
+
 func CreateRecord(pid int, t string, root string) Record {
 	var record Record
 	switch t {
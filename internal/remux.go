@@ -0,0 +1,262 @@
+package mpts
+
+import (
+	"fmt"
+	"io"
+)
+
+// RemuxOptions selects the PIDs to write. The PCR PID of a selected program
+// is kept unless dropped explicitly.
+type RemuxOptions struct {
+	Program  int          // program_number to extract, 0 for all
+	Keep     map[int]bool // PIDs to keep, empty for all
+	Drop     map[int]bool // PIDs to drop
+	Remap    map[int]int  // input PID -> output PID
+	NullFill bool         // replace dropped packets with null packets
+}
+
+// Remuxer filters packets by PID or program, remaps PIDs and rewrites the
+// PAT and PMTs accordingly. With NullFill every input packet produces one
+// output packet, so the PCR timing of the kept PIDs is preserved. A
+// rewritten section taking more packets than the input one pushes the
+// following packets back; the next null or dropped packets make up for it.
+// A PMT is rewritten again when its version_number changes.
+type Remuxer struct {
+	RemuxOptions
+	Out         io.Writer
+	psi         *PsiParser
+	patSection  []byte
+	pmtSections map[int][]byte
+	pmts        map[int]*Pmt   // input PMTs of the selected programs
+	pmtData     map[int][]byte // PMT sections being received
+	pids        map[int]bool
+	known       map[int]bool
+	cc          map[int]int
+	used        map[int]int // output PID -> input PID
+	excess      int         // packets written ahead of the input
+}
+
+// NewRemuxer returns an error if two kept PIDs end up on the same output
+// PID.
+func NewRemuxer(psi *PsiParser, opt RemuxOptions, out io.Writer) (*Remuxer, error) {
+	m := &Remuxer{
+		RemuxOptions: opt,
+		Out:          out,
+		psi:          psi,
+		pmtSections:  make(map[int][]byte),
+		pmts:         make(map[int]*Pmt),
+		pmtData:      make(map[int][]byte),
+		pids:         make(map[int]bool),
+		known:        make(map[int]bool),
+		cc:           make(map[int]int),
+		used:         map[int]int{0: 0},
+	}
+
+	pat := &Pat{programs: make(map[int]Program)}
+	if psi.Pat != nil {
+		pat.transport_stream_id = psi.Pat.transport_stream_id
+		pat.version_number = psi.Pat.version_number
+	}
+	for pmtPid, _ := range psi.Programs() {
+		m.known[pmtPid] = true
+		if pmt := psi.Pmts[pmtPid]; pmt != nil {
+			for pid, _ := range pmt.streams {
+				m.known[pid] = true
+			}
+		}
+	}
+	for pmtPid, program := range psi.Programs() {
+		if opt.Program != 0 && program.Number != opt.Program {
+			continue
+		}
+		pmt := psi.Pmts[pmtPid]
+		if pmt == nil {
+			continue
+		}
+		if !m.rewritePmt(pmtPid, pmt) {
+			continue
+		}
+		program.PmtPid = m.remap(pmtPid)
+		pat.programs[program.PmtPid] = program
+	}
+	m.patSection = pat.Section()
+	for pid, _ := range m.pmtSections {
+		if err := m.use(pid); err != nil {
+			return nil, err
+		}
+	}
+	for pid, _ := range m.pids {
+		if err := m.use(pid); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// rewritePmt keeps the selected streams of pmt and sets the section to
+// write on its PID. It returns false when no stream is selected.
+func (m *Remuxer) rewritePmt(pmtPid int, pmt *Pmt) bool {
+	out := *pmt
+	out.streams = make(map[int]Stream)
+	for pid, stream := range pmt.streams {
+		if m.selected(pid) {
+			stream.Pid = m.remap(pid)
+			out.streams[stream.Pid] = stream
+		}
+	}
+	if len(out.streams) == 0 {
+		return false
+	}
+	for pid, _ := range pmt.streams {
+		if m.selected(pid) {
+			m.pids[pid] = true
+		}
+	}
+	if !m.Drop[pmt.pcr_pid] && pmt.pcr_pid != NullPid {
+		m.pids[pmt.pcr_pid] = true
+	}
+	out.pcr_pid = m.remap(pmt.pcr_pid)
+	m.pmts[pmtPid] = pmt
+	m.pmtSections[pmtPid] = out.Section()
+	return true
+}
+
+// updatePmt rewrites the PMT on pmtPid again when a new version of it is
+// received. The streams it no longer lists are dropped, and an error is
+// returned when a new one maps to the output PID of another.
+func (m *Remuxer) updatePmt(pmtPid int, pmt *Pmt) error {
+	old := m.pmts[pmtPid]
+	if old == nil || pmt.current_next_indicator == 0 || pmt.version_number == old.version_number {
+		return nil
+	}
+	delete(m.pids, old.pcr_pid)
+	for pid, _ := range old.streams {
+		delete(m.pids, pid)
+		if m.used[m.remap(pid)] == pid {
+			delete(m.used, m.remap(pid))
+		}
+	}
+	for pid, _ := range pmt.streams {
+		m.known[pid] = true
+	}
+	if !m.rewritePmt(pmtPid, pmt) {
+		// Keep the program with no stream rather than changing the PAT
+		out := *pmt
+		out.streams = make(map[int]Stream)
+		out.pcr_pid = NullPid
+		m.pmts[pmtPid] = pmt
+		m.pmtSections[pmtPid] = out.Section()
+		return nil
+	}
+	for pid, _ := range m.pids {
+		if err := m.use(pid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// use claims the output PID of a kept PID, and returns an error if another
+// PID already maps to it.
+func (m *Remuxer) use(pid int) error {
+	out := m.remap(pid)
+	if prev, ok := m.used[out]; ok && prev != pid {
+		return fmt.Errorf("remux: PID %d and %d both map to %d", prev, pid, out)
+	}
+	m.used[out] = pid
+	return nil
+}
+
+func (m *Remuxer) selected(pid int) bool {
+	if m.Drop[pid] {
+		return false
+	}
+	if len(m.Keep) > 0 {
+		return m.Keep[pid]
+	}
+	return true
+}
+
+func (m *Remuxer) remap(pid int) int {
+	if newPid, ok := m.Remap[pid]; ok {
+		return newPid
+	}
+	return pid
+}
+
+// Process writes the packet, or what replaces it. PIDs not listed in the
+// PSI are passed through when all programs are kept; an error is returned
+// when one of them maps to the output PID of another.
+func (m *Remuxer) Process(pkt *TsPkt) error {
+	pid := pkt.Pid
+	if pid == 0 {
+		if pkt.PUSI != 1 {
+			m.drop()
+		} else {
+			m.writeSection(0, m.patSection)
+		}
+	} else if _, ok := m.pmtSections[pid]; ok {
+		return m.processPmt(pkt)
+	} else if pid == NullPid && m.NullFill && m.excess > 0 {
+		m.excess--
+	} else if m.pids[pid] || (m.Program == 0 && !m.known[pid] && m.selected(pid)) {
+		if !m.pids[pid] {
+			if err := m.use(pid); err != nil {
+				return err
+			}
+			m.pids[pid] = true
+		}
+		buf := append([]byte{}, pkt.Raw...)
+		SetPid(buf, m.remap(pid))
+		m.Out.Write(buf)
+	} else {
+		m.drop()
+	}
+	return nil
+}
+
+// processPmt writes the rewritten PMT once a section is complete on its
+// PID, after updating it if the section is a new version.
+func (m *Remuxer) processPmt(pkt *TsPkt) error {
+	pid := pkt.Pid
+	data := m.pmtData[pid]
+	if pkt.PUSI == 1 {
+		data = nil
+	}
+	if !m.psi.BufferData(pkt, &data) {
+		m.pmtData[pid] = data
+		m.drop()
+		return nil
+	}
+	m.pmtData[pid] = nil
+	if err := m.updatePmt(pid, ParsePmt(data)); err != nil {
+		return err
+	}
+	m.writeSection(m.remap(pid), m.pmtSections[pid])
+	return nil
+}
+
+// writeSection writes a rewritten section on pid in place of an input
+// packet.
+func (m *Remuxer) writeSection(pid int, section []byte) {
+	cc := m.cc[pid]
+	bufs := PacketizeSection(pid, section, &cc)
+	for _, buf := range bufs {
+		m.Out.Write(buf)
+	}
+	m.cc[pid] = cc
+	m.excess += len(bufs) - 1
+}
+
+// drop replaces a packet with a null packet, unless it makes up for a
+// packet written ahead.
+func (m *Remuxer) drop() {
+	if !m.NullFill {
+		return
+	}
+	if m.excess > 0 {
+		m.excess--
+		return
+	}
+	m.Out.Write(NullPacket())
+}
@@ -1,10 +1,14 @@
 package mpts
 
 import (
+	"encoding/json"
+	"encoding/xml"
 	"fmt"
+	"log"
 	"os"
 	"path/filepath"
 	"strconv"
+	"time"
 )
 
 type SpliceInfoSection struct {
@@ -23,9 +27,47 @@ type SpliceInfoSection struct {
 	splice_command_type      int
 	descriptor_loop_length   int
 	SpliceDescriptorList     []SpliceDescriptor
+	E_CRC_32                 int64
+	CRC_32                   int64
+	raw                      []byte
+	// Ciphertext and plaintext of an encrypted section
+	encrypted []byte
+	decrypted []byte
+	*SpliceNull
+	*SpliceSchedule
 	*SpliceInsert
 	*TimeSignal
-	*SegmentDescriptor
+	*BandwidthReservation
+	*PrivateCommand
+}
+
+type SpliceNull struct {
+}
+
+type SpliceSchedule struct {
+	splice_count int
+	Events       []SpliceScheduleEvent
+}
+
+type SpliceScheduleEvent struct {
+	splice_event_id               int64
+	splice_event_cancel_indicator int
+	reserved                      int
+	out_of_network_indicator      int
+	program_splice_flag           int
+	duration_flag                 int
+	utc_splice_time               int64
+	component_count               int
+	Components                    []SpliceScheduleComponent
+	*BreakDuration
+	unique_program_id int
+	avail_num         int
+	avails_expected   int
+}
+
+type SpliceScheduleComponent struct {
+	component_tag   int
+	utc_splice_time int64
 }
 
 type SpliceInsert struct {
@@ -41,14 +83,28 @@ type SpliceInsert struct {
 	unique_program_id             int
 	avail_num                     int
 	avails_expected               int
+	Components                    []SpliceInsertComponent
 	*SpliceTime
 	*BreakDuration
 }
 
+type SpliceInsertComponent struct {
+	component_tag int
+	*SpliceTime
+}
+
 type TimeSignal struct {
 	SpliceTime
 }
 
+type BandwidthReservation struct {
+}
+
+type PrivateCommand struct {
+	identifier   int64
+	private_byte []byte
+}
+
 type SpliceTime struct {
 	time_specified_flag int
 	reserved            int
@@ -65,6 +121,43 @@ type SpliceDescriptor struct {
 	splice_descriptor_tag int
 	descriptor_length     int
 	identifier            int
+	*AvailDescriptor
+	*DtmfDescriptor
+	*SegmentDescriptor
+	*TimeDescriptor
+	*AudioDescriptor
+	private_byte []byte
+}
+
+type AvailDescriptor struct {
+	provider_avail_id int64
+}
+
+type DtmfDescriptor struct {
+	preroll    int
+	dtmf_count int
+	reserved   int
+	DTMF_char  string
+}
+
+type TimeDescriptor struct {
+	TAI_seconds int64
+	TAI_ns      int64
+	UTC_offset  int
+}
+
+type AudioDescriptor struct {
+	audio_count int
+	reserved    int
+	Components  []AudioComponent
+}
+
+type AudioComponent struct {
+	component_tag   int
+	ISO_code        string
+	Bit_Stream_Mode int
+	Num_Channels    int
+	Full_Srvc_Audio int
 }
 
 type SegmentDescriptor struct {
@@ -81,25 +174,47 @@ type SegmentDescriptor struct {
 	component_count                     int
 	component_tag                       int
 	pts_offset                          int64
+	Components                          []SegmentComponent
 	segmentation_duration               int64
 	segmentation_upid_type              int
 	segmentation_upid_length            int
+	Upid                                *SegmentationUpid
 	segmentation_type_id                int
 	segment_num                         int
 	segments_expected                   int
+	sub_segment_num                     int
+	sub_segments_expected               int
+	sub_segment_present                 bool
 }
 
+type SegmentComponent struct {
+	component_tag int
+	pts_offset    int64
+}
+
+// ParseSpliceInfoSection parses a section payload starting with pointer_field.
 func ParseSpliceInfoSection(data []byte) *SpliceInfoSection {
+	pointer := int(data[0])
+	return DecodeSpliceInfoSection(data[1+pointer:])
+}
+
+// DecodeSpliceInfoSection parses a splice_info_section without pointer_field,
+// e.g. one carried in base64 by HLS and DASH.
+func DecodeSpliceInfoSection(data []byte) *SpliceInfoSection {
 	r := NewReader(data)
-	pointer := r.ReadBit(8)
-	r.SkipByte(pointer)
 
 	section := &SpliceInfoSection{}
+	start := r.Base
 	section.table_id = r.ReadBit(8)
 	section.section_syntax_indicator = r.ReadBit(1)
 	section.private_indicator = r.ReadBit(1)
 	section.reserved = r.ReadBit(2)
 	section.section_length = r.ReadBit(12)
+	end := start + 3 + section.section_length
+	if end > len(data) {
+		end = len(data)
+	}
+	section.raw = data[start:end]
 	section.protocol_version = r.ReadBit(8)
 	section.encrypted_packet = r.ReadBit(1)
 	section.encryption_algorithm = r.ReadBit(6)
@@ -107,27 +222,68 @@ func ParseSpliceInfoSection(data []byte) *SpliceInfoSection {
 	section.cw_index = r.ReadBit(8)
 	section.tier = r.ReadBit(12)
 	section.splice_command_length = r.ReadBit(12)
+
+	// Everything from splice_command_type to E_CRC_32 may be encrypted
+	if section.encrypted_packet == 1 {
+		if end-4 < r.Base {
+			// Too short to hold the encrypted fields and E_CRC_32
+			section.encrypted = []byte{}
+			section.splice_command_type = -1
+			return section
+		}
+		section.encrypted = data[r.Base : end-4]
+		if r = section.decrypt(); r == nil {
+			section.splice_command_type = -1
+			section.CRC_32 = NewReader(data[end-4 : end]).ReadBit64(32)
+			return section
+		}
+	}
+
 	section.splice_command_type = r.ReadBit(8)
 
+	// splice_command_length of 0xFFF is allowed for backwards compatibility
+	cmdStart := r.Base
+	cmdLength := section.splice_command_length
+	if cmdLength == 0xFFF {
+		cmdLength = -1
+	}
 	switch section.splice_command_type {
-	case 5:
+	case 0x00:
+		section.SpliceNull = &SpliceNull{}
+	case 0x04:
+		section.SpliceSchedule = ParseSpliceSchedule(r)
+	case 0x05:
 		section.SpliceInsert = ParseSpliceInsert(r)
-	case 6:
+	case 0x06:
 		section.TimeSignal = ParseTimeSignal(r)
-	default:
-		r.SkipByte(section.splice_command_length)
+	case 0x07:
+		section.BandwidthReservation = &BandwidthReservation{}
+	case 0xFF:
+		section.PrivateCommand = ParsePrivateCommand(r, cmdLength)
+	}
+	if cmdLength >= 0 {
+		r.Base = cmdStart + cmdLength
+		r.Off = 0
 	}
 
 	section.descriptor_loop_length = r.ReadBit(16)
 	descriptor_loop_length := section.descriptor_loop_length
-	for descriptor_loop_length > 0 {
+	for descriptor_loop_length > 0 && r.Base+2 <= len(r.Data) {
 		descriptor_length := ParseSpliceDescriptor(section, r)
 		descriptor_loop_length -= descriptor_length
 	}
+	if len(section.raw) >= 4 {
+		section.CRC_32 = NewReader(section.raw[len(section.raw)-4:]).ReadBit64(32)
+	}
 
 	return section
 }
 
+// Bytes returns the splice_info_section as received, without pointer_field.
+func (section SpliceInfoSection) Bytes() []byte {
+	return section.raw
+}
+
 func ParseSpliceInsert(r *Reader) *SpliceInsert {
 	insert := &SpliceInsert{}
 	insert.splice_event_id = r.ReadBit64(32)
@@ -145,10 +301,14 @@ func ParseSpliceInsert(r *Reader) *SpliceInsert {
 		if insert.program_splice_flag == 0 {
 			insert.component_count = r.ReadBit(8)
 			for i := 0; i < insert.component_count; i++ {
-				insert.component_tag = r.ReadBit(8)
+				component := SpliceInsertComponent{}
+				component.component_tag = r.ReadBit(8)
+				insert.component_tag = component.component_tag
 				if insert.splice_immediate_flag == 0 {
-					insert.SpliceTime = ParseSpliceTime(r)
+					component.SpliceTime = ParseSpliceTime(r)
+					insert.SpliceTime = component.SpliceTime
 				}
+				insert.Components = append(insert.Components, component)
 			}
 		}
 		if insert.duration_flag == 1 {
@@ -161,6 +321,58 @@ func ParseSpliceInsert(r *Reader) *SpliceInsert {
 	return insert
 }
 
+func ParseSpliceSchedule(r *Reader) *SpliceSchedule {
+	schedule := &SpliceSchedule{}
+	schedule.splice_count = r.ReadBit(8)
+	for i := 0; i < schedule.splice_count; i++ {
+		event := SpliceScheduleEvent{}
+		event.splice_event_id = r.ReadBit64(32)
+		event.splice_event_cancel_indicator = r.ReadBit(1)
+		event.reserved = r.ReadBit(7)
+		if event.splice_event_cancel_indicator == 0 {
+			event.out_of_network_indicator = r.ReadBit(1)
+			event.program_splice_flag = r.ReadBit(1)
+			event.duration_flag = r.ReadBit(1)
+			event.reserved = r.ReadBit(5)
+			if event.program_splice_flag == 1 {
+				event.utc_splice_time = r.ReadBit64(32)
+			} else {
+				event.component_count = r.ReadBit(8)
+				for j := 0; j < event.component_count; j++ {
+					component := SpliceScheduleComponent{}
+					component.component_tag = r.ReadBit(8)
+					component.utc_splice_time = r.ReadBit64(32)
+					event.Components = append(event.Components, component)
+				}
+			}
+			if event.duration_flag == 1 {
+				event.BreakDuration = ParseBreakDuration(r)
+			}
+			event.unique_program_id = r.ReadBit(16)
+			event.avail_num = r.ReadBit(8)
+			event.avails_expected = r.ReadBit(8)
+		}
+		schedule.Events = append(schedule.Events, event)
+	}
+	return schedule
+}
+
+// ParsePrivateCommand reads a private_command of the given
+// splice_command_length, or with no private bytes if the length is unknown.
+func ParsePrivateCommand(r *Reader, length int) *PrivateCommand {
+	command := &PrivateCommand{}
+	command.identifier = r.ReadBit64(32)
+	if length > 4 {
+		end := r.Base + length - 4
+		if end > len(r.Data) {
+			end = len(r.Data)
+		}
+		command.private_byte = r.Data[r.Base:end]
+		r.SkipByte(length - 4)
+	}
+	return command
+}
+
 func ParseTimeSignal(r *Reader) *TimeSignal {
 	signal := &TimeSignal{}
 	signal.SpliceTime = *ParseSpliceTime(r)
@@ -187,22 +399,106 @@ func ParseBreakDuration(r *Reader) *BreakDuration {
 	return duration
 }
 
+// Identifier of the descriptors defined by SCTE-35
+const CueIdentifier = 0x43554549 // "CUEI"
+
+// ParseSpliceDescriptor reads a splice_descriptor into section and returns
+// its size. A descriptor running past the data, or whose fields run past
+// descriptor_length, is skipped.
 func ParseSpliceDescriptor(section *SpliceInfoSection, r *Reader) int {
 	descriptor := SpliceDescriptor{}
 	descriptor.splice_descriptor_tag = r.ReadBit(8)
 	descriptor.descriptor_length = r.ReadBit(8)
+	if descriptor.descriptor_length < 4 || r.Base+descriptor.descriptor_length > len(r.Data) {
+		r.SkipByte(descriptor.descriptor_length)
+		return descriptor.descriptor_length + 2
+	}
 	descriptor.identifier = r.ReadBit(32)
-	section.SpliceDescriptorList = append(section.SpliceDescriptorList, descriptor)
 
-	if descriptor.splice_descriptor_tag == 2 {
-		section.SegmentDescriptor = ParseSegmentDescriptor(r)
+	// Each descriptor is read on its own so a bad one cannot shift the loop
+	data := r.Data[r.Base : r.Base+descriptor.descriptor_length-4]
+	r.SkipByte(descriptor.descriptor_length - 4)
+	d := NewReader(data)
+	if descriptor.identifier != CueIdentifier {
+		descriptor.private_byte = data
 	} else {
-		r.SkipByte(descriptor.descriptor_length - 4)
+		switch descriptor.splice_descriptor_tag {
+		case 0x00:
+			descriptor.AvailDescriptor = ParseAvailDescriptor(d)
+		case 0x01:
+			if descriptor.DtmfDescriptor = ParseDtmfDescriptor(d); descriptor.DtmfDescriptor == nil {
+				return descriptor.descriptor_length + 2
+			}
+		case 0x02:
+			if descriptor.SegmentDescriptor = ParseSegmentDescriptor(d); descriptor.SegmentDescriptor == nil {
+				return descriptor.descriptor_length + 2
+			}
+		case 0x03:
+			descriptor.TimeDescriptor = ParseTimeDescriptor(d)
+		case 0x04:
+			if descriptor.AudioDescriptor = ParseAudioDescriptor(d); descriptor.AudioDescriptor == nil {
+				return descriptor.descriptor_length + 2
+			}
+		default:
+			descriptor.private_byte = data
+		}
 	}
+	section.SpliceDescriptorList = append(section.SpliceDescriptorList, descriptor)
 
 	return descriptor.descriptor_length + 2
 }
 
+func ParseAvailDescriptor(r *Reader) *AvailDescriptor {
+	avail := &AvailDescriptor{}
+	avail.provider_avail_id = r.ReadBit64(32)
+	return avail
+}
+
+// ParseDtmfDescriptor returns nil if the DTMF_chars run past the data.
+func ParseDtmfDescriptor(r *Reader) *DtmfDescriptor {
+	dtmf := &DtmfDescriptor{}
+	dtmf.preroll = r.ReadBit(8)
+	dtmf.dtmf_count = r.ReadBit(3)
+	dtmf.reserved = r.ReadBit(5)
+	if r.Base+dtmf.dtmf_count > len(r.Data) {
+		return nil
+	}
+	dtmf.DTMF_char = string(r.Data[r.Base : r.Base+dtmf.dtmf_count])
+	r.SkipByte(dtmf.dtmf_count)
+	return dtmf
+}
+
+func ParseTimeDescriptor(r *Reader) *TimeDescriptor {
+	t := &TimeDescriptor{}
+	t.TAI_seconds = r.ReadBit64(48)
+	t.TAI_ns = r.ReadBit64(32)
+	t.UTC_offset = r.ReadBit(16)
+	return t
+}
+
+// ParseAudioDescriptor returns nil if the components run past the data.
+func ParseAudioDescriptor(r *Reader) *AudioDescriptor {
+	audio := &AudioDescriptor{}
+	audio.audio_count = r.ReadBit(4)
+	audio.reserved = r.ReadBit(4)
+	for i := 0; i < audio.audio_count; i++ {
+		c := AudioComponent{}
+		if r.Base+5 > len(r.Data) {
+			return nil
+		}
+		c.component_tag = r.ReadBit(8)
+		c.ISO_code = string(r.Data[r.Base : r.Base+3])
+		r.SkipByte(3)
+		c.Bit_Stream_Mode = r.ReadBit(3)
+		c.Num_Channels = r.ReadBit(4)
+		c.Full_Srvc_Audio = r.ReadBit(1)
+		audio.Components = append(audio.Components, c)
+	}
+	return audio
+}
+
+// ParseSegmentDescriptor returns nil if the segmentation_upid runs past the
+// data.
 func ParseSegmentDescriptor(r *Reader) *SegmentDescriptor {
 	segment := &SegmentDescriptor{}
 	segment.segmentation_event_id = r.ReadBit(32)
@@ -226,6 +522,8 @@ func ParseSegmentDescriptor(r *Reader) *SegmentDescriptor {
 				segment.component_tag = r.ReadBit(8)
 				segment.reserved = r.ReadBit(7)
 				segment.pts_offset = r.ReadBit64(33)
+				segment.Components = append(segment.Components,
+					SegmentComponent{segment.component_tag, segment.pts_offset})
 			}
 		}
 		if segment.segmentation_duration_flag == 1 {
@@ -234,23 +532,64 @@ func ParseSegmentDescriptor(r *Reader) *SegmentDescriptor {
 		}
 		segment.segmentation_upid_type = r.ReadBit(8)
 		segment.segmentation_upid_length = r.ReadBit(8)
-		// segmentation_upid()
+		if r.Base+segment.segmentation_upid_length > len(r.Data) {
+			return nil
+		}
+		upid := r.Data[r.Base : r.Base+segment.segmentation_upid_length]
+		segment.Upid = ParseSegmentationUpid(segment.segmentation_upid_type, upid)
 		r.SkipByte(segment.segmentation_upid_length)
 		segment.segmentation_type_id = r.ReadBit(8)
 		segment.segment_num = r.ReadBit(8)
 		segment.segments_expected = r.ReadBit(8)
+		// Only present in messages following SCTE 35 2016 and later
+		if HasSubSegments(segment.segmentation_type_id) && r.Base+2 <= len(r.Data) {
+			segment.sub_segment_num = r.ReadBit(8)
+			segment.sub_segments_expected = r.ReadBit(8)
+			segment.sub_segment_present = true
+		}
 	}
 	return segment
 }
 
+// HasSubSegments reports whether the segmentation_type_id, a provider or
+// distributor placement opportunity start, carries sub_segment_num and
+// sub_segments_expected.
+func HasSubSegments(segType int) bool {
+	switch segType {
+	case 0x34, 0x36, 0x38, 0x3A:
+		return true
+	}
+	return false
+}
+
+var SpliceCommandTypeString map[int]string = map[int]string{
+	0x00: "splice_null",
+	0x04: "splice_schedule",
+	0x05: "splice_insert",
+	0x06: "time_signal",
+	0x07: "bandwidth_reservation",
+	0xFF: "private_command",
+}
+
+// SegmentDescriptors returns the segmentation_descriptors in loop order.
+func (section SpliceInfoSection) SegmentDescriptors() []*SegmentDescriptor {
+	var segments []*SegmentDescriptor
+	for _, d := range section.SpliceDescriptorList {
+		if d.SegmentDescriptor != nil {
+			segments = append(segments, d.SegmentDescriptor)
+		}
+	}
+	return segments
+}
+
 func (section SpliceInfoSection) GetSpliceType() string {
-	switch section.splice_command_type {
-	case 5:
-		return "splice_insert"
-	case 6:
-		return "time_signal"
-	default:
-		return "private"
+	if section.opaque() {
+		return "encrypted"
+	}
+	if typeString, ok := SpliceCommandTypeString[section.splice_command_type]; ok {
+		return typeString
+	} else {
+		return "reserved"
 	}
 }
 
@@ -271,8 +610,8 @@ func (section SpliceInfoSection) GetSpliceDuration() int64 {
 	case 5:
 		duration = section.SpliceInsert.GetSpliceDuration()
 	case 6:
-		if section.SegmentDescriptor != nil {
-			return section.SegmentDescriptor.GetSpliceDuration()
+		if segments := section.SegmentDescriptors(); len(segments) > 0 {
+			return segments[0].GetSpliceDuration()
 		}
 	}
 	return duration
@@ -286,8 +625,8 @@ func (section SpliceInfoSection) GetSegType() int {
 		segType = section.SpliceInsert.GetOutOfNetworkIndicator()
 	case 6:
 		// segmentation_type_id
-		if section.SegmentDescriptor != nil {
-			segType = section.SegmentDescriptor.GetSegType()
+		if segments := section.SegmentDescriptors(); len(segments) > 0 {
+			segType = segments[0].GetSegType()
 		}
 	}
 	return segType
@@ -313,6 +652,35 @@ func (insert SpliceInsert) GetOutOfNetworkIndicator() int {
 	return insert.out_of_network_indicator
 }
 
+// GpsEpoch is the origin of utc_splice_time in splice_schedule.
+var GpsEpoch = time.Date(1980, time.January, 6, 0, 0, 0, 0, time.UTC)
+
+// GetUTCSpliceTime returns the program splice time of the event. The value
+// counts seconds since GpsEpoch, leap seconds included, so the result is
+// ahead of UTC by the GPS-UTC offset.
+func (event SpliceScheduleEvent) GetUTCSpliceTime() time.Time {
+	return GpsEpoch.Add(time.Duration(event.utc_splice_time) * time.Second)
+}
+
+func (event SpliceScheduleEvent) GetSpliceEventId() int64 {
+	return event.splice_event_id
+}
+
+func (component SpliceScheduleComponent) GetUTCSpliceTime() time.Time {
+	return GpsEpoch.Add(time.Duration(component.utc_splice_time) * time.Second)
+}
+
+// GetIdentifier returns the 32-bit identifier, normally an ASCII value
+// registered with SMPTE.
+func (command PrivateCommand) GetIdentifier() string {
+	id := command.identifier
+	return string([]byte{byte(id >> 24), byte(id >> 16), byte(id >> 8), byte(id)})
+}
+
+func (command PrivateCommand) GetPrivateBytes() []byte {
+	return command.private_byte
+}
+
 func (signal TimeSignal) GetSpliceTime() int64 {
 	if signal.SpliceTime.time_specified_flag == 1 {
 		return signal.SpliceTime.pts_time
@@ -343,13 +711,24 @@ type Scte35Record struct {
 	Sections []*SpliceInfoSection
 }
 
+// addSection parses the section in progress. A malformed one is logged and
+// dropped.
+func (s *Scte35Record) addSection() {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Println("SCTE-35 parsing error at", s.CurByte, r)
+		}
+	}()
+	section := ParseSpliceInfoSection(s.CurData)
+	s.BytePos = append(s.BytePos, s.CurByte)
+	s.PcrTime = append(s.PcrTime, s.CurTime)
+	s.Sections = append(s.Sections, section)
+}
+
 func (s *Scte35Record) Process(pkt *TsPkt) {
 	if pkt.PUSI == 1 {
 		if s.CurData != nil {
-			section := ParseSpliceInfoSection(s.CurData)
-			s.BytePos = append(s.BytePos, s.CurByte)
-			s.PcrTime = append(s.PcrTime, s.CurTime)
-			s.Sections = append(s.Sections, section)
+			s.addSection()
 		}
 		s.CurByte = pkt.Pos
 		s.CurTime = s.BaseRecord.PcrTime
@@ -361,10 +740,7 @@ func (s *Scte35Record) Process(pkt *TsPkt) {
 
 func (s *Scte35Record) Flush() {
 	if s.CurData != nil {
-		section := ParseSpliceInfoSection(s.CurData)
-		s.BytePos = append(s.BytePos, s.CurByte)
-		s.PcrTime = append(s.PcrTime, s.CurTime)
-		s.Sections = append(s.Sections, section)
+		s.addSection()
 	}
 }
 
@@ -376,21 +752,95 @@ func (s *Scte35Record) Report(root string) {
 	}
 	defer w.Close()
 
-	fmt.Fprintln(w, "pos, pcr, type, pts_time, pts_adjust, duration, out_or_segType")
+	fmt.Fprintln(w, "pos, pcr, type, pts_time, pts_adjust, duration, out_or_segType, base64")
 	if s.Sections != nil {
 		for i, section := range s.Sections {
 			splice_type := section.GetSpliceType()
 			pts, adj := section.GetSpliceTime()
 			duration := section.GetSpliceDuration()
 			segType := section.GetSegType()
-			fmt.Fprintf(w, "%v, %v, %v, %v, %v, %v, %v\n",
+			fmt.Fprintf(w, "%v, %v, %v, %v, %v, %v, %v, %v\n",
 				s.BytePos[i],
 				s.PcrTime[i]/300,
 				splice_type,
 				pts,
 				adj,
 				duration,
-				segType)
+				segType,
+				section.Base64())
+		}
+	}
+
+	s.reportJson(root)
+	s.reportXml(root)
+	s.reportBreaks(root)
+	s.reportPreroll(root)
+}
+
+// reportJson writes one JSON object per section.
+func (s *Scte35Record) reportJson(root string) {
+	fname := filepath.Join(root, strconv.Itoa(s.Pid)+"-scte35.json")
+	w, err := os.Create(fname)
+	if err != nil {
+		panic(err)
+	}
+	defer w.Close()
+
+	for i, section := range s.Sections {
+		o := jsonObject{}
+		o.Add("pos", s.BytePos[i])
+		o.Add("pcr", s.PcrTime[i]/300)
+		o.Add("splice_info_section", section.jsonObject())
+		b, err := json.Marshal(o)
+		if err != nil {
+			panic(err)
 		}
+		fmt.Fprintln(w, string(b))
+	}
+}
+
+// reportXml writes the sections as a DASH EventStream. Sections with no
+// splice time, e.g. splice_null or an immediate splice_insert, have no
+// presentationTime.
+func (s *Scte35Record) reportXml(root string) {
+	fname := filepath.Join(root, strconv.Itoa(s.Pid)+"-scte35.xml")
+	w, err := os.Create(fname)
+	if err != nil {
+		panic(err)
+	}
+	defer w.Close()
+
+	type event struct {
+		PresentationTime *int64             `xml:"presentationTime,attr,omitempty"`
+		Duration         int64              `xml:"duration,attr,omitempty"`
+		Id               int                `xml:"id,attr"`
+		Section          *SpliceInfoSection `xml:"SpliceInfoSection"`
+	}
+	type eventStream struct {
+		XMLName     xml.Name `xml:"EventStream"`
+		SchemeIdUri string   `xml:"schemeIdUri,attr"`
+		Timescale   int      `xml:"timescale,attr"`
+		Events      []event  `xml:"Event"`
+	}
+
+	stream := eventStream{SchemeIdUri: Scte35XmlScheme, Timescale: 90000}
+	for i, section := range s.Sections {
+		e := event{Id: i, Section: section}
+		if d := section.GetSpliceDuration(); d > 0 {
+			e.Duration = d
+		}
+		if pts, adj := section.GetSpliceTime(); pts >= 0 {
+			t := adjustPts(pts, adj)
+			e.PresentationTime = &t
+		}
+		stream.Events = append(stream.Events, e)
+	}
+
+	fmt.Fprint(w, xml.Header)
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(stream); err != nil {
+		panic(err)
 	}
+	fmt.Fprintln(w)
 }
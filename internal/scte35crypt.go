@@ -0,0 +1,187 @@
+package mpts
+
+import (
+	"bufio"
+	"crypto/cipher"
+	"crypto/des"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// SCTE-35 Table 27
+var EncryptionAlgorithmString map[int]string = map[int]string{
+	0: "No encryption",
+	1: "DES - ECB mode",
+	2: "DES - CBC mode",
+	3: "Triple DES EDE3 - ECB mode",
+}
+
+// ControlWords holds the keys used to decrypt splice_info_sections, indexed
+// by cw_index. Sections whose key is missing are left encrypted.
+var ControlWords map[int][]byte = map[int][]byte{}
+
+// LoadControlWords reads a control word file into ControlWords. Each line
+// holds a cw_index and a hex key, 8 bytes for DES or 24 for triple DES:
+//
+//	# cw_index key
+//	0 0123456789abcdef
+//
+// Nothing is loaded if the file has a malformed line.
+func LoadControlWords(fname string) error {
+	f, err := os.Open(fname)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	keys := make(map[int][]byte)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return fmt.Errorf("%s:%d: expected cw_index and key", fname, n)
+		}
+		index, err := strconv.ParseInt(fields[0], 0, 32)
+		if err != nil || index < 0 || index > 0xFF {
+			return fmt.Errorf("%s:%d: invalid cw_index %s", fname, n, fields[0])
+		}
+		key, err := hex.DecodeString(strings.TrimPrefix(fields[1], "0x"))
+		if err != nil || len(key) != des.BlockSize && len(key) != 3*des.BlockSize {
+			return fmt.Errorf("%s:%d: key is not 8 or 24 hex bytes", fname, n)
+		}
+		keys[int(index)] = key
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	for index, key := range keys {
+		ControlWords[index] = key
+	}
+	return nil
+}
+
+func (section SpliceInfoSection) IsEncrypted() bool {
+	return section.encrypted_packet == 1
+}
+
+// IsDecrypted reports whether an encrypted section was decrypted with a
+// control word.
+func (section SpliceInfoSection) IsDecrypted() bool {
+	return section.decrypted != nil
+}
+
+// opaque reports whether the section was received encrypted and could not
+// be decrypted, so that only its clear fields are known.
+func (section SpliceInfoSection) opaque() bool {
+	return section.IsEncrypted() && section.encrypted != nil && section.decrypted == nil
+}
+
+func (section SpliceInfoSection) GetEncryptionAlgorithm() string {
+	if name, ok := EncryptionAlgorithmString[section.encryption_algorithm]; ok {
+		return name
+	} else if section.encryption_algorithm >= 32 {
+		return "User private"
+	}
+	return "Reserved"
+}
+
+// CheckECrc reports whether the E_CRC_32 matches the decrypted portion.
+func (section SpliceInfoSection) CheckECrc() bool {
+	return len(section.decrypted) >= 4 && Crc32(section.decrypted) == 0
+}
+
+func newSpliceCipher(algorithm int, key []byte) cipher.Block {
+	var block cipher.Block
+	var err error
+	switch algorithm {
+	case 1, 2:
+		block, err = des.NewCipher(key)
+	case 3:
+		block, err = des.NewTripleDESCipher(key)
+	default:
+		return nil
+	}
+	if err != nil {
+		return nil
+	}
+	return block
+}
+
+// spliceCrypt runs DES in ECB or CBC mode with a zero IV over whole blocks.
+func spliceCrypt(algorithm int, key []byte, data []byte, encrypt bool) []byte {
+	block := newSpliceCipher(algorithm, key)
+	if block == nil || len(data)%block.BlockSize() != 0 {
+		return nil
+	}
+	out := make([]byte, len(data))
+	if algorithm == 2 {
+		iv := make([]byte, block.BlockSize())
+		if encrypt {
+			cipher.NewCBCEncrypter(block, iv).CryptBlocks(out, data)
+		} else {
+			cipher.NewCBCDecrypter(block, iv).CryptBlocks(out, data)
+		}
+		return out
+	}
+	for i := 0; i < len(data); i += block.BlockSize() {
+		if encrypt {
+			block.Encrypt(out[i:], data[i:])
+		} else {
+			block.Decrypt(out[i:], data[i:])
+		}
+	}
+	return out
+}
+
+// decrypt returns a reader over the decrypted command and descriptors, or
+// nil if there is no usable control word or the E_CRC_32 does not match.
+func (section *SpliceInfoSection) decrypt() *Reader {
+	key, ok := ControlWords[section.cw_index]
+	if !ok {
+		return nil
+	}
+	plain := spliceCrypt(section.encryption_algorithm, key, section.encrypted, false)
+	if plain == nil || len(plain) < 5 {
+		return nil
+	}
+	section.E_CRC_32 = NewReader(plain[len(plain)-4:]).ReadBit64(32)
+	// A wrong control word yields garbage, so parse only what checks out
+	if Crc32(plain) != 0 {
+		return nil
+	}
+	section.decrypted = plain
+	return NewReader(plain)
+}
+
+// encrypt appends alignment_stuffing and E_CRC_32 to the plaintext from
+// splice_command_type on, and encrypts it with the section's control word.
+// The section is left unchanged if there is no usable control word.
+func (section *SpliceInfoSection) encrypt(plain []byte) ([]byte, error) {
+	key, ok := ControlWords[section.cw_index]
+	if !ok {
+		return nil, fmt.Errorf("no control word for cw_index %d", section.cw_index)
+	}
+	for (len(plain)+4)%des.BlockSize != 0 {
+		plain = append(plain, 0xFF)
+	}
+	w := NewWriter()
+	w.WriteBytes(plain)
+	crc := int64(Crc32(plain))
+	w.WriteBit64(crc, 32)
+	data := spliceCrypt(section.encryption_algorithm, key, w.Bytes(), true)
+	if data == nil {
+		return nil, fmt.Errorf("cannot encrypt with %s and a %d byte control word",
+			section.GetEncryptionAlgorithm(), len(key))
+	}
+	section.E_CRC_32 = crc
+	section.decrypted = w.Bytes()
+	section.encrypted = data
+	return data, nil
+}
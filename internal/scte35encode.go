@@ -0,0 +1,463 @@
+package mpts
+
+// NewSpliceInfoSection returns a section carrying a splice_null command with
+// no descriptors, ready to have its command and descriptors set.
+func NewSpliceInfoSection() *SpliceInfoSection {
+	section := &SpliceInfoSection{}
+	section.table_id = 0xFC
+	section.reserved = 3 // sap_type not specified
+	section.cw_index = 0xFF
+	section.tier = 0xFFF
+	section.SpliceNull = &SpliceNull{}
+	return section
+}
+
+func (section *SpliceInfoSection) SetPtsAdjustment(adj int64) {
+	section.pts_adjustment = adj
+}
+
+func (section *SpliceInfoSection) SetTier(tier int) {
+	section.tier = tier
+}
+
+// SetCommand replaces the splice command. It takes one of *SpliceNull,
+// *SpliceSchedule, *SpliceInsert, *TimeSignal, *BandwidthReservation or
+// *PrivateCommand.
+func (section *SpliceInfoSection) SetCommand(command interface{}) {
+	section.SpliceNull = nil
+	section.SpliceSchedule = nil
+	section.SpliceInsert = nil
+	section.TimeSignal = nil
+	section.BandwidthReservation = nil
+	section.PrivateCommand = nil
+	switch c := command.(type) {
+	case *SpliceNull:
+		section.SpliceNull = c
+	case *SpliceSchedule:
+		section.SpliceSchedule = c
+	case *SpliceInsert:
+		section.SpliceInsert = c
+	case *TimeSignal:
+		section.TimeSignal = c
+	case *BandwidthReservation:
+		section.BandwidthReservation = c
+	case *PrivateCommand:
+		section.PrivateCommand = c
+	default:
+		panic("unknown splice command")
+	}
+	section.splice_command_type = section.commandType()
+}
+
+func (section *SpliceInfoSection) AddAvailDescriptor(providerAvailId int64) {
+	section.SpliceDescriptorList = append(section.SpliceDescriptorList, SpliceDescriptor{
+		splice_descriptor_tag: 0x00,
+		identifier:            CueIdentifier,
+		AvailDescriptor:       &AvailDescriptor{provider_avail_id: providerAvailId},
+	})
+}
+
+func (section *SpliceInfoSection) AddSegmentDescriptor(segment *SegmentDescriptor) {
+	section.SpliceDescriptorList = append(section.SpliceDescriptorList, SpliceDescriptor{
+		splice_descriptor_tag: 0x02,
+		identifier:            CueIdentifier,
+		SegmentDescriptor:     segment,
+	})
+}
+
+// NewSpliceTime returns a splice_time, or one without pts_time if pts is
+// negative.
+func NewSpliceTime(pts int64) *SpliceTime {
+	if pts < 0 {
+		return &SpliceTime{reserved: 0x7F}
+	}
+	return &SpliceTime{time_specified_flag: 1, reserved: 0x3F, pts_time: pts & 0x1FFFFFFFF}
+}
+
+func NewBreakDuration(duration int64, autoReturn bool) *BreakDuration {
+	return &BreakDuration{auto_return: b2i(autoReturn), reserved: 0x3F, duration: duration}
+}
+
+// NewSpliceInsert returns a program splice_insert. A negative pts makes it
+// splice immediately, and a positive duration adds an auto-return
+// break_duration.
+func NewSpliceInsert(eventId int64, outOfNetwork bool, pts int64, duration int64) *SpliceInsert {
+	insert := &SpliceInsert{}
+	insert.splice_event_id = eventId
+	insert.reserved = 0xF
+	insert.out_of_network_indicator = b2i(outOfNetwork)
+	insert.program_splice_flag = 1
+	if pts < 0 {
+		insert.splice_immediate_flag = 1
+	} else {
+		insert.SpliceTime = NewSpliceTime(pts)
+	}
+	if duration > 0 {
+		insert.duration_flag = 1
+		insert.BreakDuration = NewBreakDuration(duration, true)
+	}
+	return insert
+}
+
+// NewSpliceCancel returns a splice_insert cancelling a pending event.
+func NewSpliceCancel(eventId int64) *SpliceInsert {
+	return &SpliceInsert{splice_event_id: eventId, splice_event_cancel_indicator: 1, reserved: 0x7F}
+}
+
+func (insert *SpliceInsert) SetAvail(uniqueProgramId, availNum, availsExpected int) {
+	insert.unique_program_id = uniqueProgramId
+	insert.avail_num = availNum
+	insert.avails_expected = availsExpected
+}
+
+func NewTimeSignal(pts int64) *TimeSignal {
+	return &TimeSignal{SpliceTime: *NewSpliceTime(pts)}
+}
+
+func NewPrivateCommand(identifier int64, data []byte) *PrivateCommand {
+	return &PrivateCommand{identifier: identifier, private_byte: data}
+}
+
+// NewSegmentDescriptor returns a program segmentation_descriptor without
+// delivery restrictions. A positive duration sets segmentation_duration and
+// a nil upid is encoded as type 0x00 (not used).
+func NewSegmentDescriptor(eventId int, segType int, duration int64, upid *SegmentationUpid) *SegmentDescriptor {
+	segment := &SegmentDescriptor{}
+	segment.segmentation_event_id = eventId
+	segment.reserved = 0x1F
+	segment.program_segmentation_flag = 1
+	segment.delivery_not_restricted_flag = 1
+	if duration > 0 {
+		segment.segmentation_duration_flag = 1
+		segment.segmentation_duration = duration
+	}
+	if upid == nil {
+		upid = ParseSegmentationUpid(0x00, nil)
+	}
+	segment.Upid = upid
+	segment.segmentation_upid_type = upid.segmentation_upid_type
+	segment.segmentation_upid_length = len(upid.upid)
+	segment.segmentation_type_id = segType
+	segment.sub_segment_present = HasSubSegments(segType)
+	return segment
+}
+
+// NewSegmentCancel returns a segmentation_descriptor cancelling an event.
+func NewSegmentCancel(eventId int) *SegmentDescriptor {
+	return &SegmentDescriptor{
+		segmentation_event_id:               eventId,
+		segmentation_event_cancel_indicator: 1,
+		reserved:                            0x7F,
+	}
+}
+
+func (segment *SegmentDescriptor) SetSegmentNum(num, expected int) {
+	segment.segment_num = num
+	segment.segments_expected = expected
+}
+
+func (segment *SegmentDescriptor) SetSubSegmentNum(num, expected int) {
+	segment.sub_segment_present = true
+	segment.sub_segment_num = num
+	segment.sub_segments_expected = expected
+}
+
+// SetDeliveryRestrictions clears delivery_not_restricted_flag and sets the
+// restriction flags.
+func (segment *SegmentDescriptor) SetDeliveryRestrictions(webDelivery, noBlackout, archive bool, device int) {
+	segment.delivery_not_restricted_flag = 0
+	segment.web_delivery_allowed_flag = b2i(webDelivery)
+	segment.no_regional_blackout_flag = b2i(noBlackout)
+	segment.archive_allowed_flag = b2i(archive)
+	segment.device_restrictions = device
+}
+
+func (section *SpliceInfoSection) commandType() int {
+	switch {
+	case section.SpliceNull != nil:
+		return 0x00
+	case section.SpliceSchedule != nil:
+		return 0x04
+	case section.SpliceInsert != nil:
+		return 0x05
+	case section.TimeSignal != nil:
+		return 0x06
+	case section.BandwidthReservation != nil:
+		return 0x07
+	case section.PrivateCommand != nil:
+		return 0xFF
+	}
+	return section.splice_command_type
+}
+
+// Encode serializes the section without pointer_field. The count and length
+// fields, including section_length, and the CRC_32 are recomputed, and the
+// result is kept as the section's raw bytes. An encrypted section is
+// encrypted again with its control word, or copied as is if it was never
+// decrypted; an error is returned if it cannot be encrypted.
+func (section *SpliceInfoSection) Encode() ([]byte, error) {
+	var body []byte
+	if section.opaque() {
+		body = section.encrypted
+	} else {
+		body = section.encodeBody()
+		if section.IsEncrypted() {
+			var err error
+			if body, err = section.encrypt(body); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Everything after section_length, up to and including CRC_32
+	section.section_length = 10 + len(body) + 4
+
+	w := NewWriter()
+	w.WriteBit(section.table_id, 8)
+	w.WriteBit(section.section_syntax_indicator, 1)
+	w.WriteBit(section.private_indicator, 1)
+	w.WriteBit(section.reserved, 2)
+	w.WriteBit(section.section_length, 12)
+	w.WriteBit(section.protocol_version, 8)
+	w.WriteBit(section.encrypted_packet, 1)
+	w.WriteBit(section.encryption_algorithm, 6)
+	w.WriteBit64(section.pts_adjustment, 33)
+	w.WriteBit(section.cw_index, 8)
+	w.WriteBit(section.tier, 12)
+	w.WriteBit(section.splice_command_length, 12)
+	w.WriteBytes(body)
+	section.CRC_32 = int64(Crc32(w.Bytes()))
+	w.WriteBit64(section.CRC_32, 32)
+
+	section.raw = w.Bytes()
+	return section.raw, nil
+}
+
+// encodeBody serializes the section from splice_command_type up to the
+// end of the descriptor loop.
+func (section *SpliceInfoSection) encodeBody() []byte {
+	cmd := NewWriter()
+	section.splice_command_type = section.commandType()
+	switch section.splice_command_type {
+	case 0x04:
+		section.SpliceSchedule.encode(cmd)
+	case 0x05:
+		section.SpliceInsert.encode(cmd)
+	case 0x06:
+		section.TimeSignal.SpliceTime.encode(cmd)
+	case 0xFF:
+		cmd.WriteBit64(section.PrivateCommand.identifier, 32)
+		cmd.WriteBytes(section.PrivateCommand.private_byte)
+	}
+	section.splice_command_length = cmd.Len()
+
+	descriptors := NewWriter()
+	for i := range section.SpliceDescriptorList {
+		section.SpliceDescriptorList[i].encode(descriptors)
+	}
+	section.descriptor_loop_length = descriptors.Len()
+
+	w := NewWriter()
+	w.WriteBit(section.splice_command_type, 8)
+	w.WriteBytes(cmd.Bytes())
+	w.WriteBit(section.descriptor_loop_length, 16)
+	w.WriteBytes(descriptors.Bytes())
+	return w.Bytes()
+}
+
+// SetEncryption marks the section to be encrypted by Encode with the
+// control word at cwIndex in ControlWords.
+func (section *SpliceInfoSection) SetEncryption(algorithm int, cwIndex int) {
+	section.encrypted_packet = 1
+	section.encryption_algorithm = algorithm
+	section.cw_index = cwIndex
+}
+
+func (t *SpliceTime) encode(w *Writer) {
+	w.WriteBit(t.time_specified_flag, 1)
+	if t.time_specified_flag == 1 {
+		w.WriteBit(0x3F, 6)
+		w.WriteBit64(t.pts_time, 33)
+	} else {
+		w.WriteBit(0x7F, 7)
+	}
+}
+
+func (d *BreakDuration) encode(w *Writer) {
+	w.WriteBit(d.auto_return, 1)
+	w.WriteBit(0x3F, 6)
+	w.WriteBit64(d.duration, 33)
+}
+
+func (insert *SpliceInsert) encode(w *Writer) {
+	w.WriteBit64(insert.splice_event_id, 32)
+	w.WriteBit(insert.splice_event_cancel_indicator, 1)
+	w.WriteBit(0x7F, 7)
+	if insert.splice_event_cancel_indicator == 1 {
+		return
+	}
+	insert.duration_flag = b2i(insert.BreakDuration != nil)
+	w.WriteBit(insert.out_of_network_indicator, 1)
+	w.WriteBit(insert.program_splice_flag, 1)
+	w.WriteBit(insert.duration_flag, 1)
+	w.WriteBit(insert.splice_immediate_flag, 1)
+	w.WriteBit(0xF, 4)
+	if insert.program_splice_flag == 1 && insert.splice_immediate_flag == 0 {
+		if insert.SpliceTime == nil {
+			insert.SpliceTime = NewSpliceTime(-1)
+		}
+		insert.SpliceTime.encode(w)
+	}
+	if insert.program_splice_flag == 0 {
+		insert.component_count = len(insert.Components)
+		w.WriteBit(insert.component_count, 8)
+		for i := range insert.Components {
+			component := &insert.Components[i]
+			w.WriteBit(component.component_tag, 8)
+			if insert.splice_immediate_flag == 0 {
+				// No splice_time is time_specified_flag 0
+				if component.SpliceTime == nil {
+					component.SpliceTime = NewSpliceTime(-1)
+				}
+				component.SpliceTime.encode(w)
+			}
+		}
+	}
+	if insert.duration_flag == 1 {
+		insert.BreakDuration.encode(w)
+	}
+	w.WriteBit(insert.unique_program_id, 16)
+	w.WriteBit(insert.avail_num, 8)
+	w.WriteBit(insert.avails_expected, 8)
+}
+
+func (schedule *SpliceSchedule) encode(w *Writer) {
+	schedule.splice_count = len(schedule.Events)
+	w.WriteBit(schedule.splice_count, 8)
+	for i := range schedule.Events {
+		event := &schedule.Events[i]
+		w.WriteBit64(event.splice_event_id, 32)
+		w.WriteBit(event.splice_event_cancel_indicator, 1)
+		w.WriteBit(0x7F, 7)
+		if event.splice_event_cancel_indicator == 1 {
+			continue
+		}
+		event.duration_flag = b2i(event.BreakDuration != nil)
+		w.WriteBit(event.out_of_network_indicator, 1)
+		w.WriteBit(event.program_splice_flag, 1)
+		w.WriteBit(event.duration_flag, 1)
+		w.WriteBit(0x1F, 5)
+		if event.program_splice_flag == 1 {
+			w.WriteBit64(event.utc_splice_time, 32)
+		} else {
+			event.component_count = len(event.Components)
+			w.WriteBit(event.component_count, 8)
+			for _, component := range event.Components {
+				w.WriteBit(component.component_tag, 8)
+				w.WriteBit64(component.utc_splice_time, 32)
+			}
+		}
+		if event.duration_flag == 1 {
+			event.BreakDuration.encode(w)
+		}
+		w.WriteBit(event.unique_program_id, 16)
+		w.WriteBit(event.avail_num, 8)
+		w.WriteBit(event.avails_expected, 8)
+	}
+}
+
+func (d *SpliceDescriptor) encode(w *Writer) {
+	body := NewWriter()
+	switch {
+	case d.AvailDescriptor != nil:
+		d.splice_descriptor_tag = 0x00
+		body.WriteBit64(d.AvailDescriptor.provider_avail_id, 32)
+	case d.DtmfDescriptor != nil:
+		d.splice_descriptor_tag = 0x01
+		dtmf := d.DtmfDescriptor
+		dtmf.dtmf_count = len(dtmf.DTMF_char)
+		body.WriteBit(dtmf.preroll, 8)
+		body.WriteBit(dtmf.dtmf_count, 3)
+		body.WriteBit(0x1F, 5)
+		body.WriteBytes([]byte(dtmf.DTMF_char))
+	case d.SegmentDescriptor != nil:
+		d.splice_descriptor_tag = 0x02
+		d.SegmentDescriptor.encode(body)
+	case d.TimeDescriptor != nil:
+		d.splice_descriptor_tag = 0x03
+		body.WriteBit64(d.TimeDescriptor.TAI_seconds, 48)
+		body.WriteBit64(d.TimeDescriptor.TAI_ns, 32)
+		body.WriteBit(d.TimeDescriptor.UTC_offset, 16)
+	case d.AudioDescriptor != nil:
+		d.splice_descriptor_tag = 0x04
+		audio := d.AudioDescriptor
+		audio.audio_count = len(audio.Components)
+		body.WriteBit(audio.audio_count, 4)
+		body.WriteBit(0xF, 4)
+		for _, c := range audio.Components {
+			body.WriteBit(c.component_tag, 8)
+			body.WriteBytes([]byte(c.ISO_code))
+			body.WriteBit(c.Bit_Stream_Mode, 3)
+			body.WriteBit(c.Num_Channels, 4)
+			body.WriteBit(c.Full_Srvc_Audio, 1)
+		}
+	default:
+		body.WriteBytes(d.private_byte)
+	}
+	if d.private_byte == nil && d.identifier == 0 {
+		d.identifier = CueIdentifier
+	}
+	d.descriptor_length = 4 + body.Len()
+	w.WriteBit(d.splice_descriptor_tag, 8)
+	w.WriteBit(d.descriptor_length, 8)
+	w.WriteBit(d.identifier, 32)
+	w.WriteBytes(body.Bytes())
+}
+
+func (segment *SegmentDescriptor) encode(w *Writer) {
+	w.WriteBit(segment.segmentation_event_id, 32)
+	w.WriteBit(segment.segmentation_event_cancel_indicator, 1)
+	w.WriteBit(0x7F, 7)
+	if segment.segmentation_event_cancel_indicator == 1 {
+		return
+	}
+	w.WriteBit(segment.program_segmentation_flag, 1)
+	w.WriteBit(segment.segmentation_duration_flag, 1)
+	w.WriteBit(segment.delivery_not_restricted_flag, 1)
+	if segment.delivery_not_restricted_flag == 0 {
+		w.WriteBit(segment.web_delivery_allowed_flag, 1)
+		w.WriteBit(segment.no_regional_blackout_flag, 1)
+		w.WriteBit(segment.archive_allowed_flag, 1)
+		w.WriteBit(segment.device_restrictions, 2)
+	} else {
+		w.WriteBit(0x1F, 5)
+	}
+	if segment.program_segmentation_flag == 0 {
+		segment.component_count = len(segment.Components)
+		w.WriteBit(segment.component_count, 8)
+		for _, c := range segment.Components {
+			w.WriteBit(c.component_tag, 8)
+			w.WriteBit(0x7F, 7)
+			w.WriteBit64(c.pts_offset, 33)
+		}
+	}
+	if segment.segmentation_duration_flag == 1 {
+		w.WriteBit64(segment.segmentation_duration, 40)
+	}
+	var upid []byte
+	if segment.Upid != nil {
+		segment.segmentation_upid_type = segment.Upid.segmentation_upid_type
+		upid = segment.Upid.upid
+	}
+	segment.segmentation_upid_length = len(upid)
+	w.WriteBit(segment.segmentation_upid_type, 8)
+	w.WriteBit(segment.segmentation_upid_length, 8)
+	w.WriteBytes(upid)
+	w.WriteBit(segment.segmentation_type_id, 8)
+	w.WriteBit(segment.segment_num, 8)
+	w.WriteBit(segment.segments_expected, 8)
+	if segment.sub_segment_present {
+		w.WriteBit(segment.sub_segment_num, 8)
+		w.WriteBit(segment.sub_segments_expected, 8)
+	}
+}
@@ -0,0 +1,251 @@
+package mpts
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func newTestSection(command interface{}, descriptors ...SpliceDescriptor) *SpliceInfoSection {
+	section := NewSpliceInfoSection()
+	section.SetPtsAdjustment(0x1FFFFFF00)
+	section.SetTier(0x123)
+	section.SetCommand(command)
+	section.SpliceDescriptorList = descriptors
+	return section
+}
+
+func segmentationDescriptor(segment *SegmentDescriptor) SpliceDescriptor {
+	return SpliceDescriptor{SegmentDescriptor: segment}
+}
+
+// upidDescriptor returns a Program Start segmentation_descriptor carrying a
+// segmentation_upid of the given type.
+func upidDescriptor(upidType int, data []byte) SpliceDescriptor {
+	segment := NewSegmentDescriptor(0x48000000+upidType, 0x10, 0, ParseSegmentationUpid(upidType, data))
+	segment.SetSegmentNum(1, 1)
+	return segmentationDescriptor(segment)
+}
+
+func TestSpliceInfoSectionRoundTrip(t *testing.T) {
+	program := NewSpliceInsert(1, true, 900000, 30*90000)
+	program.SetAvail(0x1234, 1, 2)
+
+	component := &SpliceInsert{splice_event_id: 3, out_of_network_indicator: 1}
+	component.Components = []SpliceInsertComponent{
+		{component_tag: 1, SpliceTime: NewSpliceTime(1000)},
+		{component_tag: 2, SpliceTime: NewSpliceTime(-1)},
+	}
+	component.BreakDuration = NewBreakDuration(60*90000, false)
+
+	untimed := &SpliceInsert{splice_event_id: 4, out_of_network_indicator: 1}
+	untimed.Components = []SpliceInsertComponent{{component_tag: 1}, {component_tag: 2}}
+
+	schedule := &SpliceSchedule{Events: []SpliceScheduleEvent{
+		{
+			splice_event_id:          10,
+			out_of_network_indicator: 1,
+			program_splice_flag:      1,
+			utc_splice_time:          1300000000,
+			BreakDuration:            NewBreakDuration(30*90000, true),
+			unique_program_id:        7,
+		},
+		{
+			splice_event_id: 11,
+			Components: []SpliceScheduleComponent{
+				{component_tag: 1, utc_splice_time: 1300000100},
+				{component_tag: 2, utc_splice_time: 1300000200},
+			},
+			avail_num:       1,
+			avails_expected: 2,
+		},
+		{splice_event_id: 12, splice_event_cancel_indicator: 1},
+	}}
+
+	restricted := NewSegmentDescriptor(20, 0x34, 15*90000, ParseSegmentationUpid(0x09, []byte("SIGNAL:ABC")))
+	restricted.SetDeliveryRestrictions(true, false, true, 2)
+	restricted.SetSegmentNum(1, 2)
+	restricted.SetSubSegmentNum(1, 3)
+
+	components := NewSegmentDescriptor(21, 0x22, 0, nil)
+	components.program_segmentation_flag = 0
+	components.Components = []SegmentComponent{{1, 0}, {2, 0x1FFFFFFFF}}
+
+	mid := []byte{0x09, 4, 'A', 'B', 'C', 'D', 0x0C, 6, 'C', 'U', 'E', 'I', 1, 2}
+
+	tests := []struct {
+		name    string
+		section *SpliceInfoSection
+	}{
+		{"splice_null", newTestSection(&SpliceNull{})},
+		{"splice_schedule", newTestSection(schedule)},
+		{"splice_insert program", newTestSection(program)},
+		{"splice_insert immediate", newTestSection(NewSpliceInsert(2, false, -1, 0))},
+		{"splice_insert components", newTestSection(component)},
+		{"splice_insert components without splice_time", newTestSection(untimed)},
+		{"splice_insert cancel", newTestSection(NewSpliceCancel(4))},
+		{"time_signal", newTestSection(NewTimeSignal(0x1FFFFFFFF))},
+		{"time_signal immediate", newTestSection(NewTimeSignal(-1))},
+		{"bandwidth_reservation", newTestSection(&BandwidthReservation{})},
+		{"private_command", newTestSection(NewPrivateCommand(0x54455354, []byte{1, 2, 3}))},
+		{"private_command empty", newTestSection(NewPrivateCommand(0x54455354, nil))},
+
+		{"avail_descriptor", newTestSection(&SpliceNull{},
+			SpliceDescriptor{AvailDescriptor: &AvailDescriptor{provider_avail_id: 0x12345678}})},
+		{"DTMF_descriptor", newTestSection(&SpliceNull{},
+			SpliceDescriptor{DtmfDescriptor: &DtmfDescriptor{preroll: 50, DTMF_char: "1234*#"}})},
+		{"segmentation_descriptor restricted", newTestSection(NewTimeSignal(1000),
+			segmentationDescriptor(restricted))},
+		{"segmentation_descriptor components", newTestSection(NewTimeSignal(1000),
+			segmentationDescriptor(components))},
+		{"segmentation_descriptor cancel", newTestSection(NewTimeSignal(-1),
+			segmentationDescriptor(NewSegmentCancel(22)))},
+		{"time_descriptor", newTestSection(&SpliceNull{},
+			SpliceDescriptor{TimeDescriptor: &TimeDescriptor{TAI_seconds: 0x123456789A, TAI_ns: 500, UTC_offset: 37}})},
+		{"audio_descriptor", newTestSection(&SpliceNull{},
+			SpliceDescriptor{AudioDescriptor: &AudioDescriptor{Components: []AudioComponent{
+				{component_tag: 1, ISO_code: "eng", Bit_Stream_Mode: 0, Num_Channels: 5, Full_Srvc_Audio: 1},
+				{component_tag: 2, ISO_code: "spa", Bit_Stream_Mode: 2, Num_Channels: 2},
+			}}})},
+		{"private descriptor", newTestSection(&SpliceNull{},
+			SpliceDescriptor{splice_descriptor_tag: 0xF0, identifier: 0x54455354, private_byte: []byte{9, 8, 7}})},
+		{"several descriptors", newTestSection(NewSpliceInsert(5, true, 1000, 0),
+			SpliceDescriptor{AvailDescriptor: &AvailDescriptor{provider_avail_id: 1}},
+			upidDescriptor(0x03, []byte("ABCD0001000H")),
+			segmentationDescriptor(restricted))},
+
+		{"UPID not used", newTestSection(NewTimeSignal(1000), upidDescriptor(0x00, nil))},
+		{"UPID user defined", newTestSection(NewTimeSignal(1000), upidDescriptor(0x01, []byte("user")))},
+		{"UPID ISCI", newTestSection(NewTimeSignal(1000), upidDescriptor(0x02, []byte("ABCD1234")))},
+		{"UPID Ad-ID", newTestSection(NewTimeSignal(1000), upidDescriptor(0x03, []byte("ABCD0001000H")))},
+		{"UPID UMID", newTestSection(NewTimeSignal(1000), upidDescriptor(0x04, bytes.Repeat([]byte{0x06, 0x0A}, 16)))},
+		{"UPID ISAN deprecated", newTestSection(NewTimeSignal(1000), upidDescriptor(0x05, []byte{0, 0, 0, 0x3A, 0x8D, 0x00, 0x00, 0x01}))},
+		{"UPID ISAN", newTestSection(NewTimeSignal(1000), upidDescriptor(0x06, []byte{0, 0, 0, 0x3A, 0x8D, 0, 0, 0x01, 0, 0, 0, 0x02}))},
+		{"UPID TID", newTestSection(NewTimeSignal(1000), upidDescriptor(0x07, []byte("MV0004146400")))},
+		{"UPID TI", newTestSection(NewTimeSignal(1000), upidDescriptor(0x08, []byte{1, 2, 3, 4, 5, 6, 7, 8}))},
+		{"UPID ADI", newTestSection(NewTimeSignal(1000), upidDescriptor(0x09, []byte("PREVIEW:001")))},
+		{"UPID EIDR", newTestSection(NewTimeSignal(1000), upidDescriptor(0x0A, []byte{0x14, 0x8D, 0x12, 0x34, 0x56, 0x78, 0x9A, 0xBC, 0xDE, 0xF0, 0x12, 0x34}))},
+		{"UPID ATSC", newTestSection(NewTimeSignal(1000), upidDescriptor(0x0B, []byte{0x12, 0x34, 0xC5, 0x0F, 'i', 'd'}))},
+		{"UPID MPU", newTestSection(NewTimeSignal(1000), upidDescriptor(0x0C, []byte{'C', 'U', 'E', 'I', 1, 2, 3}))},
+		{"UPID MID", newTestSection(NewTimeSignal(1000), upidDescriptor(0x0D, mid))},
+		{"UPID ADS", newTestSection(NewTimeSignal(1000), upidDescriptor(0x0E, []byte("ads=1")))},
+		{"UPID URI", newTestSection(NewTimeSignal(1000), upidDescriptor(0x0F, []byte("urn:uuid:f81d4fae-7dec-11d0-a765-00a0c91e6bf6")))},
+		{"UPID UUID", newTestSection(NewTimeSignal(1000), upidDescriptor(0x10, bytes.Repeat([]byte{0xF8, 0x1D}, 8)))},
+		{"UPID SCR", newTestSection(NewTimeSignal(1000), upidDescriptor(0x11, []byte("scr")))},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			checkRoundTrip(t, test.section)
+		})
+	}
+}
+
+func TestEncryptedSpliceInfoSectionRoundTrip(t *testing.T) {
+	keys := map[int][]byte{
+		1: []byte("\x01\x23\x45\x67\x89\xAB\xCD\xEF"),
+		2: []byte("\xFE\xDC\xBA\x98\x76\x54\x32\x10"),
+		3: []byte("0123456789abcdefghijklmn"),
+	}
+	for index, key := range keys {
+		ControlWords[index] = key
+		defer delete(ControlWords, index)
+	}
+
+	tests := []struct {
+		name      string
+		algorithm int
+	}{
+		{"DES-ECB", 1},
+		{"DES-CBC", 2},
+		{"3DES-ECB", 3},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			insert := NewSpliceInsert(100, true, 900000, 30*90000)
+			section := newTestSection(insert, upidDescriptor(0x09, []byte("SIGNAL:XYZ")))
+			section.SetEncryption(test.algorithm, test.algorithm)
+			if section.IsDecrypted() {
+				t.Error("decrypted before it was encrypted")
+			}
+			decoded := checkRoundTrip(t, section)
+			if !decoded.IsDecrypted() || !decoded.CheckECrc() {
+				t.Errorf("not decrypted, E_CRC_32 %#x", decoded.E_CRC_32)
+			}
+			if bytes.Equal(section.encrypted, section.decrypted) {
+				t.Error("encrypted bytes equal the plaintext")
+			}
+
+			// Without the control word the section stays encrypted and is
+			// copied as is
+			key := ControlWords[test.algorithm]
+			delete(ControlWords, test.algorithm)
+			defer func() { ControlWords[test.algorithm] = key }()
+			opaque := DecodeSpliceInfoSection(section.Bytes())
+			if opaque.IsDecrypted() || opaque.GetSpliceType() != "encrypted" {
+				t.Errorf("decoded without control word as %s", opaque.GetSpliceType())
+			}
+			if data, err := opaque.Encode(); err != nil || !bytes.Equal(data, section.Bytes()) {
+				t.Errorf("encrypted section not copied as is: %v", err)
+			}
+
+			// Nor can a decrypted one be encrypted again
+			if _, err := decoded.Encode(); err == nil {
+				t.Error("encoded without control word")
+			}
+		})
+	}
+}
+
+func TestEncryptErrors(t *testing.T) {
+	ControlWords[1] = []byte("0123456789abcdefghijklmn")
+	defer delete(ControlWords, 1)
+
+	tests := []struct {
+		name      string
+		algorithm int
+		cwIndex   int
+	}{
+		{"missing control word", 1, 2},
+		{"DES with a triple DES key", 1, 1},
+		{"user private algorithm", 32, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			section := newTestSection(NewTimeSignal(1000))
+			section.SetEncryption(test.algorithm, test.cwIndex)
+			if data, err := section.Encode(); err == nil {
+				t.Errorf("encoded %x", data)
+			}
+		})
+	}
+}
+
+// checkRoundTrip encodes section, decodes it, and checks that both have the
+// same fields and encode to the same bytes.
+func checkRoundTrip(t *testing.T, section *SpliceInfoSection) *SpliceInfoSection {
+	t.Helper()
+	encoded, err := section.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded = append([]byte{}, encoded...)
+	decoded := DecodeSpliceInfoSection(encoded)
+	if !decoded.CheckCrc() {
+		t.Errorf("CRC_32 %#x does not match", decoded.CRC_32)
+	}
+	want, err := json.Marshal(section)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := json.Marshal(decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("decoded\n%s\nwant\n%s", got, want)
+	}
+	if again, err := decoded.Encode(); err != nil || !bytes.Equal(again, encoded) {
+		t.Errorf("encoded again\n%x\nwant\n%x %v", again, encoded, err)
+	}
+	return decoded
+}
@@ -0,0 +1,395 @@
+package mpts
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// SCTE-35 Table 23
+var SegmentationTypeString map[int]string = map[int]string{
+	0x00: "Not Indicated",
+	0x01: "Content Identification",
+	0x10: "Program Start",
+	0x11: "Program End",
+	0x12: "Program Early Termination",
+	0x13: "Program Breakaway",
+	0x14: "Program Resumption",
+	0x15: "Program Runover Planned",
+	0x16: "Program Runover Unplanned",
+	0x17: "Program Overlap Start",
+	0x18: "Program Blackout Override",
+	0x19: "Program Join",
+	0x20: "Chapter Start",
+	0x21: "Chapter End",
+	0x22: "Break Start",
+	0x23: "Break End",
+	0x24: "Opening Credit Start",
+	0x25: "Opening Credit End",
+	0x26: "Closing Credit Start",
+	0x27: "Closing Credit End",
+	0x30: "Provider Advertisement Start",
+	0x31: "Provider Advertisement End",
+	0x32: "Distributor Advertisement Start",
+	0x33: "Distributor Advertisement End",
+	0x34: "Provider Placement Opportunity Start",
+	0x35: "Provider Placement Opportunity End",
+	0x36: "Distributor Placement Opportunity Start",
+	0x37: "Distributor Placement Opportunity End",
+	0x38: "Provider Overlay Placement Opportunity Start",
+	0x39: "Provider Overlay Placement Opportunity End",
+	0x3A: "Distributor Overlay Placement Opportunity Start",
+	0x3B: "Distributor Overlay Placement Opportunity End",
+	0x3C: "Provider Promo Start",
+	0x3D: "Provider Promo End",
+	0x3E: "Distributor Promo Start",
+	0x3F: "Distributor Promo End",
+	0x40: "Unscheduled Event Start",
+	0x41: "Unscheduled Event End",
+	0x42: "Alternate Content Opportunity Start",
+	0x43: "Alternate Content Opportunity End",
+	0x44: "Provider Ad Block Start",
+	0x45: "Provider Ad Block End",
+	0x46: "Distributor Ad Block Start",
+	0x47: "Distributor Ad Block End",
+	0x50: "Network Start",
+	0x51: "Network End",
+}
+
+// IsSegmentStart reports whether segType opens a segment closed by the
+// type that follows it.
+func IsSegmentStart(segType int) bool {
+	if segType < 0x10 || segType%2 != 0 {
+		return false
+	}
+	_, start := SegmentationTypeString[segType]
+	_, end := SegmentationTypeString[segType+1]
+	return start && end && segType != 0x12 && segType != 0x14 && segType != 0x16 && segType != 0x18
+}
+
+// IsSegmentEnd reports whether segType closes the segment opened by
+// segType-1.
+func IsSegmentEnd(segType int) bool {
+	return segType%2 == 1 && IsSegmentStart(segType-1)
+}
+
+const (
+	BreakSpliceInsert = "splice_insert"
+	BreakSegmentation = "segmentation"
+	BreakOk           = "ok"
+	BreakAutoReturn   = "auto_return"
+	BreakCancelled    = "cancelled"
+	BreakDuplicate    = "duplicate"
+	BreakOrphanOut    = "orphan_out"
+	BreakOrphanIn     = "orphan_in"
+	BreakOrphanCancel = "orphan_cancel"
+	ptsMask           = 0x1FFFFFFFF
+	noTime            = -1
+)
+
+// SpliceBreak is an out/in pair of splice_insert commands, or a start/end
+// pair of segmentation_descriptors, sharing an event id. Times are in 90kHz
+// with pts_adjustment applied, and -1 when unknown.
+type SpliceBreak struct {
+	Kind       string
+	EventId    int64
+	SegType    int
+	OutPos     int64
+	InPos      int64
+	OutPts     int64
+	InPts      int64
+	Signalled  int64
+	AutoReturn bool
+	Repeats    int
+	Overlap    bool
+	Status     string
+}
+
+// Actual returns the duration between the out and in points, or -1.
+func (b *SpliceBreak) Actual() int64 {
+	if b.OutPts == noTime || b.InPts == noTime {
+		return noTime
+	}
+	return (b.InPts - b.OutPts) & ptsMask
+}
+
+// end returns when the break returns by itself, or -1.
+func (b *SpliceBreak) end() int64 {
+	if !b.AutoReturn || b.OutPts == noTime || b.Signalled < 0 {
+		return noTime
+	}
+	return (b.OutPts + b.Signalled) & ptsMask
+}
+
+// SpliceEventTracker pairs splice events in arrival order.
+type SpliceEventTracker struct {
+	Breaks []*SpliceBreak
+	open   map[string]*SpliceBreak
+}
+
+func NewSpliceEventTracker() *SpliceEventTracker {
+	return &SpliceEventTracker{open: make(map[string]*SpliceBreak)}
+}
+
+func breakKey(kind string, id int64, segType int) string {
+	return kind + ":" + strconv.FormatInt(id, 10) + ":" + strconv.Itoa(segType)
+}
+
+func adjustPts(pts, adj int64) int64 {
+	if pts < 0 {
+		return noTime
+	}
+	return (pts + adj) & ptsMask
+}
+
+// before reports whether a precedes b, allowing for PTS wrap.
+func before(a, b int64) bool {
+	return a != b && (b-a)&ptsMask < ptsMask/2
+}
+
+// Add feeds one section received at pos to the tracker.
+func (t *SpliceEventTracker) Add(pos int64, section *SpliceInfoSection) {
+	if section.opaque() {
+		return
+	}
+	pts, adj := section.GetSpliceTime()
+	pts = adjustPts(pts, adj)
+	t.expire(pts)
+
+	if section.SpliceInsert != nil {
+		t.addInsert(pos, pts, section.SpliceInsert)
+	}
+	for _, segment := range section.SegmentDescriptors() {
+		t.addSegment(pos, pts, segment)
+	}
+}
+
+// expire closes the auto-return breaks that ended before pts. Their in
+// point stays unknown until a splice-in arrives for them.
+func (t *SpliceEventTracker) expire(pts int64) {
+	if pts == noTime {
+		return
+	}
+	for key, b := range t.open {
+		if end := b.end(); end != noTime && before(end, pts) {
+			b.InPts = end
+			b.Status = BreakAutoReturn
+			delete(t.open, key)
+		}
+	}
+}
+
+// overlaps marks b if another break of its kind is still open. Segments of
+// different types may nest, e.g. a placement opportunity within a Break
+// Start, so only segments of the same type overlap; a Program Overlap Start
+// is meant to begin before the previous program ends.
+func (t *SpliceEventTracker) overlaps(b *SpliceBreak) {
+	for _, o := range t.open {
+		if o.Kind != b.Kind || o == b {
+			continue
+		}
+		if b.Kind == BreakSegmentation && (o.SegType != b.SegType || b.SegType == 0x17) {
+			continue
+		}
+		o.Overlap = true
+		b.Overlap = true
+	}
+}
+
+func (t *SpliceEventTracker) openBreak(key string, b *SpliceBreak) {
+	if o, ok := t.open[key]; ok {
+		// Identical cues are repeated for robustness
+		if o.OutPts == b.OutPts && o.Signalled == b.Signalled {
+			o.Repeats += 1
+			return
+		}
+		o.Status = BreakDuplicate
+		delete(t.open, key)
+	}
+	t.overlaps(b)
+	t.open[key] = b
+	t.Breaks = append(t.Breaks, b)
+}
+
+func (t *SpliceEventTracker) addInsert(pos int64, pts int64, insert *SpliceInsert) {
+	id := insert.splice_event_id
+	key := breakKey(BreakSpliceInsert, id, -1)
+	b, opened := t.open[key]
+
+	if insert.splice_event_cancel_indicator == 1 {
+		if opened {
+			b.InPos = pos
+			b.Status = BreakCancelled
+			delete(t.open, key)
+		} else {
+			t.Breaks = append(t.Breaks, &SpliceBreak{
+				Kind: BreakSpliceInsert, EventId: id, SegType: -1,
+				OutPos: -1, InPos: pos, OutPts: noTime, InPts: noTime,
+				Signalled: -1, Status: BreakOrphanCancel,
+			})
+		}
+		return
+	}
+
+	if insert.out_of_network_indicator == 1 {
+		b := &SpliceBreak{
+			Kind: BreakSpliceInsert, EventId: id, SegType: -1,
+			OutPos: pos, InPos: -1, OutPts: pts, InPts: noTime,
+			Signalled: insert.GetSpliceDuration(),
+		}
+		if insert.BreakDuration != nil {
+			b.AutoReturn = insert.BreakDuration.auto_return == 1
+		}
+		t.openBreak(key, b)
+		return
+	}
+
+	if opened {
+		if b.InPos != -1 && b.InPts == pts {
+			b.Repeats += 1
+			return
+		}
+		b.InPos = pos
+		b.InPts = pts
+		b.Status = BreakOk
+		delete(t.open, key)
+	} else if !t.repeatedIn(BreakSpliceInsert, id, pos, pts) {
+		t.Breaks = append(t.Breaks, &SpliceBreak{
+			Kind: BreakSpliceInsert, EventId: id, SegType: -1,
+			OutPos: -1, InPos: pos, OutPts: noTime, InPts: pts,
+			Signalled: -1, Status: BreakOrphanIn,
+		})
+	}
+}
+
+// repeatedIn reports whether an in point belongs to the last closed break
+// with the same id, either repeating its in point or arriving after it
+// returned automatically.
+func (t *SpliceEventTracker) repeatedIn(kind string, id int64, pos int64, pts int64) bool {
+	for i := len(t.Breaks) - 1; i >= 0; i-- {
+		b := t.Breaks[i]
+		if b.Kind == kind && b.EventId == id {
+			if b.InPos != -1 && b.InPts == pts {
+				b.Repeats += 1
+				return true
+			}
+			if b.Status == BreakAutoReturn && b.InPos == -1 {
+				b.InPos = pos
+				b.InPts = pts
+				return true
+			}
+			return false
+		}
+	}
+	return false
+}
+
+func (t *SpliceEventTracker) addSegment(pos int64, pts int64, segment *SegmentDescriptor) {
+	id := int64(segment.segmentation_event_id)
+
+	if segment.segmentation_event_cancel_indicator == 1 {
+		cancelled := false
+		for key, b := range t.open {
+			if b.Kind == BreakSegmentation && b.EventId == id {
+				b.InPos = pos
+				b.Status = BreakCancelled
+				delete(t.open, key)
+				cancelled = true
+			}
+		}
+		if !cancelled {
+			t.Breaks = append(t.Breaks, &SpliceBreak{
+				Kind: BreakSegmentation, EventId: id, SegType: -1,
+				OutPos: -1, InPos: pos, OutPts: noTime, InPts: noTime,
+				Signalled: -1, Status: BreakOrphanCancel,
+			})
+		}
+		return
+	}
+
+	segType := segment.segmentation_type_id
+	if IsSegmentStart(segType) || segType == 0x17 {
+		// A Program Overlap Start is ended by a Program End
+		start := segType
+		if segType == 0x17 {
+			start = 0x10
+		}
+		t.openBreak(breakKey(BreakSegmentation, id, start), &SpliceBreak{
+			Kind: BreakSegmentation, EventId: id, SegType: segType,
+			OutPos: pos, InPos: -1, OutPts: pts, InPts: noTime,
+			Signalled: segment.GetSpliceDuration(),
+		})
+	} else if IsSegmentEnd(segType) || segType == 0x12 {
+		// A Program Early Termination ends a Program Start
+		start := segType - 1
+		if segType == 0x12 {
+			start = 0x10
+		}
+		key := breakKey(BreakSegmentation, id, start)
+		if b, ok := t.open[key]; ok {
+			b.InPos = pos
+			b.InPts = pts
+			b.Status = BreakOk
+			delete(t.open, key)
+		} else if !t.repeatedIn(BreakSegmentation, id, pos, pts) {
+			t.Breaks = append(t.Breaks, &SpliceBreak{
+				Kind: BreakSegmentation, EventId: id, SegType: segType,
+				OutPos: -1, InPos: pos, OutPts: noTime, InPts: pts,
+				Signalled: -1, Status: BreakOrphanIn,
+			})
+		}
+	}
+}
+
+// Close ends the tracking, returning auto-return breaks and marking the
+// rest of the open breaks as orphans.
+func (t *SpliceEventTracker) Close() {
+	for key, b := range t.open {
+		if end := b.end(); end != noTime {
+			b.InPts = end
+			b.Status = BreakAutoReturn
+		} else {
+			b.Status = BreakOrphanOut
+		}
+		delete(t.open, key)
+	}
+}
+
+func (s *Scte35Record) reportBreaks(root string) {
+	tracker := NewSpliceEventTracker()
+	for i, section := range s.Sections {
+		tracker.Add(s.BytePos[i], section)
+	}
+	tracker.Close()
+	if len(tracker.Breaks) == 0 {
+		return
+	}
+
+	fname := filepath.Join(root, strconv.Itoa(s.Pid)+"-breaks.csv")
+	w, err := os.Create(fname)
+	if err != nil {
+		panic(err)
+	}
+	defer w.Close()
+
+	fmt.Fprintln(w, "Kind, EventId, SegType, OutPos, InPos, OutPts, InPts, Signalled, Actual, Repeats, Overlap, Status")
+	for _, b := range tracker.Breaks {
+		cols := []string{
+			b.Kind,
+			strconv.FormatInt(b.EventId, 10),
+			strconv.Itoa(b.SegType),
+			strconv.FormatInt(b.OutPos, 10),
+			strconv.FormatInt(b.InPos, 10),
+			strconv.FormatInt(b.OutPts, 10),
+			strconv.FormatInt(b.InPts, 10),
+			strconv.FormatInt(b.Signalled, 10),
+			strconv.FormatInt(b.Actual(), 10),
+			strconv.Itoa(b.Repeats),
+			strconv.FormatBool(b.Overlap),
+			b.Status,
+		}
+		fmt.Fprintln(w, strings.Join(cols, ", "))
+	}
+}
@@ -0,0 +1,65 @@
+package mpts
+
+import "testing"
+
+func TestSpliceEventTrackerSegments(t *testing.T) {
+	segment := func(id int, segType int, pts int64) *SpliceInfoSection {
+		section := NewSpliceInfoSection()
+		section.SetCommand(NewTimeSignal(pts))
+		section.AddSegmentDescriptor(NewSegmentDescriptor(id, segType, 0, nil))
+		return section
+	}
+
+	tests := []struct {
+		name     string
+		sections []*SpliceInfoSection
+		want     []SpliceBreak
+	}{
+		{
+			"Program Start and End",
+			[]*SpliceInfoSection{segment(1, 0x10, 1000), segment(1, 0x11, 5000)},
+			[]SpliceBreak{{SegType: 0x10, OutPts: 1000, InPts: 5000, Status: BreakOk}},
+		},
+		{
+			"Program Start and Early Termination",
+			[]*SpliceInfoSection{segment(1, 0x10, 1000), segment(1, 0x12, 5000)},
+			[]SpliceBreak{{SegType: 0x10, OutPts: 1000, InPts: 5000, Status: BreakOk}},
+		},
+		{
+			"Program Overlap Start and End",
+			[]*SpliceInfoSection{segment(1, 0x10, 1000), segment(2, 0x17, 4000), segment(1, 0x11, 5000), segment(2, 0x11, 9000)},
+			[]SpliceBreak{
+				{SegType: 0x10, OutPts: 1000, InPts: 5000, Status: BreakOk},
+				{SegType: 0x17, OutPts: 4000, InPts: 9000, Status: BreakOk},
+			},
+		},
+		{
+			"Program Start left open",
+			[]*SpliceInfoSection{segment(1, 0x10, 1000), segment(1, 0x13, 5000)},
+			[]SpliceBreak{{SegType: 0x10, OutPts: 1000, InPts: noTime, Status: BreakOrphanOut}},
+		},
+		{
+			"Early Termination with no start",
+			[]*SpliceInfoSection{segment(1, 0x12, 5000)},
+			[]SpliceBreak{{SegType: 0x12, OutPts: noTime, InPts: 5000, Status: BreakOrphanIn}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tracker := NewSpliceEventTracker()
+			for i, section := range test.sections {
+				tracker.Add(int64(i), section)
+			}
+			tracker.Close()
+			if len(tracker.Breaks) != len(test.want) {
+				t.Fatalf("%d breaks, want %d", len(tracker.Breaks), len(test.want))
+			}
+			for i, want := range test.want {
+				b := tracker.Breaks[i]
+				if b.SegType != want.SegType || b.OutPts != want.OutPts || b.InPts != want.InPts || b.Status != want.Status {
+					t.Errorf("break %d: %+v, want %+v", i, *b, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,697 @@
+package mpts
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	// DASH EventStream schemes for SCTE-35 in XML and in base64 binary
+	Scte35XmlScheme = "urn:scte:scte35:2013:xml"
+	Scte35BinScheme = "urn:scte:scte35:2014:xml+bin"
+	// Namespace of the SCTE-35 XML schema
+	Scte35XmlNamespace = "http://www.scte.org/schemas/35/2016"
+)
+
+// Base64 returns the section in the form used by HLS and DASH attributes.
+func (section SpliceInfoSection) Base64() string {
+	return base64.StdEncoding.EncodeToString(section.raw)
+}
+
+// CheckCrc reports whether the CRC_32 matches the section bytes.
+func (section SpliceInfoSection) CheckCrc() bool {
+	return len(section.raw) >= 4 && Crc32(section.raw) == 0
+}
+
+// Dump writes the section as an indented list of its fields.
+func (section SpliceInfoSection) Dump(w io.Writer) {
+	fmt.Fprintln(w, "splice_info_section")
+	dumpObject(w, section.jsonObject(), 1)
+	if section.CheckCrc() {
+		fmt.Fprintln(w, "CRC_32 OK")
+	} else {
+		fmt.Fprintln(w, "CRC_32 mismatch")
+	}
+	if section.opaque() {
+		if _, ok := ControlWords[section.cw_index]; ok {
+			fmt.Fprintln(w, "Encrypted, decryption failed for cw_index", section.cw_index)
+		} else {
+			fmt.Fprintln(w, "Encrypted, no control word for cw_index", section.cw_index)
+		}
+	} else if section.IsEncrypted() {
+		fmt.Fprintln(w, "E_CRC_32 OK")
+	}
+}
+
+func dumpObject(w io.Writer, o jsonObject, depth int) {
+	indent := strings.Repeat("  ", depth)
+	for _, f := range o {
+		switch v := f.Value.(type) {
+		case jsonObject:
+			fmt.Fprintf(w, "%s%s\n", indent, f.Key)
+			dumpObject(w, v, depth+1)
+		case []jsonObject:
+			for i, e := range v {
+				fmt.Fprintf(w, "%s%s[%d]\n", indent, f.Key, i)
+				dumpObject(w, e, depth+1)
+			}
+		case int:
+			fmt.Fprintf(w, "%s%s: %d (0x%X)\n", indent, f.Key, v, v)
+		case int64:
+			fmt.Fprintf(w, "%s%s: %d (0x%X)\n", indent, f.Key, v, v)
+		default:
+			fmt.Fprintf(w, "%s%s: %v\n", indent, f.Key, v)
+		}
+	}
+}
+
+// jsonObject is a JSON object that keeps its keys in insertion order.
+type jsonObject []jsonField
+
+type jsonField struct {
+	Key   string
+	Value interface{}
+}
+
+func (o *jsonObject) Add(key string, value interface{}) {
+	*o = append(*o, jsonField{key, value})
+}
+
+func (o jsonObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, f := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, _ := json.Marshal(f.Key)
+		v, err := json.Marshal(f.Value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// MarshalJSON writes the section as an object using the field names of the
+// SCTE-35 syntax tables.
+func (section SpliceInfoSection) MarshalJSON() ([]byte, error) {
+	return json.Marshal(section.jsonObject())
+}
+
+func (section SpliceInfoSection) jsonObject() jsonObject {
+	o := jsonObject{}
+	o.Add("table_id", section.table_id)
+	o.Add("section_syntax_indicator", section.section_syntax_indicator)
+	o.Add("private_indicator", section.private_indicator)
+	o.Add("sap_type", section.reserved)
+	o.Add("section_length", section.section_length)
+	o.Add("protocol_version", section.protocol_version)
+	o.Add("encrypted_packet", section.encrypted_packet)
+	o.Add("encryption_algorithm", section.encryption_algorithm)
+	o.Add("pts_adjustment", section.pts_adjustment)
+	o.Add("cw_index", section.cw_index)
+	o.Add("tier", section.tier)
+	o.Add("splice_command_length", section.splice_command_length)
+	if section.opaque() {
+		o.Add("encryption_algorithm_name", section.GetEncryptionAlgorithm())
+		o.Add("encrypted_bytes", hex.EncodeToString(section.encrypted))
+		o.Add("CRC_32", section.CRC_32)
+		o.Add("base64", section.Base64())
+		return o
+	}
+	o.Add("splice_command_type", section.splice_command_type)
+	o.Add("splice_command_name", section.GetSpliceType())
+	switch {
+	case section.SpliceNull != nil, section.BandwidthReservation != nil:
+		o.Add(section.GetSpliceType(), jsonObject{})
+	case section.SpliceSchedule != nil:
+		o.Add("splice_schedule", section.SpliceSchedule.jsonObject())
+	case section.SpliceInsert != nil:
+		o.Add("splice_insert", section.SpliceInsert.jsonObject())
+	case section.TimeSignal != nil:
+		o.Add("time_signal", jsonObject{{"splice_time", section.TimeSignal.SpliceTime.jsonObject()}})
+	case section.PrivateCommand != nil:
+		p := jsonObject{}
+		p.Add("identifier", section.PrivateCommand.GetIdentifier())
+		p.Add("private_byte", hex.EncodeToString(section.PrivateCommand.private_byte))
+		o.Add("private_command", p)
+	}
+	o.Add("descriptor_loop_length", section.descriptor_loop_length)
+	descriptors := []jsonObject{}
+	for _, d := range section.SpliceDescriptorList {
+		descriptors = append(descriptors, d.jsonObject())
+	}
+	o.Add("splice_descriptors", descriptors)
+	if section.IsEncrypted() {
+		o.Add("E_CRC_32", section.E_CRC_32)
+		o.Add("E_CRC_32_ok", section.CheckECrc())
+	}
+	o.Add("CRC_32", section.CRC_32)
+	o.Add("base64", section.Base64())
+	return o
+}
+
+func (t SpliceTime) jsonObject() jsonObject {
+	o := jsonObject{}
+	o.Add("time_specified_flag", t.time_specified_flag)
+	if t.time_specified_flag == 1 {
+		o.Add("pts_time", t.pts_time)
+	}
+	return o
+}
+
+func (d BreakDuration) jsonObject() jsonObject {
+	o := jsonObject{}
+	o.Add("auto_return", d.auto_return)
+	o.Add("duration", d.duration)
+	return o
+}
+
+func (insert SpliceInsert) jsonObject() jsonObject {
+	o := jsonObject{}
+	o.Add("splice_event_id", insert.splice_event_id)
+	o.Add("splice_event_cancel_indicator", insert.splice_event_cancel_indicator)
+	if insert.splice_event_cancel_indicator == 1 {
+		return o
+	}
+	o.Add("out_of_network_indicator", insert.out_of_network_indicator)
+	o.Add("program_splice_flag", insert.program_splice_flag)
+	o.Add("duration_flag", insert.duration_flag)
+	o.Add("splice_immediate_flag", insert.splice_immediate_flag)
+	if insert.program_splice_flag == 1 {
+		if insert.SpliceTime != nil {
+			o.Add("splice_time", insert.SpliceTime.jsonObject())
+		}
+	} else {
+		o.Add("component_count", insert.component_count)
+		components := []jsonObject{}
+		for _, c := range insert.Components {
+			co := jsonObject{}
+			co.Add("component_tag", c.component_tag)
+			if c.SpliceTime != nil {
+				co.Add("splice_time", c.SpliceTime.jsonObject())
+			}
+			components = append(components, co)
+		}
+		o.Add("components", components)
+	}
+	if insert.BreakDuration != nil {
+		o.Add("break_duration", insert.BreakDuration.jsonObject())
+	}
+	o.Add("unique_program_id", insert.unique_program_id)
+	o.Add("avail_num", insert.avail_num)
+	o.Add("avails_expected", insert.avails_expected)
+	return o
+}
+
+func (schedule SpliceSchedule) jsonObject() jsonObject {
+	o := jsonObject{}
+	o.Add("splice_count", schedule.splice_count)
+	events := []jsonObject{}
+	for _, event := range schedule.Events {
+		e := jsonObject{}
+		e.Add("splice_event_id", event.splice_event_id)
+		e.Add("splice_event_cancel_indicator", event.splice_event_cancel_indicator)
+		if event.splice_event_cancel_indicator == 0 {
+			e.Add("out_of_network_indicator", event.out_of_network_indicator)
+			e.Add("program_splice_flag", event.program_splice_flag)
+			e.Add("duration_flag", event.duration_flag)
+			if event.program_splice_flag == 1 {
+				e.Add("utc_splice_time", event.utc_splice_time)
+				e.Add("utc_splice_time_text", event.GetUTCSpliceTime())
+			} else {
+				e.Add("component_count", event.component_count)
+				components := []jsonObject{}
+				for _, c := range event.Components {
+					co := jsonObject{}
+					co.Add("component_tag", c.component_tag)
+					co.Add("utc_splice_time", c.utc_splice_time)
+					components = append(components, co)
+				}
+				e.Add("components", components)
+			}
+			if event.BreakDuration != nil {
+				e.Add("break_duration", event.BreakDuration.jsonObject())
+			}
+			e.Add("unique_program_id", event.unique_program_id)
+			e.Add("avail_num", event.avail_num)
+			e.Add("avails_expected", event.avails_expected)
+		}
+		events = append(events, e)
+	}
+	o.Add("events", events)
+	return o
+}
+
+func (d SpliceDescriptor) jsonObject() jsonObject {
+	o := jsonObject{}
+	o.Add("splice_descriptor_tag", d.splice_descriptor_tag)
+	o.Add("descriptor_length", d.descriptor_length)
+	o.Add("identifier", string([]byte{byte(d.identifier >> 24), byte(d.identifier >> 16), byte(d.identifier >> 8), byte(d.identifier)}))
+	switch {
+	case d.AvailDescriptor != nil:
+		o.Add("provider_avail_id", d.AvailDescriptor.provider_avail_id)
+	case d.DtmfDescriptor != nil:
+		o.Add("preroll", d.DtmfDescriptor.preroll)
+		o.Add("dtmf_count", d.DtmfDescriptor.dtmf_count)
+		o.Add("DTMF_char", d.DtmfDescriptor.DTMF_char)
+	case d.SegmentDescriptor != nil:
+		o = append(o, d.SegmentDescriptor.jsonObject()...)
+	case d.TimeDescriptor != nil:
+		o.Add("TAI_seconds", d.TimeDescriptor.TAI_seconds)
+		o.Add("TAI_ns", d.TimeDescriptor.TAI_ns)
+		o.Add("UTC_offset", d.TimeDescriptor.UTC_offset)
+	case d.AudioDescriptor != nil:
+		o.Add("audio_count", d.AudioDescriptor.audio_count)
+		components := []jsonObject{}
+		for _, c := range d.AudioDescriptor.Components {
+			co := jsonObject{}
+			co.Add("component_tag", c.component_tag)
+			co.Add("ISO_code", c.ISO_code)
+			co.Add("Bit_Stream_Mode", c.Bit_Stream_Mode)
+			co.Add("Num_Channels", c.Num_Channels)
+			co.Add("Full_Srvc_Audio", c.Full_Srvc_Audio)
+			components = append(components, co)
+		}
+		o.Add("components", components)
+	default:
+		o.Add("private_byte", hex.EncodeToString(d.private_byte))
+	}
+	return o
+}
+
+func (segment SegmentDescriptor) jsonObject() jsonObject {
+	o := jsonObject{}
+	o.Add("segmentation_event_id", segment.segmentation_event_id)
+	o.Add("segmentation_event_cancel_indicator", segment.segmentation_event_cancel_indicator)
+	if segment.segmentation_event_cancel_indicator == 1 {
+		return o
+	}
+	o.Add("program_segmentation_flag", segment.program_segmentation_flag)
+	o.Add("segmentation_duration_flag", segment.segmentation_duration_flag)
+	o.Add("delivery_not_restricted_flag", segment.delivery_not_restricted_flag)
+	if segment.delivery_not_restricted_flag == 0 {
+		o.Add("web_delivery_allowed_flag", segment.web_delivery_allowed_flag)
+		o.Add("no_regional_blackout_flag", segment.no_regional_blackout_flag)
+		o.Add("archive_allowed_flag", segment.archive_allowed_flag)
+		o.Add("device_restrictions", segment.device_restrictions)
+	}
+	if segment.program_segmentation_flag == 0 {
+		o.Add("component_count", segment.component_count)
+		components := []jsonObject{}
+		for _, c := range segment.Components {
+			co := jsonObject{}
+			co.Add("component_tag", c.component_tag)
+			co.Add("pts_offset", c.pts_offset)
+			components = append(components, co)
+		}
+		o.Add("components", components)
+	}
+	if segment.segmentation_duration_flag == 1 {
+		o.Add("segmentation_duration", segment.segmentation_duration)
+	}
+	o.Add("segmentation_upid_type", segment.segmentation_upid_type)
+	o.Add("segmentation_upid_length", segment.segmentation_upid_length)
+	if segment.Upid != nil {
+		o.Add("segmentation_upid", segment.Upid.jsonObject())
+	}
+	o.Add("segmentation_type_id", segment.segmentation_type_id)
+	o.Add("segment_num", segment.segment_num)
+	o.Add("segments_expected", segment.segments_expected)
+	if segment.sub_segment_present {
+		o.Add("sub_segment_num", segment.sub_segment_num)
+		o.Add("sub_segments_expected", segment.sub_segments_expected)
+	}
+	return o
+}
+
+func (upid SegmentationUpid) jsonObject() jsonObject {
+	o := jsonObject{}
+	o.Add("type", upid.GetTypeName())
+	o.Add("value", upid.String())
+	if upid.segmentation_upid_type == 0x0D {
+		upids := []jsonObject{}
+		for _, u := range upid.Upids {
+			uo := jsonObject{}
+			uo.Add("segmentation_upid_type", u.segmentation_upid_type)
+			uo.Add("segmentation_upid_length", u.segmentation_upid_length)
+			uo.Add("segmentation_upid", u.jsonObject())
+			upids = append(upids, uo)
+		}
+		o.Add("upids", upids)
+	}
+	return o
+}
+
+// Types mirroring the SCTE-35 XML schema
+
+type xmlSpliceInfoSection struct {
+	XMLName                xml.Name               `xml:"SpliceInfoSection"`
+	Xmlns                  string                 `xml:"xmlns,attr"`
+	PtsAdjustment          int64                  `xml:"ptsAdjustment,attr"`
+	ProtocolVersion        int                    `xml:"protocolVersion,attr"`
+	SapType                int                    `xml:"sapType,attr"`
+	Tier                   int                    `xml:"tier,attr"`
+	EncryptedPacket        *xmlEncryptedPacket    `xml:"EncryptedPacket"`
+	SpliceNull             *struct{}              `xml:"SpliceNull"`
+	SpliceSchedule         *xmlSpliceSchedule     `xml:"SpliceSchedule"`
+	SpliceInsert           *xmlSpliceInsert       `xml:"SpliceInsert"`
+	TimeSignal             *xmlTimeSignal         `xml:"TimeSignal"`
+	BandwidthReservation   *struct{}              `xml:"BandwidthReservation"`
+	PrivateCommand         *xmlPrivateCommand     `xml:"PrivateCommand"`
+	AvailDescriptor        []xmlAvailDescriptor   `xml:"AvailDescriptor"`
+	DTMFDescriptor         []xmlDtmfDescriptor    `xml:"DTMFDescriptor"`
+	SegmentationDescriptor []xmlSegmentDescriptor `xml:"SegmentationDescriptor"`
+	TimeDescriptor         []xmlTimeDescriptor    `xml:"TimeDescriptor"`
+	AudioDescriptor        []xmlAudioDescriptor   `xml:"AudioDescriptor"`
+}
+
+type xmlEncryptedPacket struct {
+	EncryptionAlgorithm int `xml:"encryptionAlgorithm,attr"`
+	CwIndex             int `xml:"cwIndex,attr"`
+}
+
+type xmlSpliceTime struct {
+	PtsTime *int64 `xml:"ptsTime,attr,omitempty"`
+}
+
+type xmlBreakDuration struct {
+	AutoReturn bool  `xml:"autoReturn,attr"`
+	Duration   int64 `xml:"duration,attr"`
+}
+
+type xmlSpliceInsert struct {
+	SpliceEventId              int64                `xml:"spliceEventId,attr"`
+	SpliceEventCancelIndicator bool                 `xml:"spliceEventCancelIndicator,attr"`
+	OutOfNetworkIndicator      *bool                `xml:"outOfNetworkIndicator,attr,omitempty"`
+	SpliceImmediateFlag        *bool                `xml:"spliceImmediateFlag,attr,omitempty"`
+	UniqueProgramId            *int                 `xml:"uniqueProgramId,attr,omitempty"`
+	AvailNum                   *int                 `xml:"availNum,attr,omitempty"`
+	AvailsExpected             *int                 `xml:"availsExpected,attr,omitempty"`
+	Program                    *xmlInsertProgram    `xml:"Program"`
+	Component                  []xmlInsertComponent `xml:"Component"`
+	BreakDuration              *xmlBreakDuration    `xml:"BreakDuration"`
+}
+
+type xmlInsertProgram struct {
+	SpliceTime *xmlSpliceTime `xml:"SpliceTime"`
+}
+
+type xmlInsertComponent struct {
+	ComponentTag int            `xml:"componentTag,attr"`
+	SpliceTime   *xmlSpliceTime `xml:"SpliceTime"`
+}
+
+type xmlSpliceSchedule struct {
+	Event []xmlScheduleEvent `xml:"Event"`
+}
+
+type xmlScheduleEvent struct {
+	SpliceEventId              int64                  `xml:"spliceEventId,attr"`
+	SpliceEventCancelIndicator bool                   `xml:"spliceEventCancelIndicator,attr"`
+	OutOfNetworkIndicator      *bool                  `xml:"outOfNetworkIndicator,attr,omitempty"`
+	UniqueProgramId            *int                   `xml:"uniqueProgramId,attr,omitempty"`
+	AvailNum                   *int                   `xml:"availNum,attr,omitempty"`
+	AvailsExpected             *int                   `xml:"availsExpected,attr,omitempty"`
+	Program                    *xmlScheduleComponent  `xml:"Program"`
+	Component                  []xmlScheduleComponent `xml:"Component"`
+	BreakDuration              *xmlBreakDuration      `xml:"BreakDuration"`
+}
+
+type xmlScheduleComponent struct {
+	ComponentTag  *int  `xml:"componentTag,attr,omitempty"`
+	UtcSpliceTime int64 `xml:"utcSpliceTime,attr"`
+}
+
+type xmlTimeSignal struct {
+	SpliceTime xmlSpliceTime `xml:"SpliceTime"`
+}
+
+type xmlPrivateCommand struct {
+	Identifier   int64  `xml:"identifier,attr"`
+	PrivateBytes string `xml:"PrivateBytes"`
+}
+
+type xmlAvailDescriptor struct {
+	ProviderAvailId int64 `xml:"providerAvailId,attr"`
+}
+
+type xmlDtmfDescriptor struct {
+	Preroll int    `xml:"preroll,attr"`
+	Chars   string `xml:"chars,attr"`
+}
+
+type xmlSegmentDescriptor struct {
+	SegmentationEventId              int                      `xml:"segmentationEventId,attr"`
+	SegmentationEventCancelIndicator bool                     `xml:"segmentationEventCancelIndicator,attr"`
+	SegmentationDuration             *int64                   `xml:"segmentationDuration,attr,omitempty"`
+	SegmentationTypeId               *int                     `xml:"segmentationTypeId,attr,omitempty"`
+	SegmentNum                       *int                     `xml:"segmentNum,attr,omitempty"`
+	SegmentsExpected                 *int                     `xml:"segmentsExpected,attr,omitempty"`
+	SubSegmentNum                    *int                     `xml:"subSegmentNum,attr,omitempty"`
+	SubSegmentsExpected              *int                     `xml:"subSegmentsExpected,attr,omitempty"`
+	DeliveryRestrictions             *xmlDeliveryRestrictions `xml:"DeliveryRestrictions"`
+	SegmentationUpid                 []xmlSegmentationUpid    `xml:"SegmentationUpid"`
+	Component                        []xmlSegmentComponent    `xml:"Component"`
+}
+
+type xmlDeliveryRestrictions struct {
+	WebDeliveryAllowedFlag bool `xml:"webDeliveryAllowedFlag,attr"`
+	NoRegionalBlackoutFlag bool `xml:"noRegionalBlackoutFlag,attr"`
+	ArchiveAllowedFlag     bool `xml:"archiveAllowedFlag,attr"`
+	DeviceRestrictions     int  `xml:"deviceRestrictions,attr"`
+}
+
+type xmlSegmentationUpid struct {
+	SegmentationUpidType   int    `xml:"segmentationUpidType,attr"`
+	FormatIdentifier       *int64 `xml:"formatIdentifier,attr,omitempty"`
+	SegmentationUpidFormat string `xml:"segmentationUpidFormat,attr"`
+	Value                  string `xml:",chardata"`
+}
+
+type xmlSegmentComponent struct {
+	ComponentTag int   `xml:"componentTag,attr"`
+	PtsOffset    int64 `xml:"ptsOffset,attr"`
+}
+
+type xmlTimeDescriptor struct {
+	TaiSeconds int64 `xml:"taiSeconds,attr"`
+	TaiNs      int64 `xml:"taiNs,attr"`
+	UtcOffset  int   `xml:"utcOffset,attr"`
+}
+
+type xmlAudioDescriptor struct {
+	AudioChannel []xmlAudioChannel `xml:"AudioChannel"`
+}
+
+type xmlAudioChannel struct {
+	ComponentTag  int    `xml:"componentTag,attr"`
+	ISOCode       string `xml:"ISOCode,attr"`
+	BitStreamMode int    `xml:"bitStreamMode,attr"`
+	NumChannels   int    `xml:"numChannels,attr"`
+	FullSrvcAudio bool   `xml:"fullSrvcAudio,attr"`
+}
+
+func newInt(v int) *int {
+	return &v
+}
+
+func newInt64(v int64) *int64 {
+	return &v
+}
+
+func newBool(v int) *bool {
+	b := v != 0
+	return &b
+}
+
+func (t *SpliceTime) xmlSpliceTime() *xmlSpliceTime {
+	x := &xmlSpliceTime{}
+	if t != nil && t.time_specified_flag == 1 {
+		x.PtsTime = newInt64(t.pts_time)
+	}
+	return x
+}
+
+func (d *BreakDuration) xmlBreakDuration() *xmlBreakDuration {
+	if d == nil {
+		return nil
+	}
+	return &xmlBreakDuration{AutoReturn: d.auto_return == 1, Duration: d.duration}
+}
+
+// MarshalXML writes the section following the SCTE-35 XML schema.
+func (section SpliceInfoSection) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	x := xmlSpliceInfoSection{
+		Xmlns:           Scte35XmlNamespace,
+		PtsAdjustment:   section.pts_adjustment,
+		ProtocolVersion: section.protocol_version,
+		SapType:         section.reserved,
+		Tier:            section.tier,
+	}
+	if section.IsEncrypted() {
+		x.EncryptedPacket = &xmlEncryptedPacket{section.encryption_algorithm, section.cw_index}
+	}
+
+	switch {
+	case section.SpliceNull != nil:
+		x.SpliceNull = &struct{}{}
+	case section.BandwidthReservation != nil:
+		x.BandwidthReservation = &struct{}{}
+	case section.SpliceSchedule != nil:
+		x.SpliceSchedule = &xmlSpliceSchedule{}
+		for _, event := range section.SpliceSchedule.Events {
+			xe := xmlScheduleEvent{
+				SpliceEventId:              event.splice_event_id,
+				SpliceEventCancelIndicator: event.splice_event_cancel_indicator == 1,
+			}
+			if event.splice_event_cancel_indicator == 0 {
+				xe.OutOfNetworkIndicator = newBool(event.out_of_network_indicator)
+				xe.UniqueProgramId = newInt(event.unique_program_id)
+				xe.AvailNum = newInt(event.avail_num)
+				xe.AvailsExpected = newInt(event.avails_expected)
+				if event.program_splice_flag == 1 {
+					xe.Program = &xmlScheduleComponent{UtcSpliceTime: event.utc_splice_time}
+				}
+				for _, c := range event.Components {
+					xe.Component = append(xe.Component, xmlScheduleComponent{
+						ComponentTag:  newInt(c.component_tag),
+						UtcSpliceTime: c.utc_splice_time,
+					})
+				}
+				xe.BreakDuration = event.BreakDuration.xmlBreakDuration()
+			}
+			x.SpliceSchedule.Event = append(x.SpliceSchedule.Event, xe)
+		}
+	case section.SpliceInsert != nil:
+		insert := section.SpliceInsert
+		xi := &xmlSpliceInsert{
+			SpliceEventId:              insert.splice_event_id,
+			SpliceEventCancelIndicator: insert.splice_event_cancel_indicator == 1,
+		}
+		if insert.splice_event_cancel_indicator == 0 {
+			xi.OutOfNetworkIndicator = newBool(insert.out_of_network_indicator)
+			xi.SpliceImmediateFlag = newBool(insert.splice_immediate_flag)
+			xi.UniqueProgramId = newInt(insert.unique_program_id)
+			xi.AvailNum = newInt(insert.avail_num)
+			xi.AvailsExpected = newInt(insert.avails_expected)
+			if insert.program_splice_flag == 1 {
+				xi.Program = &xmlInsertProgram{}
+				if insert.splice_immediate_flag == 0 {
+					xi.Program.SpliceTime = insert.SpliceTime.xmlSpliceTime()
+				}
+			}
+			for _, c := range insert.Components {
+				xc := xmlInsertComponent{ComponentTag: c.component_tag}
+				if c.SpliceTime != nil {
+					xc.SpliceTime = c.SpliceTime.xmlSpliceTime()
+				}
+				xi.Component = append(xi.Component, xc)
+			}
+			xi.BreakDuration = insert.BreakDuration.xmlBreakDuration()
+		}
+		x.SpliceInsert = xi
+	case section.TimeSignal != nil:
+		x.TimeSignal = &xmlTimeSignal{SpliceTime: *section.TimeSignal.SpliceTime.xmlSpliceTime()}
+	case section.PrivateCommand != nil:
+		x.PrivateCommand = &xmlPrivateCommand{
+			Identifier:   section.PrivateCommand.identifier,
+			PrivateBytes: hex.EncodeToString(section.PrivateCommand.private_byte),
+		}
+	}
+
+	for _, d := range section.SpliceDescriptorList {
+		switch {
+		case d.AvailDescriptor != nil:
+			x.AvailDescriptor = append(x.AvailDescriptor, xmlAvailDescriptor{d.AvailDescriptor.provider_avail_id})
+		case d.DtmfDescriptor != nil:
+			x.DTMFDescriptor = append(x.DTMFDescriptor, xmlDtmfDescriptor{d.DtmfDescriptor.preroll, d.DtmfDescriptor.DTMF_char})
+		case d.SegmentDescriptor != nil:
+			x.SegmentationDescriptor = append(x.SegmentationDescriptor, d.SegmentDescriptor.xmlSegmentDescriptor())
+		case d.TimeDescriptor != nil:
+			t := d.TimeDescriptor
+			x.TimeDescriptor = append(x.TimeDescriptor, xmlTimeDescriptor{t.TAI_seconds, t.TAI_ns, t.UTC_offset})
+		case d.AudioDescriptor != nil:
+			xa := xmlAudioDescriptor{}
+			for _, c := range d.AudioDescriptor.Components {
+				xa.AudioChannel = append(xa.AudioChannel, xmlAudioChannel{
+					c.component_tag, c.ISO_code, c.Bit_Stream_Mode, c.Num_Channels, c.Full_Srvc_Audio == 1,
+				})
+			}
+			x.AudioDescriptor = append(x.AudioDescriptor, xa)
+		}
+	}
+
+	return e.Encode(x)
+}
+
+func (segment *SegmentDescriptor) xmlSegmentDescriptor() xmlSegmentDescriptor {
+	x := xmlSegmentDescriptor{
+		SegmentationEventId:              segment.segmentation_event_id,
+		SegmentationEventCancelIndicator: segment.segmentation_event_cancel_indicator == 1,
+	}
+	if segment.segmentation_event_cancel_indicator == 1 {
+		return x
+	}
+	if segment.segmentation_duration_flag == 1 {
+		x.SegmentationDuration = newInt64(segment.segmentation_duration)
+	}
+	x.SegmentationTypeId = newInt(segment.segmentation_type_id)
+	x.SegmentNum = newInt(segment.segment_num)
+	x.SegmentsExpected = newInt(segment.segments_expected)
+	if segment.sub_segment_present {
+		x.SubSegmentNum = newInt(segment.sub_segment_num)
+		x.SubSegmentsExpected = newInt(segment.sub_segments_expected)
+	}
+	if segment.delivery_not_restricted_flag == 0 {
+		x.DeliveryRestrictions = &xmlDeliveryRestrictions{
+			WebDeliveryAllowedFlag: segment.web_delivery_allowed_flag == 1,
+			NoRegionalBlackoutFlag: segment.no_regional_blackout_flag == 1,
+			ArchiveAllowedFlag:     segment.archive_allowed_flag == 1,
+			DeviceRestrictions:     segment.device_restrictions,
+		}
+	}
+	if segment.Upid != nil {
+		if segment.Upid.segmentation_upid_type == 0x0D {
+			for _, u := range segment.Upid.Upids {
+				x.SegmentationUpid = append(x.SegmentationUpid, u.xmlSegmentationUpid())
+			}
+		} else {
+			x.SegmentationUpid = append(x.SegmentationUpid, segment.Upid.xmlSegmentationUpid())
+		}
+	}
+	if segment.program_segmentation_flag == 0 {
+		for _, c := range segment.Components {
+			x.Component = append(x.Component, xmlSegmentComponent{c.component_tag, c.pts_offset})
+		}
+	}
+	return x
+}
+
+func (upid *SegmentationUpid) xmlSegmentationUpid() xmlSegmentationUpid {
+	x := xmlSegmentationUpid{SegmentationUpidType: upid.segmentation_upid_type}
+	switch upid.segmentation_upid_type {
+	case 0x02, 0x03, 0x07, 0x09, 0x0E, 0x0F, 0x11:
+		x.SegmentationUpidFormat = "text"
+		x.Value = string(upid.upid)
+	case 0x0C:
+		x.FormatIdentifier = newInt64(upid.format_identifier)
+		x.SegmentationUpidFormat = "hexbinary"
+		x.Value = hex.EncodeToString(upid.private_data)
+	default:
+		x.SegmentationUpidFormat = "hexbinary"
+		x.Value = hex.EncodeToString(upid.upid)
+	}
+	return x
+}
@@ -0,0 +1,118 @@
+package mpts
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// MinSplicePreroll is the pre-roll in seconds below which a cue is flagged,
+// 4 seconds as recommended by SCTE 67.
+var MinSplicePreroll float64 = 4.0
+
+const (
+	PrerollOk        = "ok"
+	PrerollShort     = "short"
+	PrerollPast      = "past"
+	PrerollImmediate = "immediate"
+	PrerollNoPcr     = "no_pcr"
+)
+
+// SplicePreroll is the time between the arrival of a cue and its splice
+// point. Arrival is taken from the last PCR before the section, so the
+// pre-roll may be overestimated by up to one PCR interval.
+type SplicePreroll struct {
+	Pos        int64
+	Pcr        int64
+	SpliceTime int64
+	Preroll    int64
+	Repeat     int
+	Status     string
+}
+
+// GetSplicePreroll computes the pre-roll, in 90kHz, of a section received
+// when the PCR was pcr.
+func GetSplicePreroll(section *SpliceInfoSection, pcr int64) (spliceTime int64, preroll int64, status string) {
+	pts, adj := section.GetSpliceTime()
+	spliceTime = adjustPts(pts, adj)
+	if spliceTime == noTime {
+		return noTime, 0, PrerollImmediate
+	}
+	if pcr <= 0 {
+		return spliceTime, 0, PrerollNoPcr
+	}
+
+	// Signed difference on the 33-bit PTS clock
+	preroll = (spliceTime - pcr/300) & ptsMask
+	if preroll > ptsMask/2 {
+		preroll -= ptsMask + 1
+	}
+	switch {
+	case preroll < 0:
+		status = PrerollPast
+	case float64(preroll) < MinSplicePreroll*90000:
+		status = PrerollShort
+	default:
+		status = PrerollOk
+	}
+	return
+}
+
+// Preroll checks every section carrying a splice time, and counts how many
+// times each cue was sent before.
+func (s *Scte35Record) Preroll() []SplicePreroll {
+	var results []SplicePreroll
+	seen := make(map[string]int)
+	for i, section := range s.Sections {
+		if section.opaque() {
+			continue
+		}
+		if section.SpliceInsert == nil && section.TimeSignal == nil {
+			continue
+		}
+		cue := string(section.Bytes())
+		repeat := seen[cue]
+		seen[cue] += 1
+
+		spliceTime, preroll, status := GetSplicePreroll(section, s.PcrTime[i])
+		results = append(results, SplicePreroll{
+			Pos:        s.BytePos[i],
+			Pcr:        s.PcrTime[i] / 300,
+			SpliceTime: spliceTime,
+			Preroll:    preroll,
+			Repeat:     repeat,
+			Status:     status,
+		})
+	}
+	return results
+}
+
+func (s *Scte35Record) reportPreroll(root string) {
+	results := s.Preroll()
+	if len(results) == 0 {
+		return
+	}
+
+	fname := filepath.Join(root, strconv.Itoa(s.Pid)+"-preroll.csv")
+	w, err := os.Create(fname)
+	if err != nil {
+		panic(err)
+	}
+	defer w.Close()
+
+	fmt.Fprintln(w, "Pos, PCR, SpliceTime, Preroll, Seconds, Repeat, Status")
+	for _, p := range results {
+		cols := []string{
+			strconv.FormatInt(p.Pos, 10),
+			strconv.FormatInt(p.Pcr, 10),
+			strconv.FormatInt(p.SpliceTime, 10),
+			strconv.FormatInt(p.Preroll, 10),
+			strconv.FormatFloat(float64(p.Preroll)/90000, 'f', 3, 64),
+			strconv.Itoa(p.Repeat),
+			p.Status,
+		}
+		fmt.Fprintln(w, strings.Join(cols, ", "))
+	}
+}
@@ -0,0 +1,173 @@
+package mpts
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// SCTE-35 Table 22
+var SegmentationUpidTypeString map[int]string = map[int]string{
+	0x00: "Not Used",
+	0x01: "User Defined",
+	0x02: "ISCI",
+	0x03: "Ad-ID",
+	0x04: "UMID",
+	0x05: "ISAN (deprecated)",
+	0x06: "ISAN",
+	0x07: "TID",
+	0x08: "TI",
+	0x09: "ADI",
+	0x0A: "EIDR",
+	0x0B: "ATSC Content Identifier",
+	0x0C: "MPU",
+	0x0D: "MID",
+	0x0E: "ADS Information",
+	0x0F: "URI",
+	0x10: "UUID",
+	0x11: "SCR",
+}
+
+type SegmentationUpid struct {
+	segmentation_upid_type   int
+	segmentation_upid_length int
+	upid                     []byte
+	// MPU()
+	format_identifier int64
+	private_data      []byte
+	// ATSC content_identifier()
+	TSID       int
+	end_of_day int
+	unique_for int
+	content_id []byte
+	// MID()
+	Upids []*SegmentationUpid
+}
+
+func ParseSegmentationUpid(upidType int, data []byte) *SegmentationUpid {
+	upid := &SegmentationUpid{}
+	upid.segmentation_upid_type = upidType
+	upid.segmentation_upid_length = len(data)
+	upid.upid = data
+	r := NewReader(data)
+	switch upidType {
+	case 0x0B:
+		if len(data) >= 4 {
+			upid.TSID = r.ReadBit(16)
+			r.SkipBit(2)
+			upid.end_of_day = r.ReadBit(5)
+			upid.unique_for = r.ReadBit(9)
+			upid.content_id = data[4:]
+		}
+	case 0x0C:
+		if len(data) >= 4 {
+			upid.format_identifier = r.ReadBit64(32)
+			upid.private_data = data[4:]
+		}
+	case 0x0D:
+		for r.Base+2 <= len(data) {
+			t := r.ReadBit(8)
+			n := r.ReadBit(8)
+			if r.Base+n > len(data) {
+				break
+			}
+			upid.Upids = append(upid.Upids, ParseSegmentationUpid(t, data[r.Base:r.Base+n]))
+			r.SkipByte(n)
+		}
+	}
+	return upid
+}
+
+func (upid SegmentationUpid) GetType() int {
+	return upid.segmentation_upid_type
+}
+
+func (upid SegmentationUpid) GetTypeName() string {
+	if typeString, ok := SegmentationUpidTypeString[upid.segmentation_upid_type]; ok {
+		return typeString
+	} else {
+		return "Reserved"
+	}
+}
+
+func (upid SegmentationUpid) GetBytes() []byte {
+	return upid.upid
+}
+
+// GetFormatIdentifier returns the format_identifier of an MPU as text.
+func (upid SegmentationUpid) GetFormatIdentifier() string {
+	id := upid.format_identifier
+	return string([]byte{byte(id >> 24), byte(id >> 16), byte(id >> 8), byte(id)})
+}
+
+// String formats the UPID the way it is usually written for its type.
+func (upid SegmentationUpid) String() string {
+	data := upid.upid
+	switch upid.segmentation_upid_type {
+	case 0x00:
+		return ""
+	case 0x02, 0x03, 0x07, 0x09, 0x0E, 0x0F, 0x11:
+		return string(data)
+	case 0x05, 0x06:
+		return groupHex(data, 4, "-")
+	case 0x08:
+		return "0x" + hex.EncodeToString(data)
+	case 0x0A:
+		return formatEidr(data)
+	case 0x0B:
+		return fmt.Sprintf("TSID=%d,end_of_day=%d,unique_for=%d,content_id=%s",
+			upid.TSID, upid.end_of_day, upid.unique_for, hex.EncodeToString(upid.content_id))
+	case 0x0C:
+		return upid.GetFormatIdentifier() + ":" + hex.EncodeToString(upid.private_data)
+	case 0x0D:
+		var upids []string
+		for _, u := range upid.Upids {
+			upids = append(upids, u.GetTypeName()+"="+u.String())
+		}
+		return strings.Join(upids, ";")
+	case 0x10:
+		if len(data) == 16 {
+			h := hex.EncodeToString(data)
+			return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
+		}
+	}
+	return hex.EncodeToString(data)
+}
+
+func groupHex(data []byte, n int, sep string) string {
+	h := strings.ToUpper(hex.EncodeToString(data))
+	var groups []string
+	for len(h) > n {
+		groups = append(groups, h[:n])
+		h = h[n:]
+	}
+	groups = append(groups, h)
+	return strings.Join(groups, sep)
+}
+
+// formatEidr formats the compact binary EIDR as 10.<sub-prefix>/<suffix>-<check>.
+func formatEidr(data []byte) string {
+	if len(data) != 12 {
+		return hex.EncodeToString(data)
+	}
+	prefix := int(data[0])<<8 | int(data[1])
+	suffix := groupHex(data[2:], 4, "-")
+	return fmt.Sprintf("10.%d/%s-%c", prefix, suffix, eidrCheckChar(strings.ReplaceAll(suffix, "-", "")))
+}
+
+// eidrCheckChar computes the ISO 7064 Mod 37,36 check character of an
+// EIDR suffix.
+func eidrCheckChar(s string) byte {
+	const alphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	const m = 36
+	p := m
+	for _, c := range s {
+		v := strings.IndexRune(alphabet, c)
+		p = (p + v) % m
+		if p == 0 {
+			p = m
+		}
+		p = (p * 2) % (m + 1)
+	}
+	return alphabet[(m+1-p)%m]
+}
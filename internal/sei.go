@@ -0,0 +1,65 @@
+package mpts
+
+// SeiMessage is one sei_message() of an H.264 or HEVC SEI NAL unit.
+type SeiMessage struct {
+	PayloadType int
+	Payload     []byte
+}
+
+// ParseSeiMessages splits the RBSP of an SEI NAL unit, whose NAL header is
+// headerLen bytes long, into its messages.
+func ParseSeiMessages(rbsp []byte, headerLen int) []SeiMessage {
+	var msgs []SeiMessage
+	pos := headerLen
+	// The rbsp_trailing_bits take the last byte
+	for pos < len(rbsp)-1 {
+		payloadType := 0
+		for pos < len(rbsp) && rbsp[pos] == 0xFF {
+			payloadType += 255
+			pos++
+		}
+		if pos >= len(rbsp) {
+			break
+		}
+		payloadType += int(rbsp[pos])
+		pos++
+		payloadSize := 0
+		for pos < len(rbsp) && rbsp[pos] == 0xFF {
+			payloadSize += 255
+			pos++
+		}
+		if pos >= len(rbsp) {
+			break
+		}
+		payloadSize += int(rbsp[pos])
+		pos++
+		if pos+payloadSize > len(rbsp) {
+			break
+		}
+		msgs = append(msgs, SeiMessage{payloadType, rbsp[pos : pos+payloadSize]})
+		pos += payloadSize
+	}
+	return msgs
+}
+
+// SeiInfo is a decoded SEI message of an access unit. Only the field
+// matching PayloadType is set; messages not decoded keep their payload.
+type SeiInfo struct {
+	Pos             int64
+	Pts             int64
+	PayloadType     int
+	Name            string
+	BufferingPeriod *BufferingPeriod      `json:",omitempty"`
+	PicTiming       *PicTiming            `json:",omitempty"`
+	RecoveryPoint   *RecoveryPoint        `json:",omitempty"`
+	Registered      *ItuT35               `json:",omitempty"`
+	Unregistered    *UserDataUnregistered `json:",omitempty"`
+	FramePacking    *FramePacking         `json:",omitempty"`
+	// HEVC only
+	Suffix              bool                          `json:",omitempty"`
+	TimeCode            []ClockTimestamp              `json:",omitempty"`
+	MasteringDisplay    *MasteringDisplayColourVolume `json:",omitempty"`
+	ContentLightLevel   *ContentLightLevel            `json:",omitempty"`
+	AlternativeTransfer *AlternativeTransfer          `json:",omitempty"`
+	Payload             string                        `json:",omitempty"`
+}
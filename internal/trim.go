@@ -0,0 +1,231 @@
+package mpts
+
+import (
+	"bytes"
+	"io"
+	"sort"
+)
+
+const (
+	TrimUnitPacket = "pkt"
+	TrimUnitByte   = "byte"
+	TrimUnitPcr    = "pcr"
+	TrimUnitPts    = "pts"
+)
+
+// TrimOptions gives the cut points. For TrimUnitPcr and TrimUnitPts, Start
+// and End are seconds from the first PCR or PTS; a negative End keeps the
+// rest of the file. Pid is the PTS PID, or the PCR PID if not 0.
+type TrimOptions struct {
+	Unit  string
+	Start float64
+	End   float64
+	Pid   int
+}
+
+// FindTrimRange returns the packet index range [start, end) to keep. The
+// start is moved back to the preceding random access point of the first
+// video PID; end is -1 when the range runs to the end of the file.
+func FindTrimRange(fname string, psi *PsiParser, opt TrimOptions) (start, end int64) {
+	streams := psi.GetStreams()
+	var videoPids []int
+	for pid, s := range streams {
+		if IsVideoStreamType(s.StreamType) {
+			videoPids = append(videoPids, pid)
+		}
+	}
+	sort.Ints(videoPids)
+	rapPid := -1
+	if opt.Unit == TrimUnitPts && IsVideoStreamType(streams[opt.Pid].StreamType) {
+		rapPid = opt.Pid
+	} else if len(videoPids) > 0 {
+		rapPid = videoPids[0]
+	}
+
+	var prevPcr, prevPts, elapsed int64 = -1, -1, 0
+	var value float64 = -1
+	var lastRap int64 = -1
+	start, end = -1, -1
+	for pkt := range ParseFile(fname) {
+		if pkt.Pid == rapPid && isRandomAccess(pkt, streams[rapPid].StreamType) {
+			lastRap = pkt.Pos
+		}
+
+		switch opt.Unit {
+		case TrimUnitPacket:
+			value = float64(pkt.Pos)
+		case TrimUnitByte:
+			value = float64(pkt.Pos * TSPacketSize)
+		case TrimUnitPcr:
+			if pcr, ok := pkt.PCR(); ok && (opt.Pid == 0 || pkt.Pid == opt.Pid) {
+				// Accumulate the steps so that the wrap of the base is crossed
+				if prevPcr >= 0 {
+					elapsed += pcrDiff(pcr, prevPcr)
+				}
+				prevPcr = pcr
+				value = float64(elapsed) / 27000000
+			}
+		case TrimUnitPts:
+			if pkt.Pid == opt.Pid && pkt.PUSI == 1 && hasPesStartCode(pkt.Data) {
+				p := &PesPkt{}
+				p.Read(pkt.Data)
+				// Accumulate the steps so that the 2^33 wrap is crossed
+				if prevPts >= 0 {
+					elapsed += ptsDiff(p.Pts, prevPts)
+				}
+				prevPts = p.Pts
+				value = float64(elapsed) / 90000
+			}
+		}
+
+		if value < 0 {
+			continue
+		}
+		if start < 0 && value >= opt.Start {
+			start = pkt.Pos
+			if rapPid >= 0 && lastRap >= 0 {
+				start = lastRap
+			}
+		}
+		if start >= 0 && opt.End >= 0 && value > opt.End {
+			end = pkt.Pos
+			break
+		}
+	}
+	if start < 0 {
+		start = 0
+	}
+	return
+}
+
+func hasPesStartCode(data []byte) bool {
+	var startcode = []byte{0, 0, 1}
+	return len(data) >= minPesHeaderLen && bytes.Compare(startcode, data[0:3]) == 0
+}
+
+// isRandomAccess reports whether pkt starts a random access point, either
+// signalled by random_access_indicator or found in the start of the PES.
+func isRandomAccess(pkt *TsPkt, t string) bool {
+	if pkt.AdaptField != nil && pkt.AdaptField.RandomAccess {
+		return true
+	}
+	if pkt.PUSI != 1 || !hasPesStartCode(pkt.Data) {
+		return false
+	}
+	p := &PesPkt{}
+	hlen := p.Read(pkt.Data)
+	if hlen > len(pkt.Data) {
+		return false
+	}
+	es := pkt.Data[hlen:]
+	switch t {
+	case "MPEG-4 AVC Video":
+		for _, nal := range ParseNalUnits(es) {
+			if nal == "slice_idr" || nal == "seq_param_set" {
+				return true
+			}
+		}
+	case "HEVC Video":
+		for _, nal := range ParseHevcNalUnits(es) {
+			switch nal {
+			case "bla_w_lp", "bla_w_radl", "bla_n_lp", "idr_w_radl", "idr_n_lp", "cra_nut", "vps_nut", "sps_nut":
+				return true
+			}
+		}
+	case "MPEG-1 Video", "MPEG-2 Video":
+		return bytes.Contains(es, []byte{0, 0, 1, 0xB3}) || bytes.Contains(es, []byte{0, 0, 1, 0xB8})
+	}
+	return false
+}
+
+// Trim writes packets [start, end) of fname, preceded by the PAT and PMTs.
+// The first packet of every PID carries discontinuity_indicator, and the
+// continuity counters of PSI PIDs are renumbered after the inserted tables.
+func Trim(fname string, psi *PsiParser, start, end int64, out io.Writer) {
+	t := &trimmer{
+		Out:  out,
+		psi:  map[int]bool{0: true},
+		cc:   make(map[int]int),
+		seen: make(map[int]bool),
+	}
+
+	if psi.Pat != nil {
+		t.writeSection(0, psi.Pat.Section())
+	}
+	var pmtPids []int
+	for pmtPid, _ := range psi.Programs() {
+		if psi.Pmts[pmtPid] != nil {
+			pmtPids = append(pmtPids, pmtPid)
+		}
+	}
+	sort.Ints(pmtPids)
+	for _, pmtPid := range pmtPids {
+		t.psi[pmtPid] = true
+		t.writeSection(pmtPid, psi.Pmts[pmtPid].Section())
+	}
+
+	for pkt := range ParseFile(fname) {
+		if pkt.Pos < start {
+			continue
+		}
+		if end >= 0 && pkt.Pos >= end {
+			break
+		}
+		buf := append([]byte{}, pkt.Raw...)
+		if t.psi[pkt.Pid] && pkt.HasPayload() {
+			t.cc[pkt.Pid] = (t.cc[pkt.Pid] + 1) & 0x0F
+			SetCC(buf, t.cc[pkt.Pid])
+		}
+		t.write(pkt.Pid, buf)
+	}
+}
+
+type trimmer struct {
+	Out  io.Writer
+	psi  map[int]bool
+	cc   map[int]int
+	seen map[int]bool
+}
+
+func (t *trimmer) writeSection(pid int, section []byte) {
+	cc := t.cc[pid]
+	for _, buf := range PacketizeSection(pid, section, &cc) {
+		t.write(pid, buf)
+	}
+	t.cc[pid] = cc
+}
+
+func (t *trimmer) write(pid int, buf []byte) {
+	if !t.seen[pid] {
+		t.seen[pid] = true
+		afctrl := (buf[3] >> 4) & 0x03
+		if (afctrl == 2 || afctrl == 3) && buf[4] > 0 {
+			buf[5] |= 0x80
+		} else {
+			t.Out.Write(DiscontinuityPacket(buf))
+		}
+	}
+	t.Out.Write(buf)
+}
+
+// DiscontinuityPacket returns an adaptation-field-only packet with
+// discontinuity_indicator set, to be sent right before buf on its PID.
+func DiscontinuityPacket(buf []byte) []byte {
+	af := make([]byte, TSPacketSize)
+	af[0] = 0x47
+	af[1] = buf[1] & 0x1F
+	af[2] = buf[2]
+	cc := int(buf[3] & 0x0F)
+	afctrl := (buf[3] >> 4) & 0x03
+	if afctrl == 1 || afctrl == 3 {
+		// The counter does not increment on packets without payload
+		cc = (cc - 1) & 0x0F
+	}
+	af[3] = 0x20 | byte(cc)
+	af[4] = TSPacketSize - 5
+	af[5] = 0x80
+	for i := 6; i < TSPacketSize; i++ {
+		af[i] = 0xFF
+	}
+	return af
+}
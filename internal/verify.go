@@ -0,0 +1,123 @@
+package mpts
+
+// SpliceCheck is the I-frame nearest to a splice point. Offset is the splice
+// time minus the I-frame PTS in 90kHz, and the splice is accurate when the
+// offset is within Tolerance and the I-frame is a key frame: an IDR, BLA or
+// closed GOP I picture. Recovery points are not I-frames.
+type SpliceCheck struct {
+	Pos          int64
+	Command      string
+	SpliceTime   int64
+	IFramePos    int64
+	IFramePts    int64
+	Offset       int64
+	OffsetFrames float64
+	Tolerance    int64
+	Key          bool
+	Accurate     bool
+}
+
+// SpliceTolerance is the largest offset, in 90kHz, accepted between a splice
+// point and its I-frame. A negative value means half a frame duration.
+var SpliceTolerance int64 = -1
+
+type iframeRecord interface {
+	GetIFrames() []IFrameInfo
+}
+
+// frameDuration returns the frame duration of a video record from the
+// timing of its access units, or 0.
+func frameDuration(video Record) int64 {
+	var t *AuTimer
+	switch r := video.(type) {
+	case *H264Record:
+		t = &r.au
+	case *H265Record:
+		t = &r.au
+	case *Mp2vRecord:
+		t = &r.au
+	default:
+		return 0
+	}
+	t.Close()
+	d, _ := t.frameDuration()
+	return d
+}
+
+// ptsDiff returns a - b on the 33-bit PTS clock.
+func ptsDiff(a, b int64) int64 {
+	d := (a - b) & ptsMask
+	if d > ptsMask/2 {
+		d -= ptsMask + 1
+	}
+	return d
+}
+
+// pcrDiff returns a - b on the 27 MHz PCR clock, which wraps with its 33-bit
+// base.
+func pcrDiff(a, b int64) int64 {
+	const period = (ptsMask + 1) * 300
+	d := ((a-b)%period + period) % period
+	if d > period/2 {
+		d -= period
+	}
+	return d
+}
+
+// VerifySplices matches every splice point signalled on scte to the
+// nearest I-frame of video.
+func VerifySplices(scte *Scte35Record, video Record) []SpliceCheck {
+	var iframes []IFrameInfo
+	if r, ok := video.(iframeRecord); ok {
+		iframes = r.GetIFrames()
+	}
+	frame := frameDuration(video)
+	tolerance := SpliceTolerance
+	if tolerance < 0 {
+		tolerance = frame / 2
+	}
+
+	var checks []SpliceCheck
+	for i, section := range scte.Sections {
+		pts, adj := section.GetSpliceTime()
+		spliceTime := adjustPts(pts, adj)
+		if spliceTime == noTime {
+			continue
+		}
+		check := SpliceCheck{
+			Pos:        scte.BytePos[i],
+			Command:    section.GetSpliceType(),
+			SpliceTime: spliceTime,
+			IFramePos:  -1,
+			IFramePts:  -1,
+			Tolerance:  tolerance,
+		}
+		for _, iframe := range iframes {
+			if iframe.Type == "RecoveryPoint" || iframe.Pts < 0 {
+				continue
+			}
+			offset := ptsDiff(spliceTime, iframe.Pts)
+			if check.IFramePos < 0 || abs64(offset) < abs64(check.Offset) {
+				check.IFramePos = iframe.Pos
+				check.IFramePts = iframe.Pts
+				check.Offset = offset
+				check.Key = iframe.Key
+			}
+		}
+		if check.IFramePos >= 0 {
+			if frame > 0 {
+				check.OffsetFrames = float64(check.Offset) / float64(frame)
+			}
+			check.Accurate = check.Key && abs64(check.Offset) <= tolerance
+		}
+		checks = append(checks, check)
+	}
+	return checks
+}
+
+func abs64(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}